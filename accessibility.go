@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// announcer announces text, e.g. by printing or speaking it
+//
+type announcer interface {
+	announce(text string) error
+	close() error
+}
+
+// lineAnnouncer announces text by writing it as a line
+//
+type lineAnnouncer struct {
+	writer io.Writer
+}
+
+func (a lineAnnouncer) announce(text string) error {
+	_, err := fmt.Fprintln(a.writer, text)
+	return err
+}
+
+func (a lineAnnouncer) close() error {
+	return nil
+}
+
+// speechDispatcher announces text by speaking it through speech-dispatcher,
+// using the Speech Synthesis Interface Protocol (SSIP) over its local socket
+//
+type speechDispatcher struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// speechDispatcherSocketPath returns the path of speech-dispatcher's default socket
+//
+func speechDispatcherSocketPath() string {
+	if address, ok := os.LookupEnv("SPEECHD_ADDRESS"); ok {
+		const prefix = "unix_socket:"
+		if strings.HasPrefix(address, prefix) {
+			return strings.TrimPrefix(address, prefix)
+		}
+	}
+
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = filepath.Join(os.TempDir(), fmt.Sprintf("runtime-%d", os.Getuid()))
+	}
+
+	return filepath.Join(runtimeDir, "speech-dispatcher", "speechd.sock")
+}
+
+func newSpeechDispatcher(path string) (*speechDispatcher, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &speechDispatcher{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	err = s.command("SET self CLIENT_NAME user:g0m8:main")
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// command sends the given SSIP command and checks the reply
+//
+func (s *speechDispatcher) command(command string) error {
+	_, err := fmt.Fprintf(s.conn, "%s\r\n", command)
+	if err != nil {
+		return err
+	}
+
+	return s.reply()
+}
+
+// reply reads a reply, which consists of lines starting with a three digit code.
+// The last line has a space after the code, all others have a dash
+//
+func (s *speechDispatcher) reply() error {
+	for {
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 4 {
+			return fmt.Errorf("invalid speech-dispatcher reply: %q", line)
+		}
+
+		if line[3] == '-' {
+			continue
+		}
+
+		// 2xx codes indicate success
+		if line[0] != '2' {
+			return fmt.Errorf("speech-dispatcher error: %s", line)
+		}
+
+		return nil
+	}
+}
+
+func (s *speechDispatcher) announce(text string) error {
+	// Stop speaking outdated announcements
+	err := s.command("CANCEL self")
+	if err != nil {
+		return err
+	}
+
+	err = s.command("SPEAK")
+	if err != nil {
+		return err
+	}
+
+	// Lines starting with a dot need to be escaped with another dot,
+	// a line with only a dot ends the message
+	var message strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, ".") {
+			message.WriteString(".")
+		}
+		message.WriteString(line)
+		message.WriteString("\r\n")
+	}
+	message.WriteString(".")
+
+	return s.command(message.String())
+}
+
+func (s *speechDispatcher) close() error {
+	_, _ = fmt.Fprint(s.conn, "QUIT\r\n")
+	return s.conn.Close()
+}
+
+// accessibilityState is what is announced
+//
+type accessibilityState struct {
+	view   string
+	focus  bool
+	row    int
+	column int
+	label  string
+	value  string
+}
+
+// accessibility announces the current view, and the focused field and its value,
+// when they change and stay unchanged for the debounce duration,
+// so the constant redraws of the screen do not flood the announcements
+//
+type accessibility struct {
+	announcer announcer
+	debounce  time.Duration
+	announced accessibilityState
+	pending   accessibilityState
+	changed   time.Time
+}
+
+func newAccessibility(announcer announcer, debounce time.Duration) *accessibility {
+	return &accessibility{
+		announcer: announcer,
+		debounce:  debounce,
+	}
+}
+
+// update determines the state of the given screen,
+// and announces it if it changed and is stable
+//
+func (a *accessibility) update(screen *textScreen, now time.Time) error {
+	state := accessibilityStateOf(screen)
+	if state != a.pending {
+		a.pending = state
+		a.changed = now
+	}
+
+	return a.flush(now)
+}
+
+// flush announces the pending state if it is stable
+//
+func (a *accessibility) flush(now time.Time) error {
+	if a.pending == a.announced || now.Sub(a.changed) < a.debounce {
+		return nil
+	}
+
+	text := a.announcement(a.pending)
+	a.announced = a.pending
+	if text == "" {
+		return nil
+	}
+
+	return a.announcer.announce(text)
+}
+
+// announcement returns the text announcing the given state,
+// only including what changed since the last announcement
+//
+func (a *accessibility) announcement(state accessibilityState) string {
+	var parts []string
+
+	viewChanged := state.view != a.announced.view
+	if viewChanged && state.view != "" {
+		parts = append(parts, state.view)
+	}
+
+	if state.focus {
+		sameField := !viewChanged &&
+			a.announced.focus &&
+			state.row == a.announced.row &&
+			state.column == a.announced.column &&
+			state.label == a.announced.label
+
+		if sameField || state.label == "" {
+			parts = append(parts, state.value)
+		} else {
+			parts = append(parts, fmt.Sprintf("%s: %s", state.label, state.value))
+		}
+	}
+
+	return strings.Join(parts, ". ")
+}
+
+// accessibilityStateOf determines the view, and the focused field and its value, of the given screen.
+//
+// The view name is the first column of text in the first non-empty row.
+//
+// The focused field is the first run of highlighted cells containing text,
+// i.e. cells which have a background different from the screen's background.
+// Its label is the column of text to the left of it in the same row.
+//
+func accessibilityStateOf(screen *textScreen) accessibilityState {
+	var state accessibilityState
+
+	for row := 0; row < textRows; row++ {
+		line := strings.TrimSpace(screen.line(row))
+		if line != "" {
+			state.view = firstTextColumn(line)
+			break
+		}
+	}
+
+	for row := 0; row < textRows; row++ {
+		line := []rune(screen.line(row) + strings.Repeat(" ", textColumns))
+
+		start := -1
+		for column := 0; column <= textColumns; column++ {
+			highlighted := column < textColumns &&
+				screen.background(row, column) != screen.backgroundColor
+
+			if highlighted {
+				if start < 0 {
+					start = column
+				}
+				continue
+			}
+
+			if start < 0 {
+				continue
+			}
+
+			// Ignore highlighted runs without text, e.g. decorations
+
+			value := strings.TrimSpace(string(line[start:column]))
+			if value != "" {
+				state.focus = true
+				state.row = row
+				state.column = start
+				state.value = value
+				state.label = lastTextColumn(strings.TrimSpace(string(line[:start])))
+				return state
+			}
+
+			start = -1
+		}
+	}
+
+	return state
+}
+
+// textColumnSeparator separates columns of text
+const textColumnSeparator = "  "
+
+func firstTextColumn(line string) string {
+	if index := strings.Index(line, textColumnSeparator); index >= 0 {
+		return line[:index]
+	}
+	return line
+}
+
+func lastTextColumn(line string) string {
+	if index := strings.LastIndex(line, textColumnSeparator); index >= 0 {
+		return strings.TrimSpace(line[index:])
+	}
+	return line
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testAnnouncer struct {
+	announcements []string
+}
+
+func (a *testAnnouncer) announce(text string) error {
+	a.announcements = append(a.announcements, text)
+	return nil
+}
+
+func (a *testAnnouncer) close() error {
+	return nil
+}
+
+var testBackgroundColor = Color{}
+var testHighlightColor = Color{r: 0x00, g: 0x80, b: 0xff}
+
+func newTestAccessibilityScreen() *textScreen {
+	screen := newTextScreen()
+	screen.update(DrawRectangleCommand{
+		size: Size{
+			width:  screenWidth,
+			height: screenHeight,
+		},
+		color: testBackgroundColor,
+	})
+	drawText(screen, 0, 0, "INSTRUMENT 00")
+	drawText(screen, 0, 20, "VOLUME  80")
+	drawText(screen, 0, 30, "PAN     40")
+	return screen
+}
+
+// highlight draws a highlight behind the given number of characters,
+// drawn at the given position
+//
+func highlight(screen *textScreen, x, y int16, length int) {
+	screen.update(DrawRectangleCommand{
+		pos: Position{
+			x: x - 1,
+			y: y + 2,
+		},
+		size: Size{
+			width:  int16(length * fontCharWidth),
+			height: fontCharHeight + 1,
+		},
+		color: testHighlightColor,
+	})
+}
+
+func TestAccessibilityStateOf(t *testing.T) {
+
+	t.Run("no focus", func(t *testing.T) {
+		screen := newTestAccessibilityScreen()
+
+		require.Equal(t,
+			accessibilityState{
+				view: "INSTRUMENT 00",
+			},
+			accessibilityStateOf(screen),
+		)
+	})
+
+	t.Run("focus", func(t *testing.T) {
+		screen := newTestAccessibilityScreen()
+		highlight(screen, 64, 20, 2)
+		drawText(screen, 64, 20, "80")
+
+		require.Equal(t,
+			accessibilityState{
+				view:   "INSTRUMENT 00",
+				focus:  true,
+				row:    2,
+				column: 8,
+				label:  "VOLUME",
+				value:  "80",
+			},
+			accessibilityStateOf(screen),
+		)
+	})
+
+	t.Run("highlight without text", func(t *testing.T) {
+		screen := newTestAccessibilityScreen()
+		highlight(screen, 0, 100, 4)
+
+		require.False(t, accessibilityStateOf(screen).focus)
+	})
+}
+
+func TestAccessibility(t *testing.T) {
+
+	const debounce = 100 * time.Millisecond
+
+	start := time.Now()
+
+	announcer := &testAnnouncer{}
+	access := newAccessibility(announcer, debounce)
+
+	screen := newTestAccessibilityScreen()
+	highlight(screen, 64, 20, 2)
+	drawText(screen, 64, 20, "80")
+
+	// Not announced until stable
+
+	require.NoError(t, access.update(screen, start))
+	require.Empty(t, announcer.announcements)
+
+	require.NoError(t, access.flush(start.Add(debounce)))
+	require.Equal(t, []string{"INSTRUMENT 00. VOLUME: 80"}, announcer.announcements)
+
+	// Changed value
+
+	drawText(screen, 64, 20, "81")
+	require.NoError(t, access.update(screen, start.Add(2*debounce)))
+	drawText(screen, 64, 20, "82")
+	require.NoError(t, access.update(screen, start.Add(2*debounce+debounce/2)))
+	require.NoError(t, access.flush(start.Add(3*debounce+debounce/2)))
+
+	require.Equal(t,
+		[]string{
+			"INSTRUMENT 00. VOLUME: 80",
+			"82",
+		},
+		announcer.announcements,
+	)
+
+	// Changed field
+
+	screen.update(DrawRectangleCommand{
+		pos: Position{
+			x: 63,
+			y: 22,
+		},
+		size: Size{
+			width:  16,
+			height: fontCharHeight + 1,
+		},
+		color: testBackgroundColor,
+	})
+	drawText(screen, 64, 20, "82")
+	highlight(screen, 64, 30, 2)
+	drawText(screen, 64, 30, "40")
+
+	require.NoError(t, access.update(screen, start.Add(4*debounce)))
+	require.NoError(t, access.flush(start.Add(5*debounce)))
+
+	require.Equal(t,
+		[]string{
+			"INSTRUMENT 00. VOLUME: 80",
+			"82",
+			"PAN: 40",
+		},
+		announcer.announcements,
+	)
+
+	// Unchanged
+
+	require.NoError(t, access.update(screen, start.Add(6*debounce)))
+	require.NoError(t, access.flush(start.Add(7*debounce)))
+	require.Len(t, announcer.announcements, 3)
+}
+
+func TestSpeechDispatcher(t *testing.T) {
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	s := &speechDispatcher{
+		conn:   client,
+		reader: bufio.NewReader(client),
+	}
+
+	received := make(chan []string)
+
+	go func() {
+		reader := bufio.NewReader(server)
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				received <- lines
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+
+			var reply string
+			switch {
+			case line == "CANCEL self":
+				reply = "210 OK CANCELED\r\n"
+			case line == "SPEAK":
+				reply = "230 OK RECEIVING DATA\r\n"
+			case line == ".":
+				reply = "225-21\r\n225 OK MESSAGE QUEUED\r\n"
+			case line == "QUIT":
+				reply = "231 HAPPY HACKING\r\n"
+			default:
+				continue
+			}
+			_, _ = server.Write([]byte(reply))
+		}
+	}()
+
+	require.NoError(t, s.announce("VOLUME: 80\n.5"))
+	require.NoError(t, s.close())
+
+	require.Equal(t,
+		[]string{
+			"CANCEL self",
+			"SPEAK",
+			"VOLUME: 80",
+			"..5",
+			".",
+			"QUIT",
+		},
+		<-received,
+	)
+}
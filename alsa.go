@@ -0,0 +1,286 @@
+//go:build linux && alsa
+// +build linux,alsa
+
+package main
+
+// #cgo LDFLAGS: -lasound
+// #include <errno.h>
+// #include <poll.h>
+// #include <stdlib.h>
+// #include <alsa/asoundlib.h>
+//
+// typedef struct {
+//     int kind;
+//     int channel;
+//     int number;
+//     int value;
+// } g0m8_midi_event;
+//
+// // g0m8_seq_read waits up to the given timeout for an event, and decodes it.
+// // It returns 1 if an event was decoded, 0 if the event was ignored or none arrived,
+// // or a negative error code
+// static int g0m8_seq_read(snd_seq_t *seq, int timeout, g0m8_midi_event *out) {
+//     if (snd_seq_event_input_pending(seq, 1) == 0) {
+//         int count = snd_seq_poll_descriptors_count(seq, POLLIN);
+//         struct pollfd fds[count];
+//         snd_seq_poll_descriptors(seq, fds, count, POLLIN);
+//         int err = poll(fds, count, timeout);
+//         if (err <= 0) {
+//             return err < 0 ? -errno : 0;
+//         }
+//     }
+//
+//     snd_seq_event_t *ev;
+//     int err = snd_seq_event_input(seq, &ev);
+//     if (err == -EAGAIN) {
+//         return 0;
+//     }
+//     if (err < 0) {
+//         return err;
+//     }
+//
+//     switch (ev->type) {
+//     case SND_SEQ_EVENT_NOTEON:
+//     case SND_SEQ_EVENT_NOTEOFF:
+//         out->kind = ev->type == SND_SEQ_EVENT_NOTEON ? 0 : 1;
+//         out->channel = ev->data.note.channel + 1;
+//         out->number = ev->data.note.note;
+//         out->value = ev->data.note.velocity;
+//         return 1;
+//
+//     case SND_SEQ_EVENT_CONTROLLER:
+//         out->kind = 2;
+//         out->channel = ev->data.control.channel + 1;
+//         out->number = ev->data.control.param;
+//         out->value = ev->data.control.value;
+//         return 1;
+//     }
+//
+//     return 0;
+// }
+//
+// static int g0m8_seq_send(snd_seq_t *seq, int port, g0m8_midi_event *event) {
+//     snd_seq_event_t ev;
+//     snd_seq_ev_clear(&ev);
+//     snd_seq_ev_set_source(&ev, port);
+//     snd_seq_ev_set_subs(&ev);
+//     snd_seq_ev_set_direct(&ev);
+//
+//     switch (event->kind) {
+//     case 0:
+//         snd_seq_ev_set_noteon(&ev, event->channel - 1, event->number, event->value);
+//         break;
+//     case 1:
+//         snd_seq_ev_set_noteoff(&ev, event->channel - 1, event->number, event->value);
+//         break;
+//     case 2:
+//         snd_seq_ev_set_controller(&ev, event->channel - 1, event->number, event->value);
+//         break;
+//     }
+//
+//     return snd_seq_event_output_direct(seq, &ev);
+// }
+import "C"
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"unsafe"
+)
+
+// alsaPollTimeout is the time in milliseconds the reader waits for events,
+// before it checks if the sequencer got closed
+const alsaPollTimeout = 100
+
+// alsaSequencer is a client of the ALSA sequencer, with one port,
+// which other clients, e.g. MIDI controllers or virtual clients,
+// can be connected to and from
+//
+type alsaSequencer struct {
+	seq       *C.snd_seq_t
+	port      C.int
+	events    chan midiEvent
+	done      chan struct{}
+	listening bool
+	closed    int32
+}
+
+func alsaError(err C.int) error {
+	return fmt.Errorf("ALSA sequencer: %s", C.GoString(C.snd_strerror(err)))
+}
+
+// openALSASequencer opens a new sequencer client with the given name
+//
+func openALSASequencer(name string) (*alsaSequencer, error) {
+	var seq *C.snd_seq_t
+
+	cDefault := C.CString("default")
+	defer C.free(unsafe.Pointer(cDefault))
+
+	err := C.snd_seq_open(&seq, cDefault, C.SND_SEQ_OPEN_DUPLEX, C.SND_SEQ_NONBLOCK)
+	if err < 0 {
+		return nil, alsaError(err)
+	}
+
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	C.snd_seq_set_client_name(seq, cName)
+
+	port := C.snd_seq_create_simple_port(
+		seq,
+		cName,
+		C.SND_SEQ_PORT_CAP_READ|C.SND_SEQ_PORT_CAP_SUBS_READ|
+			C.SND_SEQ_PORT_CAP_WRITE|C.SND_SEQ_PORT_CAP_SUBS_WRITE,
+		C.SND_SEQ_PORT_TYPE_MIDI_GENERIC|C.SND_SEQ_PORT_TYPE_APPLICATION,
+	)
+	if port < 0 {
+		C.snd_seq_close(seq)
+		return nil, alsaError(port)
+	}
+
+	return &alsaSequencer{
+		seq:    seq,
+		port:   port,
+		events: make(chan midiEvent, 64),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// address returns the address of the port, e.g. 128:0
+//
+func (s *alsaSequencer) address() string {
+	return fmt.Sprintf("%d:%d", C.snd_seq_client_id(s.seq), s.port)
+}
+
+func (s *alsaSequencer) parseAddress(address string) (C.snd_seq_addr_t, error) {
+	var addr C.snd_seq_addr_t
+
+	cAddress := C.CString(address)
+	defer C.free(unsafe.Pointer(cAddress))
+
+	err := C.snd_seq_parse_address(s.seq, &addr, cAddress)
+	if err < 0 {
+		return addr, fmt.Errorf("invalid ALSA sequencer address %s: %w", address, alsaError(err))
+	}
+
+	return addr, nil
+}
+
+// connectFrom subscribes the port to the events of the given client port,
+// given by address, e.g. 20:0, or by name, e.g. "MPD218"
+//
+func (s *alsaSequencer) connectFrom(address string) error {
+	addr, err := s.parseAddress(address)
+	if err != nil {
+		return err
+	}
+
+	result := C.snd_seq_connect_from(s.seq, s.port, C.int(addr.client), C.int(addr.port))
+	if result < 0 {
+		return alsaError(result)
+	}
+
+	return nil
+}
+
+// connectTo subscribes the given client port to the events of the port
+//
+func (s *alsaSequencer) connectTo(address string) error {
+	addr, err := s.parseAddress(address)
+	if err != nil {
+		return err
+	}
+
+	result := C.snd_seq_connect_to(s.seq, s.port, C.int(addr.client), C.int(addr.port))
+	if result < 0 {
+		return alsaError(result)
+	}
+
+	return nil
+}
+
+// send sends the given event to all subscribers of the port
+//
+func (s *alsaSequencer) send(event midiEvent) error {
+	cEvent := C.g0m8_midi_event{
+		kind:    C.int(event.kind),
+		channel: C.int(event.channel),
+		number:  C.int(event.number),
+		value:   C.int(event.value),
+	}
+
+	err := C.g0m8_seq_send(s.seq, s.port, &cEvent)
+	if err < 0 {
+		return alsaError(err)
+	}
+
+	return nil
+}
+
+// listen reads the events sent to the port in the background,
+// and delivers them on the events channel
+//
+func (s *alsaSequencer) listen() {
+	s.listening = true
+
+	go func() {
+		defer close(s.done)
+
+		var cEvent C.g0m8_midi_event
+
+		for atomic.LoadInt32(&s.closed) == 0 {
+			result := C.g0m8_seq_read(s.seq, alsaPollTimeout, &cEvent)
+			if result < 0 {
+				if result == -C.EINTR || result == -C.ENOSPC {
+					continue
+				}
+				log.Printf("failed to read MIDI events, no longer receiving: %s", alsaError(result))
+				return
+			}
+
+			if result == 0 {
+				continue
+			}
+
+			s.events <- midiEvent{
+				kind:    midiEventKind(cEvent.kind),
+				channel: int(cEvent.channel),
+				number:  int(cEvent.number),
+				value:   int(cEvent.value),
+			}
+		}
+	}()
+}
+
+// run handles all received events
+//
+func (s *alsaSequencer) run(handle func(midiEvent)) {
+	for {
+		select {
+		case event := <-s.events:
+			handle(event)
+		default:
+			return
+		}
+	}
+}
+
+func (s *alsaSequencer) close() {
+	atomic.StoreInt32(&s.closed, 1)
+
+	if s.listening {
+		// Drain the events, so the reader does not block delivering them
+	wait:
+		for {
+			select {
+			case <-s.done:
+				break wait
+			case <-s.events:
+			}
+		}
+	}
+
+	C.snd_seq_close(s.seq)
+}
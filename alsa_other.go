@@ -0,0 +1,28 @@
+//go:build !linux || !alsa
+// +build !linux !alsa
+
+package main
+
+import "errors"
+
+// alsaSequencer is only available on Linux, when built with the alsa tag
+//
+type alsaSequencer struct{}
+
+func openALSASequencer(name string) (*alsaSequencer, error) {
+	return nil, errors.New("MIDI input is only supported on Linux, when built with -tags alsa")
+}
+
+func (s *alsaSequencer) address() string {
+	return ""
+}
+
+func (s *alsaSequencer) connectFrom(address string) error {
+	return nil
+}
+
+func (s *alsaSequencer) listen() {}
+
+func (s *alsaSequencer) run(handle func(midiEvent)) {}
+
+func (s *alsaSequencer) close() {}
@@ -0,0 +1,52 @@
+//go:build linux && alsa
+// +build linux,alsa
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestALSASequencer(t *testing.T) {
+
+	input, err := openALSASequencer("g0m8 test input")
+	if err != nil {
+		t.Skipf("ALSA sequencer not available: %s", err)
+	}
+	defer input.close()
+
+	// A virtual client, e.g. a MIDI controller
+
+	controller, err := openALSASequencer("g0m8 test controller")
+	require.NoError(t, err)
+	defer controller.close()
+
+	require.NoError(t, input.connectFrom(controller.address()))
+
+	input.listen()
+
+	sent := []midiEvent{
+		{kind: midiNoteOn, channel: 1, number: 36, value: 100},
+		{kind: midiControlChange, channel: 2, number: 64, value: 127},
+		{kind: midiNoteOff, channel: 1, number: 36, value: 0},
+	}
+
+	for _, event := range sent {
+		require.NoError(t, controller.send(event))
+	}
+
+	var received []midiEvent
+
+	deadline := time.Now().Add(time.Second)
+	for len(received) < len(sent) && time.Now().Before(deadline) {
+		input.run(func(event midiEvent) {
+			received = append(received, event)
+		})
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.Equal(t, sent, received)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The automation API is an HTTP/JSON API, served on a TCP or Unix socket:
+//
+// POST /keys/press    {"keys": ["SHIFT", "UP"]}                 Press keys
+// POST /keys/release  {"keys": ["UP"]}                          Release keys, all if none are given
+// POST /keys/tap      {"keys": ["EDIT"], "duration_ms": 50}     Press keys, and release them after the duration
+// POST /note          {"note": 60, "velocity": 100}             Send a keyjazz note on
+// POST /note/off                                                Send a keyjazz note off
+// GET  /screen                                                  Get the character grid as JSON
+// GET  /screen/text                                             Get the text on the screen
+// POST /wait          {"text": "PHRASE", "timeout_ms": 5000}    Wait until the screen contains the text,
+//                     {"regexp": "^SONG", ...}                  or a line matches the regular expression
+// GET  /screenshot                                              Get a PNG screenshot
+
+const apiDefaultTapDuration = 50 * time.Millisecond
+const apiDefaultWaitTimeout = 5 * time.Second
+const apiWaitInterval = 20 * time.Millisecond
+
+// api serves the automation API.
+//
+// The handlers run concurrently, but the API state, the screen, and the port
+// are only accessed on the main goroutine: handlers queue calls,
+// which are executed by run
+//
+type api struct {
+	calls          chan func()
+	keys           uint8
+	sendController func(uint8)
+	sendNoteOn     func(note byte, velocity byte)
+	sendNoteOff    func()
+	screen         *textScreen
+	framebuffer    *framebuffer
+}
+
+func newAPI(
+	sendController func(uint8),
+	sendNoteOn func(note byte, velocity byte),
+	sendNoteOff func(),
+	screen *textScreen,
+	framebuffer *framebuffer,
+) *api {
+	return &api{
+		calls:          make(chan func(), 16),
+		sendController: sendController,
+		sendNoteOn:     sendNoteOn,
+		sendNoteOff:    sendNoteOff,
+		screen:         screen,
+		framebuffer:    framebuffer,
+	}
+}
+
+// listen listens on the given address,
+// which is either a TCP address, or a Unix socket path prefixed with "unix:"
+//
+func listen(address string) (net.Listener, error) {
+	const unixPrefix = "unix:"
+	if strings.HasPrefix(address, unixPrefix) {
+		path := strings.TrimPrefix(address, unixPrefix)
+		// Remove the socket of a previous run
+		_ = os.Remove(path)
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+func (a *api) serve(listener net.Listener) {
+	err := http.Serve(listener, a.handler())
+	if err != nil {
+		log.Printf("automation API stopped: %s", err)
+	}
+}
+
+func (a *api) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys/press", a.post(a.handlePress))
+	mux.HandleFunc("/keys/release", a.post(a.handleRelease))
+	mux.HandleFunc("/keys/tap", a.post(a.handleTap))
+	mux.HandleFunc("/note", a.post(a.handleNote))
+	mux.HandleFunc("/note/off", a.post(a.handleNoteOff))
+	mux.HandleFunc("/screen", a.get(a.handleScreen))
+	mux.HandleFunc("/screen/text", a.get(a.handleScreenText))
+	mux.HandleFunc("/wait", a.post(a.handleWait))
+	mux.HandleFunc("/screenshot", a.get(a.handleScreenshot))
+	return mux
+}
+
+// run executes the queued calls. It must be called on the main goroutine
+//
+func (a *api) run() {
+	for {
+		select {
+		case call := <-a.calls:
+			call()
+		default:
+			return
+		}
+	}
+}
+
+// call queues the given function, and waits until it got executed
+//
+func (a *api) call(f func()) {
+	done := make(chan struct{})
+	a.calls <- func() {
+		f()
+		close(done)
+	}
+	<-done
+}
+
+func (a *api) post(handle func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handle(w, r)
+	}
+}
+
+func (a *api) get(handle func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handle(w, r)
+	}
+}
+
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	if r.ContentLength == 0 {
+		return true
+	}
+	err := json.NewDecoder(r.Body).Decode(request)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+type apiKeysRequest struct {
+	Keys       []string `json:"keys"`
+	DurationMs int      `json:"duration_ms"`
+}
+
+type apiKeysResponse struct {
+	Controller uint8 `json:"controller"`
+}
+
+func (a *api) decodeKeys(w http.ResponseWriter, r *http.Request) (apiKeysRequest, uint8, bool) {
+	var request apiKeysRequest
+	if !decodeAPIRequest(w, r, &request) {
+		return request, 0, false
+	}
+
+	keys, err := parseKeys(request.Keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return request, 0, false
+	}
+
+	return request, keys, true
+}
+
+// reset forgets the keys pressed through the API, e.g. after reconnecting.
+// It must be called from the main loop, like run
+//
+func (a *api) reset() {
+	a.keys = 0
+}
+
+// update presses or releases the given keys,
+// and returns the new controller state
+//
+func (a *api) update(keys uint8, pressed bool) uint8 {
+	var controller uint8
+	a.call(func() {
+		if pressed {
+			a.keys |= keys
+		} else {
+			a.keys &= 255 ^ keys
+		}
+		a.sendController(a.keys)
+		controller = a.keys
+	})
+	return controller
+}
+
+func (a *api) handlePress(w http.ResponseWriter, r *http.Request) {
+	_, keys, ok := a.decodeKeys(w, r)
+	if !ok {
+		return
+	}
+
+	controller := a.update(keys, true)
+
+	writeAPIResponse(w, http.StatusOK, apiKeysResponse{controller})
+}
+
+func (a *api) handleRelease(w http.ResponseWriter, r *http.Request) {
+	request, keys, ok := a.decodeKeys(w, r)
+	if !ok {
+		return
+	}
+
+	if len(request.Keys) == 0 {
+		keys = 255
+	}
+
+	controller := a.update(keys, false)
+
+	writeAPIResponse(w, http.StatusOK, apiKeysResponse{controller})
+}
+
+func (a *api) handleTap(w http.ResponseWriter, r *http.Request) {
+	request, keys, ok := a.decodeKeys(w, r)
+	if !ok {
+		return
+	}
+
+	duration := apiDefaultTapDuration
+	if request.DurationMs > 0 {
+		duration = time.Duration(request.DurationMs) * time.Millisecond
+	}
+
+	a.update(keys, true)
+	time.Sleep(duration)
+	controller := a.update(keys, false)
+
+	writeAPIResponse(w, http.StatusOK, apiKeysResponse{controller})
+}
+
+type apiNoteRequest struct {
+	Note     byte `json:"note"`
+	Velocity byte `json:"velocity"`
+}
+
+func (a *api) handleNote(w http.ResponseWriter, r *http.Request) {
+	request := apiNoteRequest{
+		Velocity: 100,
+	}
+	if !decodeAPIRequest(w, r, &request) {
+		return
+	}
+
+	err := checkNote(int(request.Note), int(request.Velocity))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	a.call(func() {
+		a.sendNoteOn(request.Note, request.Velocity)
+	})
+
+	writeAPIResponse(w, http.StatusOK, struct{}{})
+}
+
+func (a *api) handleNoteOff(w http.ResponseWriter, r *http.Request) {
+	a.call(a.sendNoteOff)
+
+	writeAPIResponse(w, http.StatusOK, struct{}{})
+}
+
+type apiCell struct {
+	Char       string `json:"char"`
+	Foreground string `json:"foreground"`
+	Background string `json:"background"`
+}
+
+type apiScreenResponse struct {
+	Lines []string    `json:"lines"`
+	Cells [][]apiCell `json:"cells"`
+}
+
+func formatColor(c Color) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.r, c.g, c.b)
+}
+
+func (a *api) handleScreen(w http.ResponseWriter, r *http.Request) {
+	var response apiScreenResponse
+
+	a.call(func() {
+		response.Lines = a.screen.lines()
+		response.Cells = make([][]apiCell, textRows)
+		for row := range response.Cells {
+			cells := make([]apiCell, textColumns)
+			for column := range cells {
+				cell := a.screen.cell(row, column)
+				if !cell.empty() {
+					cells[column].Char = string(glyphRune(cell.c))
+					cells[column].Foreground = formatColor(cell.foreground)
+				}
+				cells[column].Background = formatColor(a.screen.background(row, column))
+			}
+			response.Cells[row] = cells
+		}
+	})
+
+	writeAPIResponse(w, http.StatusOK, response)
+}
+
+func (a *api) handleScreenText(w http.ResponseWriter, r *http.Request) {
+	var text string
+	a.call(func() {
+		text = a.screen.String()
+	})
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintln(w, text)
+}
+
+type apiWaitRequest struct {
+	Text      string `json:"text"`
+	Regexp    string `json:"regexp"`
+	TimeoutMs int    `json:"timeout_ms"`
+}
+
+type apiWaitResponse struct {
+	Found bool     `json:"found"`
+	Lines []string `json:"lines"`
+}
+
+func (a *api) handleWait(w http.ResponseWriter, r *http.Request) {
+	var request apiWaitRequest
+	if !decodeAPIRequest(w, r, &request) {
+		return
+	}
+
+	var re *regexp.Regexp
+	if request.Regexp != "" {
+		var err error
+		re, err = regexp.Compile(request.Regexp)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid regexp: %s", err), http.StatusBadRequest)
+			return
+		}
+	} else if request.Text == "" {
+		http.Error(w, "missing text or regexp", http.StatusBadRequest)
+		return
+	}
+
+	timeout := apiDefaultWaitTimeout
+	if request.TimeoutMs > 0 {
+		timeout = time.Duration(request.TimeoutMs) * time.Millisecond
+	}
+	deadline := time.Now().Add(timeout)
+
+	var response apiWaitResponse
+
+	for {
+		a.call(func() {
+			response.Lines = a.screen.lines()
+			if re != nil {
+				for _, line := range response.Lines {
+					if re.MatchString(line) {
+						response.Found = true
+						break
+					}
+				}
+			} else {
+				response.Found = a.screen.contains(request.Text)
+			}
+		})
+
+		if response.Found {
+			writeAPIResponse(w, http.StatusOK, response)
+			return
+		}
+
+		if time.Now().After(deadline) {
+			writeAPIResponse(w, http.StatusRequestTimeout, response)
+			return
+		}
+
+		time.Sleep(apiWaitInterval)
+	}
+}
+
+func (a *api) handleScreenshot(w http.ResponseWriter, r *http.Request) {
+	var screenshot *image.RGBA
+	a.call(func() {
+		screenshot = image.NewRGBA(a.framebuffer.image.Rect)
+		copy(screenshot.Pix, a.framebuffer.image.Pix)
+	})
+
+	w.Header().Set("Content-Type", "image/png")
+	_ = png.Encode(w, screenshot)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testAPI struct {
+	*api
+	server      *httptest.Server
+	controllers []uint8
+	notes       [][2]byte
+	stop        chan struct{}
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	test := &testAPI{
+		stop: make(chan struct{}),
+	}
+
+	test.api = newAPI(
+		func(controller uint8) {
+			test.controllers = append(test.controllers, controller)
+		},
+		func(note byte, velocity byte) {
+			test.notes = append(test.notes, [2]byte{note, velocity})
+		},
+		func() {
+			test.notes = append(test.notes, [2]byte{})
+		},
+		newTextScreen(),
+		newFramebuffer(),
+	)
+
+	// Execute the calls, like the main loop
+
+	go func() {
+		for {
+			select {
+			case call := <-test.calls:
+				call()
+			case <-test.stop:
+				return
+			}
+		}
+	}()
+
+	test.server = httptest.NewServer(test.handler())
+
+	t.Cleanup(func() {
+		test.server.Close()
+		close(test.stop)
+	})
+
+	return test
+}
+
+func (test *testAPI) post(t *testing.T, path string, request interface{}) *http.Response {
+	body, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	response, err := http.Post(test.server.URL+path, "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = response.Body.Close()
+	})
+
+	return response
+}
+
+func TestAPI(t *testing.T) {
+
+	t.Run("keys", func(t *testing.T) {
+		test := newTestAPI(t)
+
+		response := test.post(t, "/keys/press", apiKeysRequest{Keys: []string{"shift", "UP"}})
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		response = test.post(t, "/keys/release", apiKeysRequest{Keys: []string{"UP"}})
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		response = test.post(t, "/keys/tap", apiKeysRequest{Keys: []string{"EDIT"}, DurationMs: 1})
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		response = test.post(t, "/keys/release", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		require.Equal(t,
+			[]uint8{
+				keySelect | keyUp,
+				keySelect,
+				keySelect | keyEdit,
+				keySelect,
+				0,
+			},
+			test.controllers,
+		)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		test := newTestAPI(t)
+
+		response := test.post(t, "/keys/press", apiKeysRequest{Keys: []string{"FOO"}})
+		require.Equal(t, http.StatusBadRequest, response.StatusCode)
+		require.Empty(t, test.controllers)
+	})
+
+	t.Run("notes", func(t *testing.T) {
+		test := newTestAPI(t)
+
+		response := test.post(t, "/note", apiNoteRequest{Note: 60, Velocity: 80})
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		response = test.post(t, "/note/off", nil)
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		require.Equal(t, [][2]byte{{60, 80}, {0, 0}}, test.notes)
+
+		// Note 0 stops the note, and has no velocity
+		for _, request := range []apiNoteRequest{
+			{Note: 0, Velocity: 80},
+			{Note: 128, Velocity: 80},
+			{Note: 60, Velocity: 128},
+		} {
+			response = test.post(t, "/note", request)
+			require.Equal(t, http.StatusBadRequest, response.StatusCode)
+		}
+		require.Len(t, test.notes, 2)
+	})
+
+	t.Run("screen and wait", func(t *testing.T) {
+		test := newTestAPI(t)
+
+		test.call(func() {
+			drawText(test.screen, 0, 0, "PHRASE 00")
+		})
+
+		response, err := http.Get(test.server.URL + "/screen")
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		var screen apiScreenResponse
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&screen))
+		require.Equal(t, "PHRASE 00", screen.Lines[0])
+		require.Equal(t, "P", screen.Cells[0][0].Char)
+		require.Equal(t, "#ffffff", screen.Cells[0][0].Foreground)
+
+		response = test.post(t, "/wait", apiWaitRequest{Regexp: "^PHRASE"})
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		response = test.post(t, "/wait", apiWaitRequest{Text: "SONG", TimeoutMs: 50})
+		require.Equal(t, http.StatusRequestTimeout, response.StatusCode)
+	})
+
+	t.Run("screenshot", func(t *testing.T) {
+		test := newTestAPI(t)
+
+		response, err := http.Get(test.server.URL + "/screenshot")
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		image, err := png.Decode(response.Body)
+		require.NoError(t, err)
+		require.Equal(t, screenWidth, image.Bounds().Dx())
+		require.Equal(t, screenHeight, image.Bounds().Dy())
+	})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// The M8 is a USB audio device. The audio passthrough captures it through SDL,
+// and plays it on the default output device.
+//
+// Both devices use queued audio instead of callbacks. The captured audio is moved in small chunks,
+// and if the output falls behind, e.g. because its clock is slower, the queued audio is dropped,
+// so the latency stays low.
+//
+// It can be tested without an M8 with SDL's audio drivers, e.g.
+// SDL_AUDIODRIVER=disk SDL_DISKAUDIOFILEIN=in.raw SDL_DISKAUDIOFILE=out.raw g0m8 -audio -audio-device default ...
+
+const audioFrequency = 44100
+const audioChannels = 2
+const audioFormat = sdl.AUDIO_S16LSB
+
+// audioFrameSize is the size of a sample of all channels, in bytes
+const audioFrameSize = audioChannels * 2
+
+// audioMaxQueuedBuffers is the number of buffers which may be queued for output,
+// before the queued audio is dropped
+const audioMaxQueuedBuffers = 4
+
+// audioDefaultDevice selects the default capture device
+const audioDefaultDevice = "default"
+
+const audioMaxVolume = 4
+
+// listAudioDevices writes the names of the SDL capture and output devices
+//
+func listAudioDevices(w io.Writer) error {
+	err := sdl.InitSubSystem(sdl.INIT_AUDIO)
+	if err != nil {
+		return err
+	}
+	defer sdl.QuitSubSystem(sdl.INIT_AUDIO)
+
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "Audio driver: %s\n", sdl.GetCurrentAudioDriver())
+
+	for _, capture := range []bool{true, false} {
+		if capture {
+			builder.WriteString("Capture devices:\n")
+		} else {
+			builder.WriteString("Output devices:\n")
+		}
+
+		for _, name := range audioDeviceNames(capture) {
+			fmt.Fprintf(&builder, "  %s\n", name)
+		}
+	}
+
+	_, err = io.WriteString(w, builder.String())
+	return err
+}
+
+func audioDeviceNames(capture bool) []string {
+	count := sdl.GetNumAudioDevices(capture)
+	names := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		names = append(names, sdl.GetAudioDeviceName(i, capture))
+	}
+	return names
+}
+
+// findAudioDevice returns the name of the device with the given name,
+// or else the first device which name contains the given name, ignoring case.
+// The default device has the empty name
+//
+func findAudioDevice(names []string, name string) (string, error) {
+	if name == audioDefaultDevice {
+		return "", nil
+	}
+
+	for _, deviceName := range names {
+		if deviceName == name {
+			return deviceName, nil
+		}
+	}
+
+	for _, deviceName := range names {
+		if strings.Contains(strings.ToLower(deviceName), strings.ToLower(name)) {
+			return deviceName, nil
+		}
+	}
+
+	return "", fmt.Errorf(
+		"audio capture device %s not found, available: %s",
+		name,
+		strings.Join(names, ", "),
+	)
+}
+
+// applyVolume scales the given signed 16-bit little endian samples by the given volume, with clipping
+//
+func applyVolume(samples []byte, volume float64) {
+	if volume == 1 {
+		return
+	}
+
+	for i := 0; i+1 < len(samples); i += 2 {
+		sample := float64(int16(binary.LittleEndian.Uint16(samples[i:])))
+		sample = math.Round(sample * volume)
+
+		switch {
+		case sample > math.MaxInt16:
+			sample = math.MaxInt16
+		case sample < math.MinInt16:
+			sample = math.MinInt16
+		}
+
+		binary.LittleEndian.PutUint16(samples[i:], uint16(int16(sample)))
+	}
+}
+
+// audioPassthrough plays the audio of a capture device on the default output device
+//
+type audioPassthrough struct {
+	capture   sdl.AudioDeviceID
+	output    sdl.AudioDeviceID
+	buffer    []byte
+	maxQueued int
+	interval  time.Duration
+	volume    float64
+	// recorder records the captured audio, before the volume is applied
+	recorder *wavWriter
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// newAudioPassthrough opens the capture device with the given name (see findAudioDevice),
+// and the default output device, with buffers of the given number of samples
+//
+func newAudioPassthrough(deviceName string, bufferSamples int, volume float64) (*audioPassthrough, error) {
+	if bufferSamples < 1 || bufferSamples > math.MaxUint16 {
+		return nil, fmt.Errorf("invalid audio buffer size: %d", bufferSamples)
+	}
+
+	if volume < 0 || volume > audioMaxVolume {
+		return nil, fmt.Errorf("invalid audio volume: %g, expected 0 to %d", volume, audioMaxVolume)
+	}
+
+	err := sdl.InitSubSystem(sdl.INIT_AUDIO)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceName, err = findAudioDevice(audioDeviceNames(true), deviceName)
+	if err != nil {
+		sdl.QuitSubSystem(sdl.INIT_AUDIO)
+		return nil, err
+	}
+
+	// SDL converts from and to the formats of the devices
+	spec := sdl.AudioSpec{
+		Freq:     audioFrequency,
+		Format:   audioFormat,
+		Channels: audioChannels,
+		Samples:  uint16(bufferSamples),
+	}
+
+	capture, err := sdl.OpenAudioDevice(deviceName, true, &spec, nil, 0)
+	if err != nil {
+		sdl.QuitSubSystem(sdl.INIT_AUDIO)
+		return nil, fmt.Errorf("failed to open audio capture device: %w", err)
+	}
+
+	output, err := sdl.OpenAudioDevice("", false, &spec, nil, 0)
+	if err != nil {
+		sdl.CloseAudioDevice(capture)
+		sdl.QuitSubSystem(sdl.INIT_AUDIO)
+		return nil, fmt.Errorf("failed to open audio output device: %w", err)
+	}
+
+	bufferSize := bufferSamples * audioFrameSize
+	bufferDuration := time.Duration(bufferSamples) * time.Second / audioFrequency
+
+	a := &audioPassthrough{
+		capture:   capture,
+		output:    output,
+		buffer:    make([]byte, bufferSize),
+		maxQueued: bufferSize * audioMaxQueuedBuffers,
+		// Move the captured audio twice per buffer
+		interval: bufferDuration / 2,
+		volume:   volume,
+		stop:     make(chan struct{}),
+	}
+
+	sdl.PauseAudioDevice(capture, false)
+	sdl.PauseAudioDevice(output, false)
+
+	return a, nil
+}
+
+// transfer moves the captured audio to the output device, and returns the number of moved bytes
+//
+func (a *audioPassthrough) transfer() int {
+	size := int(sdl.GetQueuedAudioSize(a.capture))
+	if size > len(a.buffer) {
+		size = len(a.buffer)
+	}
+	size -= size % audioFrameSize
+
+	if size == 0 {
+		return 0
+	}
+
+	data := a.buffer[:size]
+
+	// The go-sdl2 wrapper of SDL_DequeueAudio returns an error when any audio was dequeued,
+	// so only the available audio is dequeued, and the result is ignored
+	_ = sdl.DequeueAudio(a.capture, data)
+
+	if a.recorder != nil {
+		err := a.recorder.write(data, time.Now())
+		if err != nil {
+			log.Printf("failed to record audio: %s", err)
+			a.recorder = nil
+		}
+	}
+
+	if int(sdl.GetQueuedAudioSize(a.output)) > a.maxQueued {
+		sdl.ClearQueuedAudio(a.output)
+	}
+
+	applyVolume(data, a.volume)
+
+	err := sdl.QueueAudio(a.output, data)
+	if err != nil {
+		return 0
+	}
+
+	return size
+}
+
+// start starts moving the captured audio to the output device, until the passthrough is closed.
+// If recorder is set, the captured audio is also recorded
+//
+func (a *audioPassthrough) start() {
+	a.stopped.Add(1)
+	go a.run()
+}
+
+func (a *audioPassthrough) run() {
+	defer a.stopped.Done()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			// Move all captured audio, in case the ticker fell behind
+			for {
+				if a.transfer() < len(a.buffer) {
+					break
+				}
+			}
+		}
+	}
+}
+
+func (a *audioPassthrough) close() {
+	close(a.stop)
+	a.stopped.Wait()
+
+	sdl.CloseAudioDevice(a.capture)
+	sdl.CloseAudioDevice(a.output)
+	sdl.QuitSubSystem(sdl.INIT_AUDIO)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+func TestFindAudioDevice(t *testing.T) {
+
+	names := []string{
+		"Built-in Audio Analog Stereo",
+		"M8 Analog Stereo",
+		"M8",
+	}
+
+	name, err := findAudioDevice(names, "M8")
+	require.NoError(t, err)
+	require.Equal(t, "M8", name)
+
+	name, err = findAudioDevice(names, "analog")
+	require.NoError(t, err)
+	require.Equal(t, "Built-in Audio Analog Stereo", name)
+
+	name, err = findAudioDevice(names, "m8 analog")
+	require.NoError(t, err)
+	require.Equal(t, "M8 Analog Stereo", name)
+
+	name, err = findAudioDevice(nil, audioDefaultDevice)
+	require.NoError(t, err)
+	require.Equal(t, "", name)
+
+	_, err = findAudioDevice(names, "USB")
+	require.Error(t, err)
+}
+
+func TestApplyVolume(t *testing.T) {
+
+	samples := func(values ...int16) []byte {
+		data := make([]byte, len(values)*2)
+		for i, value := range values {
+			binary.LittleEndian.PutUint16(data[i*2:], uint16(value))
+		}
+		return data
+	}
+
+	data := samples(0, 1000, -1000, 20000, -20000, 3)
+	applyVolume(data, 1)
+	require.Equal(t, samples(0, 1000, -1000, 20000, -20000, 3), data)
+
+	applyVolume(data, 2)
+	require.Equal(t, samples(0, 2000, -2000, 32767, -32768, 6), data)
+
+	applyVolume(data, 0.5)
+	require.Equal(t, samples(0, 1000, -1000, 16384, -16384, 3), data)
+
+	applyVolume(data, 0)
+	require.Equal(t, samples(0, 0, 0, 0, 0, 0), data)
+}
+
+// TestAudioPassthrough plays the audio captured from a file on a file, with SDL's disk audio driver
+//
+func TestAudioPassthrough(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "g0m8")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	inputPath := filepath.Join(dir, "in.raw")
+	outputPath := filepath.Join(dir, "out.raw")
+
+	// 100ms of a constant signal
+	input := make([]byte, audioFrequency/10*audioFrameSize)
+	for i := 0; i < len(input); i += 2 {
+		binary.LittleEndian.PutUint16(input[i:], 1000)
+	}
+	require.NoError(t, ioutil.WriteFile(inputPath, input, 0644))
+
+	for name, value := range map[string]string{
+		"SDL_AUDIODRIVER":     "disk",
+		"SDL_DISKAUDIOFILEIN": inputPath,
+		"SDL_DISKAUDIOFILE":   outputPath,
+	} {
+		previous, ok := os.LookupEnv(name)
+		require.NoError(t, os.Setenv(name, value))
+		if ok {
+			defer os.Setenv(name, previous)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	if err := sdl.InitSubSystem(sdl.INIT_AUDIO); err != nil {
+		t.Skipf("SDL audio is not available: %s", err)
+	}
+	defer sdl.QuitSubSystem(sdl.INIT_AUDIO)
+
+	audio, err := newAudioPassthrough(audioDefaultDevice, 256, 2)
+	require.NoError(t, err)
+
+	audio.start()
+	time.Sleep(300 * time.Millisecond)
+	audio.close()
+
+	output, err := ioutil.ReadFile(outputPath)
+	require.NoError(t, err)
+
+	// The output is silence, until the captured signal arrives, with the volume applied
+	var signal int
+	for i := 0; i+1 < len(output); i += 2 {
+		sample := int16(binary.LittleEndian.Uint16(output[i:]))
+		if sample != 0 {
+			require.Equal(t, int16(2000), sample)
+			signal++
+		}
+	}
+	require.NotZero(t, signal)
+}
@@ -0,0 +1,226 @@
+package main
+
+// # Capture file format
+//
+// Captures record the traffic between g0m8 and the M8, e.g. session recordings and flight recorder dumps.
+// All integers are little endian, varints are encoded like encoding/binary's Uvarint.
+//
+// Header:
+//   8 bytes: magic "G0M8CAP" followed by the version byte 1
+//   int64:   start time, in nanoseconds since the Unix epoch
+//
+// Followed by records until the end of the file:
+//   byte:    kind, 'R' (packet received from the M8, SLIP decoded),
+//            'W' (data written to the M8), or 'E' (error message, e.g. a SLIP protocol error)
+//   varint:  time since the start, in nanoseconds
+//   varint:  length of the data
+//   bytes:   data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var captureMagic = []byte("G0M8CAP\x01")
+
+// captureMaxDataLength is the maximum length of the data of a record
+const captureMaxDataLength = 1 << 20
+
+// captureFlushInterval is how often a recorded session is flushed to the file,
+// so a crash or a kill loses at most the records of the last interval
+const captureFlushInterval = time.Second
+
+type captureRecordKind byte
+
+const (
+	captureReceived captureRecordKind = 'R'
+	captureWritten  captureRecordKind = 'W'
+	captureError    captureRecordKind = 'E'
+)
+
+type captureRecord struct {
+	kind captureRecordKind
+	// time is the time since the start of the capture
+	time time.Duration
+	data []byte
+}
+
+var errInvalidCapture = errors.New("invalid capture file")
+
+// captureWriter writes a capture file
+//
+type captureWriter struct {
+	writer *bufio.Writer
+	start  time.Time
+	buf    [binary.MaxVarintLen64]byte
+}
+
+// newCaptureWriter writes the header of a capture which started at the given time
+//
+func newCaptureWriter(w io.Writer, start time.Time) (*captureWriter, error) {
+	c := &captureWriter{
+		writer: bufio.NewWriter(w),
+		start:  start,
+	}
+
+	_, err := c.writer.Write(captureMagic)
+	if err != nil {
+		return nil, err
+	}
+
+	err = binary.Write(c.writer, binary.LittleEndian, start.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *captureWriter) writeVarint(value uint64) error {
+	n := binary.PutUvarint(c.buf[:], value)
+	_, err := c.writer.Write(c.buf[:n])
+	return err
+}
+
+func (c *captureWriter) write(record captureRecord) error {
+	if record.time < 0 {
+		record.time = 0
+	}
+
+	err := c.writer.WriteByte(byte(record.kind))
+	if err != nil {
+		return err
+	}
+
+	err = c.writeVarint(uint64(record.time))
+	if err != nil {
+		return err
+	}
+
+	err = c.writeVarint(uint64(len(record.data)))
+	if err != nil {
+		return err
+	}
+
+	_, err = c.writer.Write(record.data)
+	return err
+}
+
+// writeAt writes a record of the given kind, which happened at the given time
+//
+func (c *captureWriter) writeAt(kind captureRecordKind, now time.Time, data []byte) error {
+	return c.write(captureRecord{
+		kind: kind,
+		time: now.Sub(c.start),
+		data: data,
+	})
+}
+
+func (c *captureWriter) flush() error {
+	return c.writer.Flush()
+}
+
+// captureReader reads a capture file
+//
+type captureReader struct {
+	reader *bufio.Reader
+	start  time.Time
+}
+
+// newCaptureReader reads the header of a capture
+//
+func newCaptureReader(r io.Reader) (*captureReader, error) {
+	c := &captureReader{
+		reader: bufio.NewReader(r),
+	}
+
+	magic := make([]byte, len(captureMagic))
+	_, err := io.ReadFull(c.reader, magic)
+	if err != nil || !bytes.Equal(magic, captureMagic) {
+		return nil, errInvalidCapture
+	}
+
+	var start int64
+	err = binary.Read(c.reader, binary.LittleEndian, &start)
+	if err != nil {
+		return nil, errInvalidCapture
+	}
+	c.start = time.Unix(0, start)
+
+	return c, nil
+}
+
+// next reads the next record. It returns io.EOF at the end of the capture
+//
+func (c *captureReader) next() (captureRecord, error) {
+	var record captureRecord
+
+	kind, err := c.reader.ReadByte()
+	if err != nil {
+		return record, err
+	}
+
+	record.kind = captureRecordKind(kind)
+	switch record.kind {
+	case captureReceived, captureWritten, captureError:
+		break
+	default:
+		return record, fmt.Errorf("%w: unknown record kind 0x%x", errInvalidCapture, kind)
+	}
+
+	t, err := binary.ReadUvarint(c.reader)
+	if err != nil {
+		return record, errInvalidCapture
+	}
+	record.time = time.Duration(t)
+
+	length, err := binary.ReadUvarint(c.reader)
+	if err != nil || length > captureMaxDataLength {
+		return record, errInvalidCapture
+	}
+
+	record.data = make([]byte, length)
+	_, err = io.ReadFull(c.reader, record.data)
+	if err != nil {
+		return record, errInvalidCapture
+	}
+
+	return record, nil
+}
+
+// readCapture reads all records of the given capture
+//
+func readCapture(r io.Reader) (start time.Time, records []captureRecord, err error) {
+	reader, err := newCaptureReader(r)
+	if err != nil {
+		return start, nil, err
+	}
+
+	for {
+		record, err := reader.next()
+		if err == io.EOF {
+			return reader.start, records, nil
+		}
+		if err != nil {
+			return reader.start, records, err
+		}
+		records = append(records, record)
+	}
+}
+
+// recordingWriter calls the given function with all data written to the underlying writer
+//
+type recordingWriter struct {
+	writer io.Writer
+	record func(data []byte)
+}
+
+func (w recordingWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.writer.Write(data)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapture(t *testing.T) {
+
+	start := time.Unix(1600000000, 123456789)
+
+	var buf bytes.Buffer
+	writer, err := newCaptureWriter(&buf, start)
+	require.NoError(t, err)
+
+	require.NoError(t, writer.writeAt(captureReceived, start.Add(time.Millisecond), []byte{0xFB, 0x1, 0x2}))
+	require.NoError(t, writer.writeAt(captureWritten, start.Add(2*time.Second), []byte{'C', keyEdit}))
+	require.NoError(t, writer.writeAt(captureError, start.Add(3*time.Hour), []byte("SLIP protocol error")))
+	// Records before the start are clamped
+	require.NoError(t, writer.writeAt(captureReceived, start.Add(-time.Second), nil))
+	require.NoError(t, writer.flush())
+
+	data := buf.Bytes()
+	require.Equal(t, []byte("G0M8CAP\x01"), data[:8])
+
+	readStart, records, err := readCapture(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.True(t, start.Equal(readStart))
+	require.Equal(t,
+		[]captureRecord{
+			{kind: captureReceived, time: time.Millisecond, data: []byte{0xFB, 0x1, 0x2}},
+			{kind: captureWritten, time: 2 * time.Second, data: []byte{'C', keyEdit}},
+			{kind: captureError, time: 3 * time.Hour, data: []byte("SLIP protocol error")},
+			{kind: captureReceived, time: 0, data: []byte{}},
+		},
+		records,
+	)
+
+	t.Run("invalid magic", func(t *testing.T) {
+		_, _, err := readCapture(bytes.NewReader([]byte("G0M8CAP\x02")))
+		require.Equal(t, errInvalidCapture, err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, records, err := readCapture(bytes.NewReader(data[:len(data)-4]))
+		require.Equal(t, errInvalidCapture, err)
+		require.Len(t, records, 2)
+	})
+
+	t.Run("unknown record kind", func(t *testing.T) {
+		invalid := append([]byte(nil), data[:16]...)
+		invalid = append(invalid, 'X', 0, 0)
+		_, _, err := readCapture(bytes.NewReader(invalid))
+		require.True(t, errors.Is(err, errInvalidCapture))
+	})
+}
+
+func TestRecordingWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var recorded [][]byte
+
+	w := recordingWriter{
+		writer: &buf,
+		record: func(data []byte) {
+			recorded = append(recorded, append([]byte(nil), data...))
+		},
+	}
+
+	sendController(w, keyUp)
+	disconnect(w)
+
+	require.Equal(t, []byte{'C', keyUp, 'C', 0, 'D'}, buf.Bytes())
+	require.Equal(t, [][]byte{{'C', keyUp}, {'C', 0}, {'D'}}, recorded)
+}
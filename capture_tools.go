@@ -0,0 +1,661 @@
+package main
+
+// The capture subcommand has tools for capture files:
+//
+//   g0m8 capture info session.cap
+//   g0m8 capture trim -from 10s -to 20s session.cap trimmed.cap
+//   g0m8 capture cat first.cap second.cap merged.cap
+//   g0m8 capture to-json session.cap session.jsonl
+//   g0m8 capture from-json session.jsonl session.cap
+//   g0m8 capture to-png -every 10 session.cap frames
+//   g0m8 capture render-video -rate 30 -scale 3 session.cap session.mp4
+//   g0m8 capture render-video -audio session.wav session.cap session.mp4
+//
+// The JSON format is JSON Lines: the first line is the header, with the start time,
+// followed by one line per record. Received packets are decoded in the type and fields properties,
+// which are ignored when converting back, only the hex property is used
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var captureSubcommands = []string{
+	"info",
+	"trim",
+	"cat",
+	"to-json",
+	"from-json",
+	"to-png",
+	"render-video",
+}
+
+func readCaptureFile(path string) (time.Time, []captureRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer file.Close()
+
+	start, records, err := readCapture(file)
+	if err != nil {
+		return start, records, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return start, records, nil
+}
+
+func writeCaptureFile(path string, start time.Time, records []captureRecord) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer, err := newCaptureWriter(file, start)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		err = writer.write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writer.flush()
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func captureDuration(records []captureRecord) time.Duration {
+	if len(records) == 0 {
+		return 0
+	}
+	return records[len(records)-1].time
+}
+
+// captureSummary are the statistics of a capture
+//
+type captureSummary struct {
+	start    time.Time
+	duration time.Duration
+	// kinds are the number of records of each kind
+	kinds map[captureRecordKind]int
+	// packets are the number of received packets of each command type, see inspectCommandTypes
+	packets map[string]int
+	// errors are the number of error records with each message
+	errors map[string]int
+}
+
+func summarizeCapture(start time.Time, records []captureRecord) captureSummary {
+	summary := captureSummary{
+		start:    start,
+		duration: captureDuration(records),
+		kinds:    map[captureRecordKind]int{},
+		packets:  map[string]int{},
+		errors:   map[string]int{},
+	}
+
+	for _, record := range records {
+		summary.kinds[record.kind]++
+
+		switch record.kind {
+		case captureReceived:
+			summary.packets[inspectPacket(record.data).kind]++
+		case captureError:
+			summary.errors[string(record.data)]++
+		}
+	}
+
+	return summary
+}
+
+func (s captureSummary) write(w io.Writer) error {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "start:    %s\n", s.start.Format(time.RFC3339Nano))
+	fmt.Fprintf(&builder, "duration: %s\n", formatReplayTime(s.duration))
+	fmt.Fprintf(
+		&builder,
+		"records:  %d received, %d written, %d errors\n",
+		s.kinds[captureReceived],
+		s.kinds[captureWritten],
+		s.kinds[captureError],
+	)
+
+	builder.WriteString("packets:\n")
+	for _, commandType := range inspectCommandTypes {
+		fmt.Fprintf(&builder, "  %-10s %d\n", commandType, s.packets[commandType])
+	}
+
+	if len(s.errors) > 0 {
+		messages := make([]string, 0, len(s.errors))
+		for message := range s.errors {
+			messages = append(messages, message)
+		}
+		sort.Strings(messages)
+
+		builder.WriteString("errors:\n")
+		for _, message := range messages {
+			fmt.Fprintf(&builder, "  %5d %s\n", s.errors[message], message)
+		}
+	}
+
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// captureRange selects the records to keep when trimming a capture,
+// by time since the start, and by index of the received packets.
+// A zero end time, or a negative last packet, selects until the end.
+// A zero last packet selects until the first packet
+//
+type captureRange struct {
+	from        time.Duration
+	to          time.Duration
+	firstPacket int
+	lastPacket  int
+	// keepScreen starts the trimmed capture with packets which draw the screen
+	// at the start of the range, so it can be replayed on its own
+	keepScreen bool
+}
+
+func (c captureRange) contains(record captureRecord, packetIndex int) bool {
+	if record.time < c.from || (c.to > 0 && record.time > c.to) {
+		return false
+	}
+
+	first := c.firstPacket
+	last := c.lastPacket
+	if last < 0 {
+		last = math.MaxInt32
+	}
+
+	// Other records are kept if they are between the first and the last packet
+	if record.kind != captureReceived {
+		return (first == 0 || packetIndex > first) && packetIndex <= last
+	}
+
+	return packetIndex >= first && packetIndex <= last
+}
+
+// trimCapture returns the records in the given range, relative to the first one,
+// and the time of the first one, i.e. the offset of the start of the trimmed capture
+//
+func trimCapture(records []captureRecord, selection captureRange) ([]captureRecord, time.Duration, error) {
+	var trimmed []captureRecord
+	var offset time.Duration
+	var packetIndex int
+
+	screen := newFramebuffer()
+
+	for _, record := range records {
+		if !selection.contains(record, packetIndex) {
+			if len(trimmed) == 0 && record.kind == captureReceived {
+				command, err := decodeCommand(record.data)
+				if err == nil {
+					screen.draw(command)
+				}
+			}
+		} else {
+			if len(trimmed) == 0 {
+				offset = record.time
+
+				if selection.keepScreen && packetIndex > 0 {
+					keyframe, err := newReplayKeyframe(0, screen)
+					if err != nil {
+						return nil, 0, err
+					}
+					err = keyframe.restore(func(command Command) {
+						trimmed = append(trimmed, captureRecord{
+							kind: captureReceived,
+							data: command.(DrawRectangleCommand).encode(),
+						})
+					})
+					if err != nil {
+						return nil, 0, err
+					}
+				}
+			}
+
+			record.time -= offset
+			trimmed = append(trimmed, record)
+		}
+
+		if record.kind == captureReceived {
+			packetIndex++
+		}
+	}
+
+	return trimmed, offset, nil
+}
+
+// captureFile are the start time and the records of a capture file
+//
+type captureFile struct {
+	start   time.Time
+	records []captureRecord
+}
+
+// concatCaptures returns the records of the given captures, one after another,
+// relative to the start of the first capture
+//
+func concatCaptures(captures []captureFile) (time.Time, []captureRecord) {
+	var start time.Time
+	var records []captureRecord
+	var offset time.Duration
+
+	for i, c := range captures {
+		if i == 0 {
+			start = c.start
+		}
+
+		for _, record := range c.records {
+			record.time += offset
+			records = append(records, record)
+		}
+
+		offset = captureDuration(records)
+	}
+
+	return start, records
+}
+
+type captureJSONHeader struct {
+	Start string `json:"start"`
+}
+
+type captureJSONRecord struct {
+	Kind   string                 `json:"kind"`
+	TimeMs float64                `json:"time_ms"`
+	Hex    string                 `json:"hex,omitempty"`
+	Text   string                 `json:"text,omitempty"`
+	Type   string                 `json:"type,omitempty"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// writeCaptureJSON writes the capture as JSON Lines
+//
+func writeCaptureJSON(w io.Writer, start time.Time, records []captureRecord) error {
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+
+	err := encoder.Encode(captureJSONHeader{
+		Start: start.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		jsonRecord := captureJSONRecord{
+			Kind:   string(record.kind),
+			TimeMs: float64(record.time) / float64(time.Millisecond),
+		}
+
+		switch record.kind {
+		case captureError:
+			jsonRecord.Text = string(record.data)
+
+		case captureReceived:
+			packet := inspectPacket(record.data)
+			jsonRecord.Type = packet.kind
+			jsonRecord.Fields = map[string]interface{}{}
+			for _, field := range packet.fields {
+				jsonRecord.Fields[field.name] = field.value
+			}
+			jsonRecord.Hex = hex.EncodeToString(record.data)
+
+		default:
+			jsonRecord.Hex = hex.EncodeToString(record.data)
+		}
+
+		err = encoder.Encode(jsonRecord)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+// readCaptureJSON reads a capture written by writeCaptureJSON, possibly edited
+//
+func readCaptureJSON(r io.Reader) (time.Time, []captureRecord, error) {
+	var start time.Time
+	var records []captureRecord
+
+	decoder := json.NewDecoder(r)
+
+	var header captureJSONHeader
+	err := decoder.Decode(&header)
+	if err != nil {
+		return start, nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	start, err = time.Parse(time.RFC3339Nano, header.Start)
+	if err != nil {
+		return start, nil, fmt.Errorf("invalid start time: %w", err)
+	}
+
+	for line := 2; ; line++ {
+		var jsonRecord captureJSONRecord
+		err := decoder.Decode(&jsonRecord)
+		if err == io.EOF {
+			return start, records, nil
+		}
+		if err != nil {
+			return start, nil, fmt.Errorf("invalid record %d: %w", line, err)
+		}
+
+		record := captureRecord{
+			time: time.Duration(math.Round(jsonRecord.TimeMs * float64(time.Millisecond))),
+		}
+
+		if len(jsonRecord.Kind) == 1 {
+			record.kind = captureRecordKind(jsonRecord.Kind[0])
+		}
+
+		switch record.kind {
+		case captureError:
+			record.data = []byte(jsonRecord.Text)
+
+		case captureReceived, captureWritten:
+			record.data, err = hex.DecodeString(strings.Join(strings.Fields(jsonRecord.Hex), ""))
+			if err != nil {
+				return start, nil, fmt.Errorf("invalid record %d: invalid hex: %w", line, err)
+			}
+
+		default:
+			return start, nil, fmt.Errorf("invalid record %d: unknown kind %q", line, jsonRecord.Kind)
+		}
+
+		if record.time < 0 {
+			return start, nil, fmt.Errorf("invalid record %d: negative time", line)
+		}
+
+		records = append(records, record)
+	}
+}
+
+// exportCaptureFrames draws the received packets with the headless renderer,
+// and saves every nth frame as a PNG file in the given directory, scaled by the given factor.
+// It returns the number of saved frames
+//
+func exportCaptureFrames(records []captureRecord, every int, scale int, dir string) (int, error) {
+	if every < 1 {
+		return 0, errors.New("invalid frame interval")
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	f := newFramebuffer()
+
+	r, err := newReplay(records, f.draw)
+	if err != nil {
+		return 0, err
+	}
+
+	var saved int
+
+	for frame := 0; r.index < len(r.packets); frame++ {
+		r.stepFrame()
+
+		if frame%every != 0 {
+			continue
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("frame-%06d.png", frame))
+
+		err = saveCaptureFrame(path, f, scale)
+		if err != nil {
+			return saved, err
+		}
+
+		saved++
+	}
+
+	return saved, nil
+}
+
+func saveCaptureFrame(path string, f *framebuffer, scale int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = png.Encode(file, f.scaledImage(scale))
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func captureUsage(flags *flag.FlagSet, usage string) {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: g0m8 capture %s\n", usage)
+		flags.PrintDefaults()
+	}
+}
+
+// runCapture runs the capture subcommand with the given arguments
+//
+func runCapture(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(
+			os.Stderr,
+			"Usage: g0m8 capture SUBCOMMAND [flags] ...\n\nSubcommands: %s\n",
+			strings.Join(captureSubcommands, ", "),
+		)
+		os.Exit(2)
+	}
+
+	subcommand := args[0]
+	flags := flag.NewFlagSet("capture "+subcommand, flag.ExitOnError)
+
+	switch subcommand {
+	case "info":
+		captureUsage(flags, "info CAPTURE")
+		_ = flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		start, records, err := readCaptureFile(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = summarizeCapture(start, records).write(os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "trim":
+		captureUsage(flags, "trim [flags] CAPTURE OUTPUT")
+		from := flags.Duration("from", 0, "keep records from the given time")
+		to := flags.Duration("to", 0, "keep records until the given time (default: until the end)")
+		first := flags.Int("first", 0, "keep records from the received packet with the given index")
+		last := flags.Int("last", -1, "keep records until the received packet with the given index (default: until the end)")
+		keepScreen := flags.Bool("keep-screen", true, "start with the screen at the start of the range, so the trimmed capture can be replayed")
+		_ = flags.Parse(args[1:])
+		if flags.NArg() != 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		start, records, err := readCaptureFile(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		trimmed, offset, err := trimCapture(records, captureRange{
+			from:        *from,
+			to:          *to,
+			firstPacket: *first,
+			lastPacket:  *last,
+			keepScreen:  *keepScreen,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = writeCaptureFile(flags.Arg(1), start.Add(offset), trimmed)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "cat":
+		captureUsage(flags, "cat CAPTURE... OUTPUT")
+		_ = flags.Parse(args[1:])
+		if flags.NArg() < 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		paths := flags.Args()
+		captures := make([]captureFile, 0, len(paths)-1)
+		for _, path := range paths[:len(paths)-1] {
+			start, records, err := readCaptureFile(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			captures = append(captures, captureFile{start, records})
+		}
+
+		start, records := concatCaptures(captures)
+
+		err := writeCaptureFile(paths[len(paths)-1], start, records)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "to-json":
+		captureUsage(flags, "to-json CAPTURE [OUTPUT]")
+		_ = flags.Parse(args[1:])
+		if flags.NArg() < 1 || flags.NArg() > 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		start, records, err := readCaptureFile(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		output := os.Stdout
+		if flags.NArg() == 2 {
+			output, err = os.Create(flags.Arg(1))
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer output.Close()
+		}
+
+		err = writeCaptureJSON(output, start, records)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "from-json":
+		captureUsage(flags, "from-json JSON OUTPUT")
+		_ = flags.Parse(args[1:])
+		if flags.NArg() != 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		start, records, err := readCaptureJSON(file)
+		_ = file.Close()
+		if err != nil {
+			log.Fatalf("%s: %s", flags.Arg(0), err)
+		}
+
+		err = writeCaptureFile(flags.Arg(1), start, records)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "to-png":
+		captureUsage(flags, "to-png [flags] CAPTURE DIRECTORY")
+		every := flags.Int("every", 1, "save every nth frame")
+		scale := flags.Int("scale", 1, "integer scale of the images")
+		_ = flags.Parse(args[1:])
+		if flags.NArg() != 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		_, records, err := readCaptureFile(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		saved, err := exportCaptureFrames(records, *every, *scale, flags.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Saved %d frames", saved)
+
+	case "render-video":
+		captureUsage(flags, "render-video [flags] CAPTURE OUTPUT")
+		rate := flags.Int("rate", 30, "frame rate of the video")
+		scale := flags.Int("scale", 2, "integer scale of the video")
+		ffmpeg := flags.String("ffmpeg", "ffmpeg", "path of ffmpeg, which encodes the video. Without it, the video is written as uncompressed Y4M")
+		audio := flags.String("audio", "", "WAV file of the audio recorded alongside the capture, see -record and -audio (default: no audio)")
+		_ = flags.Parse(args[1:])
+		if flags.NArg() != 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+
+		start, records, err := readCaptureFile(flags.Arg(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		path, frames, err := renderVideo(start, records, flags.Arg(1), *rate, *scale, *ffmpeg, *audio)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Printf("Rendered %d frames to %s", frames, path)
+
+	default:
+		log.Fatalf(
+			"unknown capture subcommand %s, expected one of %s",
+			subcommand,
+			strings.Join(captureSubcommands, ", "),
+		)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureTools(t *testing.T) {
+
+	start := time.Unix(1600000000, 0).UTC()
+
+	rectangle := []byte{drawRectangleCommand, 1, 0, 2, 0, 3, 0, 4, 0, 0xff, 0, 0x10}
+
+	records := []captureRecord{
+		{kind: captureWritten, time: 0, data: []byte{'E'}},
+		{kind: captureReceived, time: time.Millisecond, data: rectangle},
+		{kind: captureReceived, time: 2 * time.Millisecond, data: []byte{0x42}},
+		{kind: captureWritten, time: 3 * time.Millisecond, data: []byte{'C', keyEdit}},
+		{kind: captureError, time: 4 * time.Millisecond, data: []byte("SLIP protocol error")},
+		{kind: captureReceived, time: 5 * time.Millisecond, data: rectangle},
+		{kind: captureError, time: 6 * time.Millisecond, data: []byte("SLIP protocol error")},
+	}
+
+	t.Run("info", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, summarizeCapture(start, records).write(&buf))
+		require.Equal(t,
+			strings.Join([]string{
+				"start:    2020-09-13T12:26:40Z",
+				"duration: 00:00.006",
+				"records:  3 received, 2 written, 2 errors",
+				"packets:",
+				"  rectangle  2",
+				"  character  0",
+				"  waveform   0",
+				"  joypad     0",
+				"  unknown    1",
+				"  invalid    0",
+				"errors:",
+				"      2 SLIP protocol error",
+				"",
+			}, "\n"),
+			buf.String(),
+		)
+	})
+
+	t.Run("trim, all", func(t *testing.T) {
+		trimmed, offset, err := trimCapture(records, captureRange{lastPacket: -1, keepScreen: true})
+		require.NoError(t, err)
+		require.Equal(t, time.Duration(0), offset)
+		require.Equal(t, records, trimmed)
+	})
+
+	t.Run("trim, time", func(t *testing.T) {
+		trimmed, offset, err := trimCapture(records, captureRange{
+			from:       2 * time.Millisecond,
+			to:         4 * time.Millisecond,
+			lastPacket: -1,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2*time.Millisecond, offset)
+		require.Equal(t,
+			[]captureRecord{
+				{kind: captureReceived, time: 0, data: []byte{0x42}},
+				{kind: captureWritten, time: time.Millisecond, data: []byte{'C', keyEdit}},
+				{kind: captureError, time: 2 * time.Millisecond, data: []byte("SLIP protocol error")},
+			},
+			trimmed,
+		)
+	})
+
+	t.Run("trim, packets", func(t *testing.T) {
+		trimmed, offset, err := trimCapture(records, captureRange{
+			firstPacket: 1,
+			lastPacket:  2,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 2*time.Millisecond, offset)
+		require.Equal(t,
+			[]captureRecord{
+				{kind: captureReceived, time: 0, data: []byte{0x42}},
+				{kind: captureWritten, time: time.Millisecond, data: []byte{'C', keyEdit}},
+				{kind: captureError, time: 2 * time.Millisecond, data: []byte("SLIP protocol error")},
+				{kind: captureReceived, time: 3 * time.Millisecond, data: rectangle},
+			},
+			trimmed,
+		)
+	})
+
+	t.Run("trim, zero ends", func(t *testing.T) {
+		// A zero end time is unbounded, but a zero last packet is the first packet
+		trimmed, offset, err := trimCapture(records, captureRange{})
+		require.NoError(t, err)
+		require.Equal(t, time.Duration(0), offset)
+		require.Equal(t,
+			[]captureRecord{
+				{kind: captureWritten, time: 0, data: []byte{'E'}},
+				{kind: captureReceived, time: time.Millisecond, data: rectangle},
+			},
+			trimmed,
+		)
+	})
+
+	t.Run("trim, keep screen", func(t *testing.T) {
+		trimmed, _, err := trimCapture(records, captureRange{
+			firstPacket: 2,
+			lastPacket:  -1,
+			keepScreen:  true,
+		})
+		require.NoError(t, err)
+
+		// The screen before the range is drawn first: the background, and one rectangle per row
+		require.Len(t, trimmed, 1+4+2)
+
+		expected := newFramebuffer()
+		for _, record := range records {
+			if record.kind != captureReceived {
+				continue
+			}
+			command, err := decodeCommand(record.data)
+			if err == nil {
+				expected.draw(command)
+			}
+		}
+
+		f := newFramebuffer()
+		for _, record := range trimmed {
+			if record.kind != captureReceived {
+				continue
+			}
+			command, err := decodeCommand(record.data)
+			require.NoError(t, err)
+			f.draw(command)
+		}
+
+		require.Equal(t, framebufferColors(expected), framebufferColors(f))
+	})
+
+	t.Run("cat", func(t *testing.T) {
+		catStart, catRecords := concatCaptures([]captureFile{
+			{start, records[:2]},
+			{start.Add(time.Hour), records[5:]},
+		})
+		require.Equal(t, start, catStart)
+		require.Equal(t,
+			[]captureRecord{
+				records[0],
+				records[1],
+				{kind: captureReceived, time: 6 * time.Millisecond, data: rectangle},
+				{kind: captureError, time: 7 * time.Millisecond, data: []byte("SLIP protocol error")},
+			},
+			catRecords,
+		)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, writeCaptureJSON(&buf, start, records[:5]))
+		require.Equal(t,
+			strings.Join([]string{
+				`{"start":"2020-09-13T12:26:40Z"}`,
+				`{"kind":"W","time_ms":0,"hex":"45"}`,
+				`{"kind":"R","time_ms":1,"hex":"fe0100020003000400ff0010","type":"rectangle","fields":{"color":"#ff0010","height":4,"width":3,"x":1,"y":2}}`,
+				`{"kind":"R","time_ms":2,"hex":"42","type":"unknown","fields":{"command":"0x42"}}`,
+				`{"kind":"W","time_ms":3,"hex":"4301"}`,
+				`{"kind":"E","time_ms":4,"text":"SLIP protocol error"}`,
+				``,
+			}, "\n"),
+			buf.String(),
+		)
+
+		readStart, readRecords, err := readCaptureJSON(&buf)
+		require.NoError(t, err)
+		require.True(t, start.Equal(readStart))
+		require.Equal(t, records[:5], readRecords)
+	})
+
+	t.Run("JSON, edited", func(t *testing.T) {
+		_, readRecords, err := readCaptureJSON(strings.NewReader(strings.Join([]string{
+			`{"start":"2020-09-13T12:26:40.5+02:00"}`,
+			`{"kind":"R","time_ms":1.25,"hex":"fe 01 00"}`,
+		}, "\n")))
+		require.NoError(t, err)
+		require.Equal(t,
+			[]captureRecord{
+				{kind: captureReceived, time: 1250 * time.Microsecond, data: []byte{0xfe, 0x1, 0x0}},
+			},
+			readRecords,
+		)
+
+		_, _, err = readCaptureJSON(strings.NewReader(strings.Join([]string{
+			`{"start":"2020-09-13T12:26:40Z"}`,
+			`{"kind":"X","time_ms":1}`,
+		}, "\n")))
+		require.EqualError(t, err, `invalid record 2: unknown kind "X"`)
+
+		_, _, err = readCaptureJSON(strings.NewReader(strings.Join([]string{
+			`{"start":"2020-09-13T12:26:40Z"}`,
+			`{"kind":"R","time_ms":1,"hex":"xyz"}`,
+		}, "\n")))
+		require.Error(t, err)
+	})
+
+	t.Run("files", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "g0m8")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "test.cap")
+		require.NoError(t, writeCaptureFile(path, start, records))
+
+		readStart, readRecords, err := readCaptureFile(path)
+		require.NoError(t, err)
+		require.True(t, start.Equal(readStart))
+		require.Equal(t, records, readRecords)
+	})
+
+	t.Run("to PNG", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "g0m8")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		frameRecords := replayRecords(t, 10)
+
+		saved, err := exportCaptureFrames(frameRecords, 3, 2, dir)
+		require.NoError(t, err)
+		require.Equal(t, 4, saved)
+
+		files, err := filepath.Glob(filepath.Join(dir, "*.png"))
+		require.NoError(t, err)
+		require.Equal(t,
+			[]string{
+				filepath.Join(dir, "frame-000000.png"),
+				filepath.Join(dir, "frame-000003.png"),
+				filepath.Join(dir, "frame-000006.png"),
+				filepath.Join(dir, "frame-000009.png"),
+			},
+			files,
+		)
+
+		file, err := os.Open(files[0])
+		require.NoError(t, err)
+		defer file.Close()
+
+		config, err := png.DecodeConfig(file)
+		require.NoError(t, err)
+		require.Equal(t, screenWidth*2, config.Width)
+		require.Equal(t, screenHeight*2, config.Height)
+	})
+}
@@ -83,6 +83,21 @@ const drawRectangleCommandDataLength = 12
 
 func (DrawRectangleCommand) isCommand() {}
 
+// encode returns the packet of the command, like the M8 sends it
+//
+func (c DrawRectangleCommand) encode() []byte {
+	data := make([]byte, drawRectangleCommandDataLength)
+	data[0] = drawRectangleCommand
+	binary.LittleEndian.PutUint16(data[1:], uint16(c.pos.x))
+	binary.LittleEndian.PutUint16(data[3:], uint16(c.pos.y))
+	binary.LittleEndian.PutUint16(data[5:], uint16(c.size.width))
+	binary.LittleEndian.PutUint16(data[7:], uint16(c.size.height))
+	data[9] = c.color.r
+	data[10] = c.color.g
+	data[11] = c.color.b
+	return data
+}
+
 // DrawCharacterCommand
 //
 type DrawCharacterCommand struct {
@@ -115,4 +115,11 @@ func TestDecodeCommand(t *testing.T) {
 			command,
 		)
 	})
+
+	t.Run("DrawRectangleCommand, encode", func(t *testing.T) {
+		data := []byte{0xFE, 0x1, 0x2, 0x03, 0x4, 0x05, 0x6, 0x7, 0x8, 0x9, 0xA, 0xB}
+		command, err := decodeCommand(data)
+		require.NoError(t, err)
+		require.Equal(t, data, command.(DrawRectangleCommand).encode())
+	})
 }
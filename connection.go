@@ -0,0 +1,199 @@
+package main
+
+import (
+	"io"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// serialConnectionPollInterval is how long reading waits while disconnected,
+// so the main loop keeps handling input without spinning
+const serialConnectionPollInterval = 10 * time.Millisecond
+
+// serialConnection is the connection to the M8 through a serial port.
+// When reading from or writing to the port fails, e.g. because the M8 got unplugged,
+// the port is closed and periodically reopened.
+// While disconnected, reads return no data, and writes are dropped.
+// Reading and writing may happen concurrently
+//
+type serialConnection struct {
+	device string
+	open   func(device string) (io.ReadWriteCloser, error)
+	mutex  sync.Mutex
+	// port is the open port, or nil if disconnected
+	port          io.ReadWriteCloser
+	retryInterval time.Duration
+	lastAttempt   time.Time
+	// onDisconnect is called when the port got closed because of an error
+	onDisconnect func()
+	// onReconnect is called when the port got reopened,
+	// e.g. to send the initial commands
+	onReconnect func()
+	// onWritten is called with the number of bytes written to the port.
+	// Writes dropped while disconnected are not included
+	onWritten func(n int)
+}
+
+func newSerialConnection(
+	device string,
+	port io.ReadWriteCloser,
+	open func(device string) (io.ReadWriteCloser, error),
+	retryInterval time.Duration,
+) *serialConnection {
+	return &serialConnection{
+		device:        device,
+		port:          port,
+		open:          open,
+		retryInterval: retryInterval,
+	}
+}
+
+func (c *serialConnection) currentPort() io.ReadWriteCloser {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.port
+}
+
+func (c *serialConnection) connected() bool {
+	return c.currentPort() != nil
+}
+
+func (c *serialConnection) Read(data []byte) (int, error) {
+	port := c.currentPort()
+	if port == nil {
+		port = c.reconnect(time.Now())
+		if port == nil {
+			time.Sleep(serialConnectionPollInterval)
+			return 0, nil
+		}
+	}
+
+	n, err := port.Read(data)
+	if err != nil && !isWouldBlock(err) {
+		c.disconnected(port, err)
+		return n, nil
+	}
+
+	return n, nil
+}
+
+// Write writes to the port. It returns an error if the port is temporarily not writable
+//
+func (c *serialConnection) Write(data []byte) (int, error) {
+	port := c.currentPort()
+	if port == nil {
+		return len(data), nil
+	}
+
+	n, err := port.Write(data)
+	if n > 0 && c.onWritten != nil {
+		c.onWritten(n)
+	}
+	if err != nil {
+		if isWouldBlock(err) {
+			return n, err
+		}
+
+		c.disconnected(port, err)
+		return len(data), nil
+	}
+
+	return n, nil
+}
+
+// waitWritable waits until the port is writable, or the given timeout elapsed
+//
+func (c *serialConnection) waitWritable(timeout time.Duration) {
+	conn, ok := c.currentPort().(syscall.Conn)
+	if !ok {
+		time.Sleep(timeout)
+		return
+	}
+
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		time.Sleep(timeout)
+		return
+	}
+
+	_ = rawConn.Control(func(fd uintptr) {
+		fds := []unix.PollFd{{
+			Fd:     int32(fd),
+			Events: unix.POLLOUT,
+		}}
+		_, _ = unix.Poll(fds, int(timeout/time.Millisecond))
+	})
+}
+
+func (c *serialConnection) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.port == nil {
+		return nil
+	}
+
+	err := c.port.Close()
+	c.port = nil
+	return err
+}
+
+// disconnected closes the given port, which failed with the given error,
+// unless it was already closed
+//
+func (c *serialConnection) disconnected(port io.ReadWriteCloser, err error) {
+	c.mutex.Lock()
+
+	if c.port != port {
+		c.mutex.Unlock()
+		return
+	}
+
+	log.Printf("Disconnected from %s: %s", c.device, err)
+
+	_ = c.port.Close()
+	c.port = nil
+	c.lastAttempt = time.Time{}
+
+	c.mutex.Unlock()
+
+	if c.onDisconnect != nil {
+		c.onDisconnect()
+	}
+}
+
+// reconnect tries to reopen the port, if the retry interval passed since the last attempt,
+// and returns the port, or nil if it is not open
+//
+func (c *serialConnection) reconnect(now time.Time) io.ReadWriteCloser {
+	c.mutex.Lock()
+
+	if now.Sub(c.lastAttempt) < c.retryInterval {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.lastAttempt = now
+
+	port, err := c.open(c.device)
+	if err != nil {
+		c.mutex.Unlock()
+		return nil
+	}
+
+	log.Printf("Reconnected to %s", c.device)
+
+	c.port = port
+
+	c.mutex.Unlock()
+
+	if c.onReconnect != nil {
+		c.onReconnect()
+	}
+
+	return c.currentPort()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTransport is a serial port which records the written bytes,
+// and fails reading and writing once closed by the M8, e.g. when unplugged
+//
+type fakeTransport struct {
+	written bytes.Buffer
+	read    bytes.Buffer
+	failed  bool
+	closed  bool
+}
+
+var errFakeTransportUnplugged = errors.New("unplugged")
+
+func (t *fakeTransport) Read(data []byte) (int, error) {
+	if t.failed {
+		return 0, errFakeTransportUnplugged
+	}
+	return t.read.Read(data)
+}
+
+func (t *fakeTransport) Write(data []byte) (int, error) {
+	if t.failed {
+		return 0, errFakeTransportUnplugged
+	}
+	return t.written.Write(data)
+}
+
+func (t *fakeTransport) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestDisconnect(t *testing.T) {
+	var transport fakeTransport
+	disconnect(&transport)
+
+	// Releases all keys before disabling the display
+	require.Equal(t, []byte{'C', 0, 'D'}, transport.written.Bytes())
+}
+
+func TestSendNoteOn(t *testing.T) {
+	var transport fakeTransport
+
+	sendNoteOn(&transport, 60, 100)
+	// Note 0 stops the note, and has no velocity
+	sendNoteOn(&transport, 0, 100)
+	sendNoteOn(&transport, 128, 100)
+	sendNoteOn(&transport, 60, 128)
+
+	require.Equal(t, []byte{'K', 60, 100}, transport.written.Bytes())
+}
+
+func TestInputReleaseAll(t *testing.T) {
+	var transport fakeTransport
+
+	mixer := newControllerMixer(func(controller byte) {
+		sendController(&transport, controller)
+	})
+	sendInputController := mixer.source()
+	sendOtherController := mixer.source()
+
+	var i input
+	i.update(keySelect, true, sendInputController)
+	i.update(keyEdit, true, sendInputController)
+	sendOtherController(keyUp)
+
+	transport.written.Reset()
+
+	// E.g. the window lost focus while SHIFT and EDIT were held
+	i.releaseAll(sendInputController)
+
+	require.Equal(t, uint8(0), i.input)
+	// Keys held by other sources stay pressed
+	require.Equal(t, []byte{'C', keyUp}, transport.written.Bytes())
+
+	sendOtherController(0)
+	transport.written.Reset()
+
+	i.update(keyLeft, true, sendInputController)
+	require.Equal(t, []byte{'C', keyLeft}, transport.written.Bytes())
+}
+
+func TestSerialConnection(t *testing.T) {
+
+	first := &fakeTransport{}
+	first.read.WriteString("abc")
+
+	second := &fakeTransport{}
+	second.read.WriteString("def")
+
+	var opened int
+	var openErr error
+	open := func(device string) (io.ReadWriteCloser, error) {
+		require.Equal(t, "/dev/m8", device)
+		if openErr != nil {
+			return nil, openErr
+		}
+		opened++
+		return second, nil
+	}
+
+	connection := newSerialConnection("/dev/m8", first, open, 0)
+
+	var disconnects int
+	connection.onDisconnect = func() {
+		disconnects++
+	}
+
+	connection.onReconnect = func() {
+		enableAndResetDisplay(connection)
+		sendController(connection, 0)
+	}
+
+	buf := make([]byte, 16)
+
+	n, err := connection.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(buf[:n]))
+
+	sendController(connection, keyEdit)
+	require.Equal(t, []byte{'C', keyEdit}, first.written.Bytes())
+
+	// The M8 gets unplugged
+
+	first.failed = true
+
+	n, err = connection.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.True(t, first.closed)
+	require.False(t, connection.connected())
+	require.Equal(t, 1, disconnects)
+
+	// Writes are dropped while disconnected
+
+	openErr = errors.New("not found")
+
+	sendController(connection, keyOpt)
+
+	n, err = connection.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.Equal(t, 0, opened)
+
+	// The M8 gets plugged in again
+
+	openErr = nil
+
+	n, err = connection.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "def", string(buf[:n]))
+	require.Equal(t, 1, opened)
+	require.True(t, connection.connected())
+
+	// After reconnecting, the display is enabled, and all keys are released
+	require.Equal(t, []byte{'E', 'R', 'C', 0}, second.written.Bytes())
+
+	second.written.Reset()
+	disconnect(connection)
+	require.Equal(t, []byte{'C', 0, 'D'}, second.written.Bytes())
+
+	require.NoError(t, connection.Close())
+	require.True(t, second.closed)
+}
+
+func TestReconnectReleasesKeys(t *testing.T) {
+
+	first := &fakeTransport{}
+	second := &fakeTransport{}
+	second.read.WriteString("abc")
+
+	connection := newSerialConnection(
+		"/dev/m8",
+		first,
+		func(string) (io.ReadWriteCloser, error) {
+			return second, nil
+		},
+		0,
+	)
+
+	mixer := newControllerMixer(func(controller byte) {
+		sendController(connection, controller)
+	})
+	sendInputController := mixer.source()
+	sendOtherController := mixer.source()
+
+	var i input
+	mixer.addReset(i.reset)
+
+	connection.onReconnect = func() {
+		enableAndResetDisplay(connection)
+		mixer.reset()
+	}
+
+	// EDIT is held when the M8 gets unplugged
+
+	i.update(keyEdit, true, sendInputController)
+	sendOtherController(keyUp)
+
+	first.failed = true
+
+	buf := make([]byte, 16)
+	_, err := connection.Read(buf)
+	require.NoError(t, err)
+	require.False(t, connection.connected())
+
+	_, err = connection.Read(buf)
+	require.NoError(t, err)
+	require.True(t, connection.connected())
+
+	require.Equal(t, []byte{'E', 'R', 'C', 0}, second.written.Bytes())
+
+	// The next key press does not press the keys which were held before again
+
+	second.written.Reset()
+	i.update(keyLeft, true, sendInputController)
+	require.Equal(t, []byte{'C', keyLeft}, second.written.Bytes())
+}
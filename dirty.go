@@ -0,0 +1,101 @@
+package main
+
+import (
+	"image"
+)
+
+// dirtyRegionMaxRects is the maximum number of rectangles a dirty region tracks separately.
+// When more are added, all are merged into their bounds
+const dirtyRegionMaxRects = 16
+
+// dirtyRegion tracks the areas of the screen which changed since the last render
+//
+type dirtyRegion struct {
+	rects []image.Rectangle
+}
+
+// add marks the given rectangle as changed.
+// Rectangles which overlap or touch are merged
+//
+func (d *dirtyRegion) add(rect image.Rectangle) {
+	if rect.Empty() {
+		return
+	}
+
+	// Merge until no more rectangles overlap,
+	// as the merged rectangle might overlap rectangles it did not before
+	for merged := true; merged; {
+		merged = false
+		for i, other := range d.rects {
+			if !touches(rect, other) {
+				continue
+			}
+
+			rect = rect.Union(other)
+
+			last := len(d.rects) - 1
+			d.rects[i] = d.rects[last]
+			d.rects = d.rects[:last]
+
+			merged = true
+			break
+		}
+	}
+
+	d.rects = append(d.rects, rect)
+
+	if len(d.rects) > dirtyRegionMaxRects {
+		d.rects = append(d.rects[:0], d.bounds())
+	}
+}
+
+// touches returns true if the given rectangles overlap or are adjacent
+//
+func touches(a, b image.Rectangle) bool {
+	return a.Min.X <= b.Max.X &&
+		b.Min.X <= a.Max.X &&
+		a.Min.Y <= b.Max.Y &&
+		b.Min.Y <= a.Max.Y
+}
+
+func (d *dirtyRegion) empty() bool {
+	return len(d.rects) == 0
+}
+
+func (d *dirtyRegion) reset() {
+	d.rects = d.rects[:0]
+}
+
+// bounds returns the rectangle which contains all changed areas
+//
+func (d *dirtyRegion) bounds() image.Rectangle {
+	var result image.Rectangle
+	for _, rect := range d.rects {
+		result = result.Union(rect)
+	}
+	return result
+}
+
+// commandBounds returns the area of the screen the given command draws to
+//
+func commandBounds(command Command) image.Rectangle {
+	switch command := command.(type) {
+	case DrawRectangleCommand:
+		x := int(command.pos.x)
+		y := int(command.pos.y)
+		return image.Rect(
+			x,
+			y,
+			x+int(command.size.width),
+			y+int(command.size.height),
+		)
+
+	case DrawCharacterCommand:
+		return characterRect(int(command.pos.x), int(command.pos.y))
+
+	case DrawOscilloscopeWaveformCommand:
+		return image.Rect(0, 0, screenWidth, waveformHeight)
+	}
+
+	return image.Rectangle{}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirtyRegion(t *testing.T) {
+
+	t.Run("empty rectangles are ignored", func(t *testing.T) {
+		var region dirtyRegion
+		region.add(image.Rectangle{})
+		require.True(t, region.empty())
+	})
+
+	t.Run("separate rectangles", func(t *testing.T) {
+		var region dirtyRegion
+		region.add(image.Rect(0, 0, 10, 10))
+		region.add(image.Rect(20, 20, 30, 30))
+
+		require.Equal(t,
+			[]image.Rectangle{
+				image.Rect(0, 0, 10, 10),
+				image.Rect(20, 20, 30, 30),
+			},
+			region.rects,
+		)
+		require.Equal(t, image.Rect(0, 0, 30, 30), region.bounds())
+	})
+
+	t.Run("overlapping and adjacent rectangles are merged", func(t *testing.T) {
+		var region dirtyRegion
+		region.add(image.Rect(0, 0, 10, 10))
+		region.add(image.Rect(20, 0, 30, 10))
+		// Adjacent to the first, overlaps the second after merging
+		region.add(image.Rect(10, 5, 25, 8))
+
+		require.Equal(t,
+			[]image.Rectangle{
+				image.Rect(0, 0, 30, 10),
+			},
+			region.rects,
+		)
+	})
+
+	t.Run("too many rectangles are merged into their bounds", func(t *testing.T) {
+		var region dirtyRegion
+		for i := 0; i <= dirtyRegionMaxRects; i++ {
+			region.add(image.Rect(i*10, i*10, i*10+5, i*10+5))
+		}
+
+		require.Equal(t,
+			[]image.Rectangle{
+				image.Rect(0, 0, dirtyRegionMaxRects*10+5, dirtyRegionMaxRects*10+5),
+			},
+			region.rects,
+		)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		var region dirtyRegion
+		region.add(image.Rect(0, 0, 10, 10))
+		region.reset()
+		require.True(t, region.empty())
+		require.Equal(t, image.Rectangle{}, region.bounds())
+	})
+}
+
+func TestCommandBounds(t *testing.T) {
+
+	require.Equal(t,
+		image.Rect(10, 20, 40, 60),
+		commandBounds(DrawRectangleCommand{
+			pos:  Position{x: 10, y: 20},
+			size: Size{width: 30, height: 40},
+		}),
+	)
+
+	require.Equal(t,
+		image.Rect(9, 22, 18, 31),
+		commandBounds(DrawCharacterCommand{
+			pos: Position{x: 10, y: 20},
+		}),
+	)
+
+	require.Equal(t,
+		image.Rect(0, 0, screenWidth, waveformHeight),
+		commandBounds(DrawOscilloscopeWaveformCommand{}),
+	)
+
+	require.Equal(t,
+		image.Rectangle{},
+		commandBounds(JoypadKeyPressedStateCommand{}),
+	)
+}
+
+// TestCommandBoundsFramebuffer checks that the bounds of commands
+// contain all pixels they draw
+//
+func TestCommandBoundsFramebuffer(t *testing.T) {
+
+	commands := []Command{
+		DrawCharacterCommand{
+			c:          'W',
+			pos:        Position{x: 100, y: 100},
+			foreground: Color{r: 0xff},
+			background: Color{g: 0xff},
+		},
+		DrawRectangleCommand{
+			pos:   Position{x: 5, y: 50},
+			size:  Size{width: 20, height: 3},
+			color: Color{b: 0xff},
+		},
+		DrawOscilloscopeWaveformCommand{
+			color:    Color{r: 0xff, g: 0xff},
+			waveform: make([]byte, screenWidth),
+		},
+	}
+
+	for _, command := range commands {
+		f := newFramebuffer()
+		f.draw(command)
+
+		bounds := commandBounds(command)
+
+		for y := 0; y < screenHeight; y++ {
+			for x := 0; x < screenWidth; x++ {
+				if f.at(x, y) == (Color{}) {
+					continue
+				}
+				require.True(t,
+					image.Pt(x, y).In(bounds),
+					"%T draws %d,%d outside of %s", command, x, y, bounds,
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// Effects are applied when compositing the screen to the window:
+//
+// scanlines  darkens the lower half of each pixel row, like a CRT
+// curvature  bends the screen, like the glass of a CRT
+// glow       adds a blurred copy of the screen, like the phosphor of a CRT
+// lcd        darkens the borders of each pixel, like the grid of an LCD
+//
+// The accelerated renderer applies them with blending and geometry,
+// the software renderer with postProcessor
+
+const scanlineIntensity = 0.4
+const lcdGridIntensity = 0.35
+const glowIntensity = 0.35
+const curvatureAmount = 0.08
+
+// effectsMaxScale is the maximum scale the software renderer processes the screen at
+const effectsMaxScale = 4
+
+type effects struct {
+	scanlines bool
+	curvature bool
+	glow      bool
+	lcd       bool
+}
+
+// parseEffects parses a comma-separated list of effects
+//
+func parseEffects(s string) (effects, error) {
+	var result effects
+
+	if s == "" {
+		return result, nil
+	}
+
+	for _, name := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "scanlines":
+			result.scanlines = true
+		case "curvature":
+			result.curvature = true
+		case "glow":
+			result.glow = true
+		case "lcd":
+			result.lcd = true
+		default:
+			return result, fmt.Errorf(
+				"invalid effect %s, expected scanlines, curvature, glow, or lcd",
+				name,
+			)
+		}
+	}
+
+	return result, nil
+}
+
+func (e effects) enabled() bool {
+	return e.scanlines || e.curvature || e.glow || e.lcd
+}
+
+// curve maps the given normalized position (-1 to 1) on the curved screen
+// to the position on the flat screen
+//
+func curve(u, v float64) (float64, float64) {
+	factor := 1 + curvatureAmount*(u*u+v*v)
+	return u * factor, v * factor
+}
+
+// effectsScale returns the scale at which the software renderer processes the screen,
+// for the given height of the scaled screen in the window
+//
+func effectsScale(height, contentHeight int) int {
+	scale := int(math.Round(float64(height) / float64(contentHeight)))
+	switch {
+	case scale < 2:
+		// Scanlines and the LCD grid need at least two pixels per pixel
+		return 2
+	case scale > effectsMaxScale:
+		return effectsMaxScale
+	}
+	return scale
+}
+
+// postProcessor applies effects to the screen in software.
+// The output is the screen scaled by an integer factor
+//
+type postProcessor struct {
+	effects effects
+	width   int
+	height  int
+	scale   int
+	// sources are the indices of the source pixels for each output pixel, or -1 if outside
+	sources []int32
+	// masks are the brightness of each output pixel, from 0 to 256
+	masks  []uint16
+	glow   []uint16
+	output *image.RGBA
+}
+
+func newPostProcessor(effects effects, width, height, scale int) *postProcessor {
+	p := &postProcessor{
+		effects: effects,
+		width:   width,
+		height:  height,
+		scale:   scale,
+		output:  image.NewRGBA(image.Rect(0, 0, width*scale, height*scale)),
+	}
+
+	if effects.glow {
+		p.glow = make([]uint16, width*height*3)
+	}
+
+	p.initMapping()
+
+	return p
+}
+
+// initMapping precomputes the source pixel and the brightness of each output pixel
+//
+func (p *postProcessor) initMapping() {
+	outputWidth := p.width * p.scale
+	outputHeight := p.height * p.scale
+
+	p.sources = make([]int32, outputWidth*outputHeight)
+	p.masks = make([]uint16, outputWidth*outputHeight)
+
+	// The fraction of a pixel which is the LCD grid line
+	gridLine := 1 / float64(p.scale)
+
+	for outputY := 0; outputY < outputHeight; outputY++ {
+		for outputX := 0; outputX < outputWidth; outputX++ {
+			index := outputY*outputWidth + outputX
+
+			// Position in source pixels
+			x := (float64(outputX) + 0.5) / float64(p.scale)
+			y := (float64(outputY) + 0.5) / float64(p.scale)
+
+			if p.effects.curvature {
+				u := x/float64(p.width)*2 - 1
+				v := y/float64(p.height)*2 - 1
+				u, v = curve(u, v)
+
+				if u < -1 || u >= 1 || v < -1 || v >= 1 {
+					p.sources[index] = -1
+					continue
+				}
+
+				x = (u + 1) / 2 * float64(p.width)
+				y = (v + 1) / 2 * float64(p.height)
+			}
+
+			p.sources[index] = int32(int(y)*p.width + int(x))
+
+			// Position within the source pixel
+			fractionX := x - math.Floor(x)
+			fractionY := y - math.Floor(y)
+
+			mask := 1.0
+
+			if p.effects.scanlines && fractionY >= 0.5 {
+				mask *= 1 - scanlineIntensity
+			}
+
+			if p.effects.lcd && (fractionX >= 1-gridLine || fractionY >= 1-gridLine) {
+				mask *= 1 - lcdGridIntensity
+			}
+
+			p.masks[index] = uint16(mask * 256)
+		}
+	}
+}
+
+// blur computes the glow of the given rectangle, the 3x3 box blur of the given image,
+// scaled by the glow intensity
+//
+func (p *postProcessor) blur(source *image.RGBA, rect image.Rectangle) {
+	intensity := int(math.Round(glowIntensity * 256))
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			var sums [3]int
+			var count int
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					sx := x + dx
+					sy := y + dy
+					if sx < 0 || sx >= p.width || sy < 0 || sy >= p.height {
+						continue
+					}
+
+					offset := sy*source.Stride + sx*4
+					sums[0] += int(source.Pix[offset])
+					sums[1] += int(source.Pix[offset+1])
+					sums[2] += int(source.Pix[offset+2])
+					count++
+				}
+			}
+
+			index := (y*p.width + x) * 3
+			for i, sum := range sums {
+				p.glow[index+i] = uint16(sum / count * intensity / 256)
+			}
+		}
+	}
+}
+
+// process applies the effects to the given image,
+// which must have the size of the post processor
+//
+func (p *postProcessor) process(source *image.RGBA) *image.RGBA {
+	p.processRect(source, image.Rect(0, 0, p.width, p.height))
+	return p.output
+}
+
+// processRect applies the effects to the given changed rectangle of the given image,
+// which must have the size of the post processor, and returns the changed rectangle of the output.
+// With curvature the whole image is processed, as the mapping is not rectangular
+//
+func (p *postProcessor) processRect(source *image.RGBA, rect image.Rectangle) image.Rectangle {
+	bounds := image.Rect(0, 0, p.width, p.height)
+
+	if p.effects.curvature {
+		rect = bounds
+	}
+
+	if p.effects.glow {
+		// The glow of the neighbouring pixels changes, too
+		rect = rect.Inset(-1).Intersect(bounds)
+		p.blur(source, rect)
+	}
+
+	outputRect := image.Rect(
+		rect.Min.X*p.scale,
+		rect.Min.Y*p.scale,
+		rect.Max.X*p.scale,
+		rect.Max.Y*p.scale,
+	)
+
+	output := p.output.Pix
+	outputWidth := p.width * p.scale
+
+	for outputY := outputRect.Min.Y; outputY < outputRect.Max.Y; outputY++ {
+		for outputX := outputRect.Min.X; outputX < outputRect.Max.X; outputX++ {
+			index := outputY*outputWidth + outputX
+			offset := index * 4
+
+			sourceIndex := p.sources[index]
+			if sourceIndex < 0 {
+				output[offset] = 0
+				output[offset+1] = 0
+				output[offset+2] = 0
+				output[offset+3] = math.MaxUint8
+				continue
+			}
+
+			sourceX := int(sourceIndex) % p.width
+			sourceY := int(sourceIndex) / p.width
+			sourceOffset := sourceY*source.Stride + sourceX*4
+
+			mask := uint32(p.masks[index])
+
+			for i := 0; i < 3; i++ {
+				value := uint32(source.Pix[sourceOffset+i]) * mask / 256
+				if p.glow != nil {
+					value += uint32(p.glow[int(sourceIndex)*3+i])
+				}
+				if value > math.MaxUint8 {
+					value = math.MaxUint8
+				}
+				output[offset+i] = uint8(value)
+			}
+
+			output[offset+3] = math.MaxUint8
+		}
+	}
+
+	return outputRect
+}
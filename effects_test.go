@@ -0,0 +1,159 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEffects(t *testing.T) {
+
+	result, err := parseEffects("")
+	require.NoError(t, err)
+	require.False(t, result.enabled())
+
+	result, err = parseEffects("scanlines, GLOW,lcd")
+	require.NoError(t, err)
+	require.Equal(t,
+		effects{
+			scanlines: true,
+			glow:      true,
+			lcd:       true,
+		},
+		result,
+	)
+	require.True(t, result.enabled())
+
+	_, err = parseEffects("scanlines,blur")
+	require.Error(t, err)
+}
+
+func TestEffectsScale(t *testing.T) {
+	require.Equal(t, 2, effectsScale(240, screenHeight))
+	require.Equal(t, 2, effectsScale(480, screenHeight))
+	require.Equal(t, 3, effectsScale(700, screenHeight))
+	require.Equal(t, 4, effectsScale(2160, screenHeight))
+}
+
+func newUniformImage(width, height int, value uint8) *image.RGBA {
+	result := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			result.SetRGBA(x, y, color.RGBA{R: value, G: value, B: value, A: 0xff})
+		}
+	}
+	return result
+}
+
+func requireGray(t *testing.T, expected uint8, img *image.RGBA, x, y int) {
+	require.Equal(t,
+		color.RGBA{R: expected, G: expected, B: expected, A: 0xff},
+		img.RGBAAt(x, y),
+		"pixel %d,%d", x, y,
+	)
+}
+
+func TestPostProcessor(t *testing.T) {
+
+	t.Run("no effects", func(t *testing.T) {
+		source := image.NewRGBA(image.Rect(0, 0, 2, 1))
+		source.SetRGBA(0, 0, color.RGBA{R: 1, G: 2, B: 3, A: 0xff})
+		source.SetRGBA(1, 0, color.RGBA{R: 4, G: 5, B: 6, A: 0xff})
+
+		output := newPostProcessor(effects{}, 2, 1, 2).process(source)
+
+		require.Equal(t, image.Rect(0, 0, 4, 2), output.Bounds())
+		for y := 0; y < 2; y++ {
+			require.Equal(t, color.RGBA{R: 1, G: 2, B: 3, A: 0xff}, output.RGBAAt(0, y))
+			require.Equal(t, color.RGBA{R: 1, G: 2, B: 3, A: 0xff}, output.RGBAAt(1, y))
+			require.Equal(t, color.RGBA{R: 4, G: 5, B: 6, A: 0xff}, output.RGBAAt(2, y))
+			require.Equal(t, color.RGBA{R: 4, G: 5, B: 6, A: 0xff}, output.RGBAAt(3, y))
+		}
+	})
+
+	t.Run("scanlines", func(t *testing.T) {
+		output := newPostProcessor(effects{scanlines: true}, 2, 2, 2).
+			process(newUniformImage(2, 2, 200))
+
+		for x := 0; x < 4; x++ {
+			requireGray(t, 200, output, x, 0)
+			requireGray(t, 119, output, x, 1)
+			requireGray(t, 200, output, x, 2)
+			requireGray(t, 119, output, x, 3)
+		}
+	})
+
+	t.Run("lcd", func(t *testing.T) {
+		output := newPostProcessor(effects{lcd: true}, 2, 2, 2).
+			process(newUniformImage(2, 2, 200))
+
+		requireGray(t, 200, output, 0, 0)
+		requireGray(t, 129, output, 1, 0)
+		requireGray(t, 129, output, 0, 1)
+		// The grid is only darkened once where lines cross
+		requireGray(t, 129, output, 1, 1)
+	})
+
+	t.Run("scanlines and lcd", func(t *testing.T) {
+		output := newPostProcessor(effects{scanlines: true, lcd: true}, 2, 2, 2).
+			process(newUniformImage(2, 2, 200))
+
+		requireGray(t, 200, output, 0, 0)
+		requireGray(t, 77, output, 1, 1)
+	})
+
+	t.Run("glow", func(t *testing.T) {
+		output := newPostProcessor(effects{glow: true}, 2, 2, 2).
+			process(newUniformImage(2, 2, 100))
+
+		requireGray(t, 135, output, 0, 0)
+
+		// Saturates
+		output = newPostProcessor(effects{glow: true}, 2, 2, 2).
+			process(newUniformImage(2, 2, 200))
+
+		requireGray(t, 0xff, output, 0, 0)
+	})
+
+	t.Run("curvature", func(t *testing.T) {
+		output := newPostProcessor(effects{curvature: true}, 8, 8, 2).
+			process(newUniformImage(8, 8, 100))
+
+		// Corners are outside of the curved screen
+		requireGray(t, 0, output, 0, 0)
+		requireGray(t, 0, output, 15, 15)
+
+		requireGray(t, 100, output, 8, 8)
+	})
+}
+
+func TestPostProcessorRect(t *testing.T) {
+
+	for _, enabled := range []effects{
+		{scanlines: true, lcd: true},
+		{glow: true},
+		{curvature: true, glow: true},
+	} {
+		source := newUniformImage(8, 8, 100)
+
+		processor := newPostProcessor(enabled, 8, 8, 2)
+		processor.process(source)
+
+		source.SetRGBA(3, 4, color.RGBA{R: 0xff, A: 0xff})
+		changed := processor.processRect(source, image.Rect(3, 4, 4, 5))
+
+		expected := newPostProcessor(enabled, 8, 8, 2).process(source)
+		require.Equal(t, expected.Pix, processor.output.Pix, "%+v", enabled)
+
+		switch {
+		case enabled.curvature:
+			require.Equal(t, image.Rect(0, 0, 16, 16), changed)
+		case enabled.glow:
+			require.Equal(t, image.Rect(4, 6, 10, 12), changed)
+		default:
+			require.Equal(t, image.Rect(6, 8, 8, 10), changed)
+		}
+	}
+}
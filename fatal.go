@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// fatalHandlers are called with the message of a fatal error, before exiting,
+// e.g. to dump the flight recorder and to flush the session recording,
+// as exiting does not run deferred functions
+var fatalHandlers []func(message string)
+
+// onFatal registers the given handler, which is called before exiting because of a fatal error.
+// Handlers are called in reverse order of their registration, like deferred functions
+//
+func onFatal(handler func(message string)) {
+	fatalHandlers = append(fatalHandlers, handler)
+}
+
+func runFatalHandlers(message string) {
+	handlers := fatalHandlers
+	// Handlers which fail fatally must not run the handlers again
+	fatalHandlers = nil
+
+	for i := len(handlers) - 1; i >= 0; i-- {
+		handlers[i](message)
+	}
+}
+
+// fatal is like log.Fatal, but calls the fatal handlers before exiting
+//
+func fatal(v ...interface{}) {
+	message := fmt.Sprint(v...)
+	runFatalHandlers(message)
+	log.Fatal(message)
+}
+
+// fatalf is like log.Fatalf, but calls the fatal handlers before exiting
+//
+func fatalf(format string, v ...interface{}) {
+	fatal(fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFatalHandlers(t *testing.T) {
+
+	defer func() {
+		fatalHandlers = nil
+	}()
+
+	var calls []string
+
+	onFatal(func(message string) {
+		calls = append(calls, "first: "+message)
+	})
+	onFatal(func(message string) {
+		calls = append(calls, "second: "+message)
+		// Handlers are not run again
+		runFatalHandlers("again")
+	})
+
+	runFatalHandlers("failed")
+
+	require.Equal(t, []string{"second: failed", "first: failed"}, calls)
+	require.Empty(t, fatalHandlers)
+}
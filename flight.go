@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// flightRecorderCooldown is the minimum time between automatic dumps,
+// so repeated errors do not create a capture file each
+const flightRecorderCooldown = 10 * time.Second
+
+type flightRecord struct {
+	kind captureRecordKind
+	time time.Time
+	data []byte
+}
+
+// flightRecorder keeps the most recent records in a ring buffer,
+// which can be dumped to a capture file, e.g. when an error occurs
+//
+type flightRecorder struct {
+	mutex    sync.Mutex
+	records  []flightRecord
+	next     int
+	full     bool
+	dir      string
+	lastDump time.Time
+}
+
+func newFlightRecorder(size int, dir string) *flightRecorder {
+	return &flightRecorder{
+		records: make([]flightRecord, size),
+		dir:     dir,
+	}
+}
+
+// record adds a record. The data is copied
+//
+func (f *flightRecorder) record(kind captureRecordKind, now time.Time, data []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	record := &f.records[f.next]
+	record.kind = kind
+	record.time = now
+	// Reuse the buffer of the overwritten record
+	record.data = append(record.data[:0], data...)
+
+	f.next++
+	if f.next == len(f.records) {
+		f.next = 0
+		f.full = true
+	}
+}
+
+// each calls the given function for each record, from the oldest to the most recent
+//
+func (f *flightRecorder) each(fn func(record flightRecord)) {
+	if f.full {
+		for _, record := range f.records[f.next:] {
+			fn(record)
+		}
+	}
+	for _, record := range f.records[:f.next] {
+		fn(record)
+	}
+}
+
+// dump writes the records to a new capture file in the directory of the recorder,
+// named after the given time, and returns its path
+//
+func (f *flightRecorder) dump(now time.Time) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.lastDump = now
+
+	err := os.MkdirAll(f.dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(
+		f.dir,
+		fmt.Sprintf("g0m8-%s.cap", now.Format("20060102-150405.000")),
+	)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	start := now
+	if f.full {
+		start = f.records[f.next].time
+	} else if f.next > 0 {
+		start = f.records[0].time
+	}
+
+	writer, err := newCaptureWriter(file, start)
+	if err != nil {
+		return "", err
+	}
+
+	f.each(func(record flightRecord) {
+		if err != nil {
+			return
+		}
+		err = writer.writeAt(record.kind, record.time, record.data)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	err = writer.flush()
+	if err != nil {
+		return "", err
+	}
+
+	return path, file.Close()
+}
+
+// dumpOnError dumps the records, unless the last dump was recently,
+// and returns the path of the capture file, or an empty path if it did not dump
+//
+func (f *flightRecorder) dumpOnError(now time.Time) (string, error) {
+	f.mutex.Lock()
+	recent := !f.lastDump.IsZero() && now.Sub(f.lastDump) < flightRecorderCooldown
+	f.mutex.Unlock()
+
+	if recent {
+		return "", nil
+	}
+
+	return f.dump(now)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlightRecorder(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "g0m8-flight")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Unix(1600000000, 0)
+
+	recorder := newFlightRecorder(3, dir)
+
+	readDump := func(path string) (time.Time, []captureRecord) {
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+
+		dumpStart, records, err := readCapture(file)
+		require.NoError(t, err)
+		return dumpStart, records
+	}
+
+	// Not full yet
+
+	recorder.record(captureReceived, start, []byte{1})
+	recorder.record(captureWritten, start.Add(time.Second), []byte{2})
+
+	path, err := recorder.dump(start.Add(2 * time.Second))
+	require.NoError(t, err)
+	require.Equal(t, dir, filepath.Dir(path))
+	require.Equal(t, ".cap", filepath.Ext(path))
+
+	dumpStart, records := readDump(path)
+	require.True(t, start.Equal(dumpStart))
+	require.Equal(t,
+		[]captureRecord{
+			{kind: captureReceived, time: 0, data: []byte{1}},
+			{kind: captureWritten, time: time.Second, data: []byte{2}},
+		},
+		records,
+	)
+
+	// Wraps around, keeping the most recent records
+
+	data := []byte{3}
+	recorder.record(captureReceived, start.Add(3*time.Second), data)
+	// The data is copied
+	data[0] = 0xff
+	recorder.record(captureError, start.Add(4*time.Second), []byte("error"))
+
+	path, err = recorder.dumpOnError(start.Add(20 * time.Second))
+	require.NoError(t, err)
+
+	dumpStart, records = readDump(path)
+	require.True(t, start.Add(time.Second).Equal(dumpStart))
+	require.Equal(t,
+		[]captureRecord{
+			{kind: captureWritten, time: 0, data: []byte{2}},
+			{kind: captureReceived, time: 2 * time.Second, data: []byte{3}},
+			{kind: captureError, time: 3 * time.Second, data: []byte("error")},
+		},
+		records,
+	)
+
+	// Errors shortly after a dump do not dump again
+
+	path, err = recorder.dumpOnError(start.Add(25 * time.Second))
+	require.NoError(t, err)
+	require.Empty(t, path)
+
+	path, err = recorder.dumpOnError(start.Add(20*time.Second + flightRecorderCooldown))
+	require.NoError(t, err)
+	require.NotEmpty(t, path)
+}
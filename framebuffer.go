@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// framebuffer is a software renderer,
+// which draws M8 commands into an in-memory image,
+// exactly like sdlRenderer draws them to the screen.
+// Characters are placed by the same functions, see glyph.go,
+// and the waveform is drawn from the same spans, see waveform.go
+//
+type framebuffer struct {
+	backgroundColor Color
+	image           *image.RGBA
+	waveformStyle   waveformStyle
+	waveformSpans   []waveformSpan
+}
+
+func newFramebuffer() *framebuffer {
+	return &framebuffer{
+		image:         image.NewRGBA(image.Rect(0, 0, screenWidth, screenHeight)),
+		waveformStyle: waveformPoints,
+	}
+}
+
+func (f *framebuffer) draw(command Command) {
+	switch command := command.(type) {
+	case DrawRectangleCommand:
+		f.drawRectangle(command)
+
+	case DrawCharacterCommand:
+		f.drawCharacter(command)
+
+	case DrawOscilloscopeWaveformCommand:
+		f.drawWaveform(command)
+	}
+}
+
+// saveScreenshot saves the image as a PNG file in the given directory,
+// named after the given time, and returns its path
+//
+func (f *framebuffer) saveScreenshot(dir string, now time.Time) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(
+		dir,
+		fmt.Sprintf("g0m8-%s.png", now.Format("20060102-150405.000")),
+	)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	err = png.Encode(file, f.image)
+	if err != nil {
+		return "", err
+	}
+
+	return path, file.Close()
+}
+
+// scaledImage returns the image, scaled by the given integer factor
+//
+func (f *framebuffer) scaledImage(scale int) *image.RGBA {
+	if scale <= 1 {
+		return f.image
+	}
+
+	bounds := f.image.Bounds()
+	scaled := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale))
+
+	for y := 0; y < scaled.Rect.Dy(); y++ {
+		row := f.image.Pix[(y/scale)*f.image.Stride:]
+		offset := y * scaled.Stride
+		for x := 0; x < scaled.Rect.Dx(); x++ {
+			copy(scaled.Pix[offset+x*4:offset+x*4+4], row[(x/scale)*4:])
+		}
+	}
+
+	return scaled
+}
+
+// at returns the color of the pixel at the given position
+//
+func (f *framebuffer) at(x, y int) Color {
+	c := f.image.RGBAAt(x, y)
+	return Color{
+		r: c.R,
+		g: c.G,
+		b: c.B,
+	}
+}
+
+func (f *framebuffer) set(x, y int, c Color) {
+	f.image.SetRGBA(x, y, color.RGBA{
+		R: c.r,
+		G: c.g,
+		B: c.b,
+		A: math.MaxUint8,
+	})
+}
+
+func (f *framebuffer) fillRect(rect image.Rectangle, c Color) {
+	rect = rect.Intersect(f.image.Rect)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			f.set(x, y, c)
+		}
+	}
+}
+
+func (f *framebuffer) drawCharacter(command DrawCharacterCommand) {
+	x := int(command.pos.x)
+	y := int(command.pos.y)
+
+	if command.background != command.foreground {
+		f.fillRect(characterBackgroundRect(x, y), command.background)
+	}
+
+	glyph := characterGlyphRect(x, y)
+	bounds := f.image.Rect
+
+	for dy := 0; dy < glyph.Dy(); dy++ {
+		for dx := 0; dx < glyph.Dx(); dx++ {
+			if !fontPixel(command.c, dx, dy) {
+				continue
+			}
+
+			p := glyph.Min.Add(image.Pt(dx, dy))
+			if !p.In(bounds) {
+				continue
+			}
+
+			f.set(p.X, p.Y, command.foreground)
+		}
+	}
+}
+
+func (f *framebuffer) drawRectangle(command DrawRectangleCommand) {
+	if command.pos.x == 0 &&
+		command.pos.y == 0 &&
+		command.size.width == screenWidth &&
+		command.size.height == screenHeight {
+
+		f.backgroundColor = command.color
+	}
+
+	x := int(command.pos.x)
+	y := int(command.pos.y)
+
+	f.fillRect(
+		image.Rect(
+			x,
+			y,
+			x+int(command.size.width),
+			y+int(command.size.height),
+		),
+		command.color,
+	)
+}
+
+func (f *framebuffer) drawWaveform(command DrawOscilloscopeWaveformCommand) {
+	f.fillRect(
+		image.Rect(0, 0, screenWidth, waveformHeight),
+		f.backgroundColor,
+	)
+
+	f.waveformSpans = appendWaveformSpans(f.waveformSpans[:0], f.waveformStyle, command.waveform)
+
+	for _, span := range f.waveformSpans {
+		f.fillRect(
+			image.Rect(span.x, span.top, span.x+1, span.bottom+1),
+			command.color,
+		)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "image"
+
+// The placement of characters is shared by all renderers,
+// so the headless renderer, and thus the golden-image tests, draw exactly like sdlRenderer
+
+// characterBackgroundRect returns the rectangle which is filled with the background color
+// of a character drawn at the given position.
+// The background starts one pixel left and above of the glyph
+//
+func characterBackgroundRect(x, y int) image.Rectangle {
+	return image.Rect(
+		x-1,
+		y+2,
+		x-1+fontCharWidth-1,
+		y+2+fontCharHeight+1,
+	)
+}
+
+// characterGlyphRect returns the rectangle which the glyph of a character
+// drawn at the given position is drawn into
+//
+func characterGlyphRect(x, y int) image.Rectangle {
+	return image.Rect(
+		x,
+		y+3,
+		x+fontCharWidth,
+		y+3+fontCharHeight,
+	)
+}
+
+// characterRect returns the rectangle which is changed by drawing a character at the given position
+//
+func characterRect(x, y int) image.Rectangle {
+	return characterBackgroundRect(x, y).Union(characterGlyphRect(x, y))
+}
+
+// fontGlyphRect returns the rectangle of the glyph for the given character in the font
+//
+func fontGlyphRect(c byte) image.Rectangle {
+	row := int(c / fontCharsByRow)
+	column := int(c % fontCharsByRow)
+
+	return image.Rect(
+		column*fontCharWidth,
+		row*fontCharHeight,
+		(column+1)*fontCharWidth,
+		(row+1)*fontCharHeight,
+	)
+}
+
+// fontPixel returns true if the pixel at the given position
+// of the glyph for the given character is set
+//
+func fontPixel(c byte, x, y int) bool {
+	glyph := fontGlyphRect(c)
+
+	index := (glyph.Min.Y+y)*fontWidth + glyph.Min.X + x
+	if index/8 >= len(fontData) {
+		return false
+	}
+
+	return fontData[index/8]&(1<<(index%8)) == 0
+}
@@ -5,5 +5,6 @@ go 1.14
 require (
 	github.com/stretchr/testify v1.4.0
 	github.com/veandco/go-sdl2 v0.4.20
+	github.com/yuin/gopher-lua v1.1.1
 	golang.org/x/sys v0.0.0-20220513210249-45d2b4557a2a
 )
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The golden-image tests render each capture in testdata/golden with the headless renderer,
+// and compare the final screen with the PNG of the same name.
+// The headless renderer places characters with the same functions as sdlRenderer (see glyph.go),
+// so changes of the glyph offsets are caught.
+//
+// To add a test case, record a session with -record, trim it with g0m8 capture trim,
+// and generate the PNG with: go test -run TestGolden -update-golden
+//
+// Captures in goldenWaveformStyleCaptures are also rendered with the other waveform styles,
+// and compared with the PNG named after the capture and the style, e.g. waveform-lines.png.
+//
+// When the screen changes, the test writes the actual screen and a diff image
+// into the directory given by -golden-output (default: a temporary directory)
+
+const goldenDir = "testdata/golden"
+
+var updateGoldenFlag = flag.Bool("update-golden", false, "update the expected PNGs of the golden-image tests")
+var goldenOutputFlag = flag.String("golden-output", "", "directory to write the actual and diff images of failed golden-image tests to (default: temporary directory)")
+
+var goldenWaveformStyleCaptures = []string{"waveform"}
+
+var goldenWaveformStyles = []waveformStyle{
+	waveformLines,
+	waveformFilled,
+	waveformThick,
+}
+
+// renderGoldenCapture draws all received packets of the capture with the headless renderer,
+// and the given waveform style, skipping invalid packets, like in live mode
+//
+func renderGoldenCapture(t *testing.T, path string, style waveformStyle) *image.RGBA {
+	_, records, err := readCaptureFile(path)
+	require.NoError(t, err)
+
+	f := newFramebuffer()
+	f.waveformStyle = style
+
+	for _, record := range records {
+		if record.kind != captureReceived {
+			continue
+		}
+
+		command, err := decodeCommand(record.data)
+		if err != nil {
+			continue
+		}
+
+		f.draw(command)
+	}
+
+	return f.image
+}
+
+func readPNG(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return png.Decode(file)
+}
+
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = png.Encode(file, img)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// goldenDiff compares the images, and returns the number of different pixels,
+// their bounds, and an image with the different pixels in red,
+// over a dimmed grayscale version of the expected image
+//
+func goldenDiff(expected image.Image, actual *image.RGBA) (int, image.Rectangle, *image.RGBA) {
+	bounds := actual.Bounds().Union(expected.Bounds())
+	diff := image.NewRGBA(bounds)
+
+	var count int
+	var changed image.Rectangle
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			point := image.Pt(x, y)
+
+			var expectedColor, actualColor color.RGBA
+			if point.In(expected.Bounds()) {
+				expectedColor = color.RGBAModel.Convert(expected.At(x, y)).(color.RGBA)
+			}
+			if point.In(actual.Bounds()) {
+				actualColor = actual.RGBAAt(x, y)
+			}
+
+			if expectedColor == actualColor {
+				gray := color.GrayModel.Convert(expectedColor).(color.Gray)
+				dimmed := gray.Y / 3
+				diff.SetRGBA(x, y, color.RGBA{R: dimmed, G: dimmed, B: dimmed, A: 0xff})
+				continue
+			}
+
+			count++
+			changed = changed.Union(image.Rect(x, y, x+1, y+1))
+			diff.SetRGBA(x, y, color.RGBA{R: 0xff, A: 0xff})
+		}
+	}
+
+	return count, changed, diff
+}
+
+func TestGolden(t *testing.T) {
+
+	paths, err := filepath.Glob(filepath.Join(goldenDir, "*.cap"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	outputDir := *goldenOutputFlag
+
+	check := func(t *testing.T, name string, actual *image.RGBA) {
+		expectedPath := filepath.Join(goldenDir, name+".png")
+
+		if *updateGoldenFlag {
+			require.NoError(t, writePNG(expectedPath, actual))
+			return
+		}
+
+		expected, err := readPNG(expectedPath)
+		if os.IsNotExist(err) {
+			t.Fatalf("missing %s, generate it with -update-golden", expectedPath)
+		}
+		require.NoError(t, err)
+
+		count, changed, diff := goldenDiff(expected, actual)
+		if count == 0 {
+			return
+		}
+
+		if outputDir == "" {
+			outputDir, err = ioutil.TempDir("", "g0m8-golden")
+			require.NoError(t, err)
+		}
+
+		actualPath := filepath.Join(outputDir, name+".actual.png")
+		diffPath := filepath.Join(outputDir, name+".diff.png")
+
+		require.NoError(t, os.MkdirAll(outputDir, 0755))
+		require.NoError(t, writePNG(actualPath, actual))
+		require.NoError(t, writePNG(diffPath, diff))
+
+		t.Errorf(
+			"%d pixels in %v differ from %s, see %s and %s",
+			count,
+			changed,
+			expectedPath,
+			actualPath,
+			diffPath,
+		)
+	}
+
+	for _, path := range paths {
+		path := path
+		name := strings.TrimSuffix(filepath.Base(path), ".cap")
+
+		t.Run(name, func(t *testing.T) {
+			check(t, name, renderGoldenCapture(t, path, waveformPoints))
+		})
+	}
+
+	for _, name := range goldenWaveformStyleCaptures {
+		path := filepath.Join(goldenDir, name+".cap")
+
+		for _, style := range goldenWaveformStyles {
+			name := name + "-" + string(style)
+			style := style
+
+			t.Run(name, func(t *testing.T) {
+				check(t, name, renderGoldenCapture(t, path, style))
+			})
+		}
+	}
+}
+
+func TestGoldenDiff(t *testing.T) {
+
+	expected := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	actual := image.NewRGBA(image.Rect(0, 0, 4, 2))
+
+	white := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	expected.SetRGBA(0, 0, white)
+	actual.SetRGBA(0, 0, white)
+
+	count, _, _ := goldenDiff(expected, actual)
+	require.Equal(t, 0, count)
+
+	actual.SetRGBA(1, 1, white)
+	actual.SetRGBA(3, 0, white)
+
+	count, changed, diff := goldenDiff(expected, actual)
+	require.Equal(t, 2, count)
+	require.Equal(t, image.Rect(1, 0, 4, 2), changed)
+
+	red := color.RGBA{R: 0xff, A: 0xff}
+	require.Equal(t, red, diff.RGBAAt(1, 1))
+	require.Equal(t, red, diff.RGBAAt(3, 0))
+	// Unchanged pixels are dimmed
+	require.Equal(t, color.RGBA{R: 0x55, G: 0x55, B: 0x55, A: 0xff}, diff.RGBAAt(0, 0))
+	require.Equal(t, color.RGBA{A: 0xff}, diff.RGBAAt(2, 1))
+}
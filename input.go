@@ -4,9 +4,46 @@ import (
 	"github.com/veandco/go-sdl2/sdl"
 )
 
+type inputHandler interface {
+	handle(
+		toggleFullscreen func(),
+		sendController func(uint8),
+	) bool
+	// reset forgets the pressed keys, without sending the controller state
+	reset()
+}
+
+// keyHandler handles the press or release of a key, given by its name,
+// and returns true if it handled it, i.e. the key must not be mapped to an M8 key
+//
+type keyHandler func(name string, pressed bool, repeat bool) bool
+
 type input struct {
-	input uint8
-	run   bool
+	input      uint8
+	run        bool
+	keyHandler keyHandler
+	// redraw is called when the window needs to be redrawn, e.g. when it got resized
+	redraw func()
+	// closeWindow is called when a window is closed,
+	// and returns false if the application should quit
+	closeWindow func(windowID uint32) bool
+	// focusLost is called when the window lost focus, before all keys are released,
+	// e.g. to drop keys which are held back
+	focusLost func()
+}
+
+func newInput(
+	keyHandler keyHandler,
+	redraw func(),
+	closeWindow func(windowID uint32) bool,
+	focusLost func(),
+) *input {
+	return &input{
+		keyHandler:  keyHandler,
+		redraw:      redraw,
+		closeWindow: closeWindow,
+		focusLost:   focusLost,
+	}
 }
 
 func (i *input) handle(
@@ -18,7 +55,43 @@ func (i *input) handle(
 	case *sdl.QuitEvent:
 		return false
 
+	case *sdl.WindowEvent:
+		switch event.Event {
+		case sdl.WINDOWEVENT_SIZE_CHANGED, sdl.WINDOWEVENT_EXPOSED:
+			if i.redraw != nil {
+				i.redraw()
+			}
+
+		case sdl.WINDOWEVENT_FOCUS_LOST, sdl.WINDOWEVENT_MINIMIZED:
+			// Key releases are not received while the window is not focused,
+			// so release all keys, so they do not stay pressed on the M8
+			if i.focusLost != nil {
+				i.focusLost()
+			}
+			i.releaseAll(sendController)
+
+		case sdl.WINDOWEVENT_CLOSE:
+			if i.closeWindow != nil {
+				return i.closeWindow(event.WindowID)
+			}
+		}
+
 	case *sdl.KeyboardEvent:
+		if i.keyHandler != nil &&
+			i.keyHandler(
+				sdl.GetKeyName(event.Keysym.Sym),
+				event.State == sdl.PRESSED,
+				event.Repeat != 0,
+			) {
+
+			break
+		}
+
+		// Held keys are handled by the M8, and the shortcut layer
+		if event.Repeat != 0 {
+			break
+		}
+
 		if event.Type == sdl.KEYUP {
 			switch event.Keysym.Sym {
 			case sdl.K_RETURN:
@@ -56,15 +129,87 @@ func (i *input) handle(
 			break
 		}
 
-		if event.State == sdl.PRESSED {
-			i.input |= key
-		} else {
-			// Go does not have a bitwise negation operator
-			i.input &= 255 ^ key
+		i.update(key, event.State == sdl.PRESSED, sendController)
+	}
+
+	return true
+}
+
+// update presses or releases the given keys,
+// and sends the new controller state
+//
+func (i *input) update(keys uint8, pressed bool, sendController func(uint8)) {
+	if pressed {
+		i.input |= keys
+	} else {
+		// Go does not have a bitwise negation operator
+		i.input &= 255 ^ keys
+	}
+
+	sendController(i.input)
+}
+
+// releaseAll releases all keys, and sends the new controller state
+//
+func (i *input) releaseAll(sendController func(uint8)) {
+	i.input = 0
+	sendController(i.input)
+}
+
+func (i *input) reset() {
+	i.input = 0
+}
+
+// controllerMixer combines the controller states of multiple sources,
+// e.g. the input and the automation API, and sends the combined state
+//
+type controllerMixer struct {
+	states         []uint8
+	resets         []func()
+	sendController func(uint8)
+}
+
+func newControllerMixer(sendController func(uint8)) *controllerMixer {
+	return &controllerMixer{
+		sendController: sendController,
+	}
+}
+
+// source adds a new source, and returns the function which sends its controller state
+//
+func (m *controllerMixer) source() func(uint8) {
+	index := len(m.states)
+	m.states = append(m.states, 0)
+
+	return func(controller uint8) {
+		m.states[index] = controller
+
+		var combined uint8
+		for _, state := range m.states {
+			combined |= state
 		}
 
-		sendController(i.input)
+		m.sendController(combined)
 	}
+}
 
-	return true
+// addReset adds a function which forgets the keys pressed in a source, see reset
+//
+func (m *controllerMixer) addReset(reset func()) {
+	m.resets = append(m.resets, reset)
+}
+
+// reset releases the keys of all sources, e.g. after reconnecting,
+// when the M8 might still have keys latched, and sends the released controller state
+//
+func (m *controllerMixer) reset() {
+	for _, reset := range m.resets {
+		reset()
+	}
+
+	for i := range m.states {
+		m.states[i] = 0
+	}
+
+	m.sendController(0)
 }
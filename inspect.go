@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The inspect subcommand connects to the M8 and prints every received packet,
+// decoded, and as a hex dump, either human-readable, or as JSON Lines:
+//
+//   g0m8 inspect -device /dev/ttyACM0 -commands character,unknown -region 0,0,320,16
+//
+// Command types are rectangle, character, waveform, joypad, unknown (unknown command byte),
+// and invalid (known command byte, but invalid packet).
+// The region filter only applies to commands which draw, all others are always printed
+
+const (
+	inspectRectangle = "rectangle"
+	inspectCharacter = "character"
+	inspectWaveform  = "waveform"
+	inspectJoypad    = "joypad"
+	inspectUnknown   = "unknown"
+	inspectInvalid   = "invalid"
+)
+
+var inspectCommandTypes = []string{
+	inspectRectangle,
+	inspectCharacter,
+	inspectWaveform,
+	inspectJoypad,
+	inspectUnknown,
+	inspectInvalid,
+}
+
+type inspectField struct {
+	name  string
+	value interface{}
+}
+
+// inspectedPacket is a received packet, and the command decoded from it
+//
+type inspectedPacket struct {
+	time    time.Time
+	elapsed time.Duration
+	kind    string
+	fields  []inspectField
+	bounds  *image.Rectangle
+	data    []byte
+}
+
+func inspectPacket(packet []byte) inspectedPacket {
+	result := inspectedPacket{
+		data: packet,
+	}
+
+	command, err := decodeCommand(packet)
+	if err != nil {
+		if unknown, ok := err.(unknownCommandError); ok {
+			result.kind = inspectUnknown
+			result.fields = []inspectField{
+				{"command", fmt.Sprintf("0x%02x", unknown.command)},
+			}
+		} else {
+			result.kind = inspectInvalid
+			result.fields = []inspectField{
+				{"error", err.Error()},
+			}
+		}
+		return result
+	}
+
+	switch command := command.(type) {
+	case DrawRectangleCommand:
+		result.kind = inspectRectangle
+		result.fields = []inspectField{
+			{"x", command.pos.x},
+			{"y", command.pos.y},
+			{"width", command.size.width},
+			{"height", command.size.height},
+			{"color", formatColor(command.color)},
+		}
+		bounds := image.Rect(
+			int(command.pos.x),
+			int(command.pos.y),
+			int(command.pos.x)+int(command.size.width),
+			int(command.pos.y)+int(command.size.height),
+		)
+		result.bounds = &bounds
+
+	case DrawCharacterCommand:
+		result.kind = inspectCharacter
+		result.fields = []inspectField{
+			{"char", string(glyphRune(command.c))},
+			{"x", command.pos.x},
+			{"y", command.pos.y},
+			{"foreground", formatColor(command.foreground)},
+			{"background", formatColor(command.background)},
+		}
+		bounds := image.Rect(
+			int(command.pos.x),
+			int(command.pos.y),
+			int(command.pos.x)+fontCharWidth,
+			int(command.pos.y)+fontCharHeight,
+		)
+		result.bounds = &bounds
+
+	case DrawOscilloscopeWaveformCommand:
+		result.kind = inspectWaveform
+		waveform := make([]int, len(command.waveform))
+		for i, y := range command.waveform {
+			waveform[i] = int(y)
+		}
+		result.fields = []inspectField{
+			{"color", formatColor(command.color)},
+			{"waveform", waveform},
+		}
+		bounds := image.Rect(0, 0, screenWidth, screenHeight/10)
+		result.bounds = &bounds
+
+	case JoypadKeyPressedStateCommand:
+		result.kind = inspectJoypad
+		result.fields = []inspectField{
+			{"key", fmt.Sprintf("%08b", command.key)},
+		}
+	}
+
+	return result
+}
+
+// inspectFilter selects the packets to print
+//
+type inspectFilter struct {
+	kinds  map[string]bool
+	region *image.Rectangle
+}
+
+func parseInspectCommandTypes(s string) (map[string]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	kinds := map[string]bool{}
+
+	for _, kind := range strings.Split(s, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+
+		var known bool
+		for _, commandType := range inspectCommandTypes {
+			if kind == commandType {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf(
+				"unknown command type %s, expected one of %s",
+				kind,
+				strings.Join(inspectCommandTypes, ", "),
+			)
+		}
+
+		kinds[kind] = true
+	}
+
+	return kinds, nil
+}
+
+// parseInspectRegion parses a region, given as x,y,width,height
+//
+func parseInspectRegion(s string) (*image.Rectangle, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid region %s, expected x,y,width,height", s)
+	}
+
+	var values [4]int
+	for i, part := range parts {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid region %s: %w", s, err)
+		}
+		values[i] = value
+	}
+
+	region := image.Rect(values[0], values[1], values[0]+values[2], values[1]+values[3])
+	return &region, nil
+}
+
+func (f inspectFilter) matches(packet inspectedPacket) bool {
+	if f.kinds != nil && !f.kinds[packet.kind] {
+		return false
+	}
+
+	if f.region != nil && packet.bounds != nil && !packet.bounds.Overlaps(*f.region) {
+		return false
+	}
+
+	return true
+}
+
+// inspector prints the packets which match the filter
+//
+type inspector struct {
+	writer io.Writer
+	json   bool
+	filter inspectFilter
+	start  time.Time
+}
+
+func (i *inspector) inspect(packet []byte, now time.Time) error {
+	if i.start.IsZero() {
+		i.start = now
+	}
+
+	inspected := inspectPacket(packet)
+	inspected.time = now
+	inspected.elapsed = now.Sub(i.start)
+
+	if !i.filter.matches(inspected) {
+		return nil
+	}
+
+	if i.json {
+		return i.writeJSON(inspected)
+	}
+	return i.writeText(inspected)
+}
+
+func (i *inspector) writeJSON(packet inspectedPacket) error {
+	fields := map[string]interface{}{}
+	for _, field := range packet.fields {
+		fields[field.name] = field.value
+	}
+
+	line, err := json.Marshal(struct {
+		Time      string                 `json:"time"`
+		ElapsedMs float64                `json:"elapsed_ms"`
+		Type      string                 `json:"type"`
+		Fields    map[string]interface{} `json:"fields"`
+		Hex       string                 `json:"hex"`
+	}{
+		Time:      packet.time.Format(time.RFC3339Nano),
+		ElapsedMs: float64(packet.elapsed) / float64(time.Millisecond),
+		Type:      packet.kind,
+		Fields:    fields,
+		Hex:       hex.Dump(packet.data),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(i.writer, "%s\n", line)
+	return err
+}
+
+// describe returns the kind and the fields of the packet
+//
+func (packet inspectedPacket) describe() string {
+	var builder strings.Builder
+
+	builder.WriteString(packet.kind)
+
+	switch packet.kind {
+	case inspectUnknown:
+		builder.WriteString(" !!! UNKNOWN COMMAND BYTE !!!")
+	case inspectInvalid:
+		builder.WriteString(" !!! INVALID PACKET !!!")
+	}
+
+	for _, field := range packet.fields {
+		// The waveform is in the hex dump
+		if packet.kind == inspectWaveform && field.name == "waveform" {
+			fmt.Fprintf(&builder, " length=%d", len(packet.data)-drawOscilloscopeWaveformCommandMinDataLength)
+			continue
+		}
+
+		value := field.value
+		if field.name == "char" || field.name == "error" {
+			value = strconv.Quote(value.(string))
+		}
+
+		fmt.Fprintf(&builder, " %s=%v", field.name, value)
+	}
+
+	return builder.String()
+}
+
+func (i *inspector) writeText(packet inspectedPacket) error {
+	var builder strings.Builder
+
+	fmt.Fprintf(
+		&builder,
+		"%s +%.3fms %s",
+		packet.time.Format("15:04:05.000000"),
+		float64(packet.elapsed)/float64(time.Millisecond),
+		packet.describe(),
+	)
+
+	builder.WriteString("\n")
+
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(hex.Dump(packet.data), "\n"), "\n") {
+		builder.WriteString("    ")
+		builder.WriteString(line)
+	}
+
+	builder.WriteString("\n")
+
+	_, err := io.WriteString(i.writer, builder.String())
+	return err
+}
+
+// runInspect runs the inspect subcommand with the given arguments
+//
+func runInspect(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+
+	device := flags.String("device", "", "connect to given device")
+	format := flags.String("format", "text", "output format: text or json (JSON Lines)")
+	commands := flags.String("commands", "", "comma-separated command types to print: "+strings.Join(inspectCommandTypes, ", ")+" (default: all)")
+	region := flags.String("region", "", "only print draw commands in the given region of the screen: x,y,width,height")
+	window := flags.Bool("window", false, "also render in a window")
+	width := flags.Int("width", 640, "width of the window")
+	height := flags.Int("height", 480, "height of the window")
+	software := flags.Bool("software", true, "use software rendering")
+
+	_ = flags.Parse(args)
+
+	if *device == "" {
+		flags.Usage()
+		return
+	}
+
+	if *format != "text" && *format != "json" {
+		log.Fatalf("invalid format: %s", *format)
+	}
+
+	kinds, err := parseInspectCommandTypes(*commands)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	regionRect, err := parseInspectRegion(*region)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	inspector := &inspector{
+		writer: os.Stdout,
+		json:   *format == "json",
+		filter: inspectFilter{
+			kinds:  kinds,
+			region: regionRect,
+		},
+	}
+
+	var renderer renderer
+	var input inputHandler
+
+	if *window {
+		sdlRenderer := newSDLRenderer(
+			int32(*width),
+			int32(*height),
+			*software,
+			scalingInteger,
+			scaleFilterNearest,
+			nil,
+			effects{},
+			waveformPoints,
+			1,
+		)
+		defer sdlRenderer.quit()
+
+		renderer = sdlRenderer
+		redraw := func() {
+			sdlRenderer.invalidate()
+			sdlRenderer.render()
+		}
+
+		input = newInput(nil, redraw, nil, nil)
+	}
+
+	log.Printf("Opening serial port ...")
+
+	port := openSerialPort(*device)
+	defer port.Close()
+	defer disconnect(port)
+
+	// Without a window, there is no input to quit,
+	// so disconnect when interrupted. The main loop handles the interrupt,
+	// as it is the only writer to the port
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+
+	enableAndResetDisplay(port)
+
+	read := newReader(port)
+
+	for {
+		select {
+		case <-interrupts:
+			log.Println("Interrupted")
+			return
+		default:
+		}
+
+		if input != nil && !input.handle(renderer.toggleFullscreen, func(controller byte) {
+			sendController(port, controller)
+		}) {
+			log.Println("Quit")
+			return
+		}
+
+		var render bool
+
+		_, err := read(func(packet []byte) {
+			err := inspector.inspect(packet, time.Now())
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if renderer == nil {
+				return
+			}
+
+			command, err := decodeCommand(packet)
+			if err != nil {
+				return
+			}
+
+			renderer.draw(command)
+			render = true
+		})
+		if err != nil {
+			log.Printf("failed to decode SLIP: %s", err)
+		}
+
+		if render {
+			renderer.render()
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspector(t *testing.T) {
+
+	start := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Character 'A' at (8, 16), white on black
+	character := []byte{0xFD, 'A', 8, 0, 16, 0, 0xFF, 0xFF, 0xFF, 0, 0, 0}
+	// Rectangle at (100, 200), 10x20, red
+	rectangle := []byte{0xFE, 100, 0, 200, 0, 10, 0, 20, 0, 0xFF, 0, 0}
+	unknown := []byte{0xAB, 1, 2}
+	invalid := []byte{0xFD, 1}
+
+	t.Run("text", func(t *testing.T) {
+		var output bytes.Buffer
+		inspector := &inspector{writer: &output}
+
+		require.NoError(t, inspector.inspect(character, start))
+		require.NoError(t, inspector.inspect(unknown, start.Add(1500*time.Microsecond)))
+		require.NoError(t, inspector.inspect(invalid, start.Add(2*time.Millisecond)))
+
+		lines := strings.Split(output.String(), "\n")
+		require.Equal(t,
+			`03:04:05.000000 +0.000ms character char="A" x=8 y=16 foreground=#ffffff background=#000000`,
+			lines[0],
+		)
+		require.Equal(t,
+			"    00000000  fd 41 08 00 10 00 ff ff  ff 00 00 00              |.A..........|",
+			lines[1],
+		)
+		require.Equal(t,
+			`03:04:05.001500 +1.500ms unknown !!! UNKNOWN COMMAND BYTE !!! command=0xab`,
+			lines[2],
+		)
+		require.True(t, strings.HasPrefix(lines[4], `03:04:05.002000 +2.000ms invalid !!! INVALID PACKET !!! error="invalid draw character packet`))
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var output bytes.Buffer
+		inspector := &inspector{
+			writer: &output,
+			json:   true,
+		}
+
+		require.NoError(t, inspector.inspect(rectangle, start))
+		require.NoError(t, inspector.inspect(unknown, start.Add(time.Millisecond)))
+
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		require.Len(t, lines, 2)
+
+		var packet map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &packet))
+		require.Equal(t,
+			map[string]interface{}{
+				"time":       "2021-01-02T03:04:05Z",
+				"elapsed_ms": 0.0,
+				"type":       "rectangle",
+				"fields": map[string]interface{}{
+					"x":      100.0,
+					"y":      200.0,
+					"width":  10.0,
+					"height": 20.0,
+					"color":  "#ff0000",
+				},
+				"hex": "00000000  fe 64 00 c8 00 0a 00 14  00 ff 00 00              |.d..........|\n",
+			},
+			packet,
+		)
+
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &packet))
+		require.Equal(t, "unknown", packet["type"])
+		require.Equal(t, 1.0, packet["elapsed_ms"])
+		require.Equal(t, map[string]interface{}{"command": "0xab"}, packet["fields"])
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		kinds, err := parseInspectCommandTypes("character, Unknown")
+		require.NoError(t, err)
+
+		region, err := parseInspectRegion("0,0,320,20")
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 320, 20), *region)
+
+		var output bytes.Buffer
+		inspector := &inspector{
+			writer: &output,
+			json:   true,
+			filter: inspectFilter{
+				kinds:  kinds,
+				region: region,
+			},
+		}
+
+		// Character inside the region
+		require.NoError(t, inspector.inspect(character, start))
+		// Character outside the region
+		require.NoError(t, inspector.inspect(
+			[]byte{0xFD, 'B', 8, 0, 100, 0, 0xFF, 0xFF, 0xFF, 0, 0, 0},
+			start,
+		))
+		// Rectangle is not selected
+		require.NoError(t, inspector.inspect(rectangle, start))
+		// Unknown commands have no region
+		require.NoError(t, inspector.inspect(unknown, start))
+
+		var types []string
+		for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+			var packet map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(line), &packet))
+			types = append(types, packet["type"].(string))
+		}
+		require.Equal(t, []string{"character", "unknown"}, types)
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		_, err := parseInspectCommandTypes("character,foo")
+		require.Error(t, err)
+
+		_, err = parseInspectRegion("0,0,320")
+		require.Error(t, err)
+
+		_, err = parseInspectRegion("0,0,a,20")
+		require.Error(t, err)
+	})
+}
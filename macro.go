@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Macros are recorded sequences of controller states, bound to keys of the keyboard.
+//
+// Pressing the record key arms the recorder, and the next pressed key selects the key
+// the macro gets bound to. All controller changes of the input get recorded,
+// until the record key is pressed again. The macro is then saved to the macros directory,
+// in a JSON file named after the key, e.g. F5.json.
+// Key names are escaped, as they might contain path separators, e.g. the / key is saved to %2F.json.
+//
+// Pressing a key bound to a macro replays it, at the original speed scaled by the speed factor.
+
+// macroEvent is a controller state change,
+// after a delay since the previous event
+//
+type macroEvent struct {
+	DelayMs    float64 `json:"delay_ms"`
+	Controller uint8   `json:"controller"`
+}
+
+func (e macroEvent) delay(speed float64) time.Duration {
+	return time.Duration(e.DelayMs / speed * float64(time.Millisecond))
+}
+
+// macro
+//
+type macro struct {
+	Events []macroEvent `json:"events"`
+}
+
+type macroPlayback struct {
+	macro *macro
+	index int
+	next  time.Time
+}
+
+type macros struct {
+	dir            string
+	recordKey      string
+	speed          float64
+	macros         map[string]*macro
+	sendController func(uint8)
+
+	// armed is true if the next pressed key selects the key to record a macro for
+	armed     bool
+	recording string
+	recorded  []macroEvent
+	lastEvent time.Time
+
+	playback *macroPlayback
+}
+
+func newMacros(dir string, recordKey string, speed float64, sendController func(uint8)) *macros {
+	return &macros{
+		dir:            dir,
+		recordKey:      strings.ToUpper(recordKey),
+		speed:          speed,
+		macros:         map[string]*macro{},
+		sendController: sendController,
+	}
+}
+
+// defaultMacrosDirectory returns the macros directory in the user's configuration directory
+//
+func defaultMacrosDirectory() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "g0m8", "macros")
+}
+
+func macroKeyName(name string) string {
+	return strings.ToUpper(name)
+}
+
+// macroFileName returns the name of the file for the macro bound to the given key
+//
+func macroFileName(name string) string {
+	return url.PathEscape(name) + ".json"
+}
+
+// load loads all macros from the macros directory
+//
+func (m *macros) load() error {
+	files, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+
+		path := filepath.Join(m.dir, file.Name())
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var macro macro
+		err = json.Unmarshal(data, &macro)
+		if err != nil {
+			return fmt.Errorf("invalid macro %s: %w", path, err)
+		}
+
+		name, err := url.PathUnescape(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			return fmt.Errorf("invalid macro file name %s: %w", path, err)
+		}
+
+		name = macroKeyName(name)
+		m.macros[name] = &macro
+
+		log.Printf("Loaded macro %s", name)
+	}
+
+	return nil
+}
+
+// save saves the macro bound to the given key to the macros directory
+//
+func (m *macros) save(name string) error {
+	data, err := json.MarshalIndent(m.macros[name], "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(m.dir, macroFileName(name)), data, 0644)
+}
+
+func (m *macros) handleKey(name string, pressed bool, repeat bool) bool {
+	name = macroKeyName(name)
+
+	switch {
+	case name == m.recordKey:
+		if pressed && !repeat {
+			if m.recording != "" {
+				m.stopRecording()
+			} else {
+				m.armed = !m.armed
+				if m.armed {
+					log.Println("Press the key to record a macro for ...")
+				}
+			}
+		}
+		return true
+
+	case m.armed:
+		if pressed && !repeat {
+			m.startRecording(name)
+		}
+		return true
+
+	case name == m.recording:
+		return true
+
+	case m.macros[name] != nil:
+		if pressed && !repeat && m.recording == "" {
+			m.play(name, time.Now())
+		}
+		return true
+	}
+
+	return false
+}
+
+func (m *macros) startRecording(name string) {
+	log.Printf("Recording macro %s ...", name)
+
+	m.armed = false
+	m.recording = name
+	m.recorded = nil
+}
+
+func (m *macros) stopRecording() {
+	name := m.recording
+	m.recording = ""
+
+	if len(m.recorded) == 0 {
+		log.Printf("Recorded nothing for macro %s", name)
+		return
+	}
+
+	m.macros[name] = &macro{
+		Events: m.recorded,
+	}
+	m.recorded = nil
+
+	err := m.save(name)
+	if err != nil {
+		log.Printf("failed to save macro %s: %s", name, err)
+		return
+	}
+
+	log.Printf("Recorded macro %s", name)
+}
+
+// record records the given controller state change, if recording
+//
+func (m *macros) record(controller uint8, now time.Time) {
+	if m.recording == "" {
+		return
+	}
+
+	var delay time.Duration
+	if len(m.recorded) > 0 {
+		delay = now.Sub(m.lastEvent)
+	}
+	m.lastEvent = now
+
+	m.recorded = append(m.recorded, macroEvent{
+		DelayMs:    float64(delay) / float64(time.Millisecond),
+		Controller: controller,
+	})
+}
+
+// play starts replaying the macro bound to the given key.
+// A macro which is currently replaying is stopped
+//
+func (m *macros) play(name string, now time.Time) {
+	macro := m.macros[name]
+	if macro == nil || len(macro.Events) == 0 {
+		return
+	}
+
+	m.playback = &macroPlayback{
+		macro: macro,
+		next:  now.Add(macro.Events[0].delay(m.speed)),
+	}
+}
+
+// reset stops replaying, e.g. after reconnecting
+//
+func (m *macros) reset() {
+	m.playback = nil
+}
+
+// run sends all controller states of the replaying macro which are due.
+// All keys are released at the end
+//
+func (m *macros) run(now time.Time) {
+	playback := m.playback
+	if playback == nil {
+		return
+	}
+
+	events := playback.macro.Events
+
+	for !now.Before(playback.next) {
+		m.sendController(events[playback.index].Controller)
+
+		playback.index++
+		if playback.index == len(events) {
+			m.sendController(0)
+			m.playback = nil
+			return
+		}
+
+		playback.next = playback.next.Add(events[playback.index].delay(m.speed))
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMacros(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "g0m8-macros")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+
+	// Record
+
+	recorder := newMacros(dir, "F12", 1, func(uint8) {})
+
+	require.True(t, recorder.handleKey("F12", true, false))
+	require.True(t, recorder.handleKey("F12", false, false))
+	require.True(t, recorder.handleKey("F5", true, false))
+	require.True(t, recorder.handleKey("F5", false, false))
+	require.False(t, recorder.handleKey("X", true, false))
+
+	recorder.record(keySelect, start)
+	recorder.record(keySelect|keyUp, start.Add(100*time.Millisecond))
+	recorder.record(0, start.Add(300*time.Millisecond))
+
+	require.True(t, recorder.handleKey("F12", true, false))
+
+	require.FileExists(t, filepath.Join(dir, "F5.json"))
+
+	// Load and replay at double speed
+
+	var controllers []uint8
+	var times []time.Duration
+
+	player := newMacros(dir, "F12", 2, nil)
+	player.sendController = func(controller uint8) {
+		controllers = append(controllers, controller)
+	}
+	require.NoError(t, player.load())
+	require.Equal(t,
+		&macro{
+			Events: []macroEvent{
+				{DelayMs: 0, Controller: keySelect},
+				{DelayMs: 100, Controller: keySelect | keyUp},
+				{DelayMs: 200, Controller: 0},
+			},
+		},
+		player.macros["F5"],
+	)
+
+	player.play("F5", start)
+
+	for elapsed := time.Duration(0); elapsed <= 200*time.Millisecond; elapsed += 10 * time.Millisecond {
+		count := len(controllers)
+		player.run(start.Add(elapsed))
+		for i := count; i < len(controllers); i++ {
+			times = append(times, elapsed)
+		}
+	}
+
+	require.Equal(t, []uint8{keySelect, keySelect | keyUp, 0, 0}, controllers)
+	require.Equal(t,
+		[]time.Duration{
+			0,
+			50 * time.Millisecond,
+			150 * time.Millisecond,
+			150 * time.Millisecond,
+		},
+		times,
+	)
+	require.Nil(t, player.playback)
+}
+
+func TestMacroKeyNames(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "g0m8-macros")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+
+	// Key names which are not valid file names are escaped
+
+	recorder := newMacros(dir, "F12", 1, func(uint8) {})
+
+	for _, name := range []string{"/", "\\", ".", "Keypad /"} {
+		require.True(t, recorder.handleKey("F12", true, false))
+		require.True(t, recorder.handleKey("F12", false, false))
+		require.True(t, recorder.handleKey(name, true, false))
+		recorder.record(keyUp, start)
+		require.True(t, recorder.handleKey("F12", true, false))
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	require.ElementsMatch(t,
+		[]string{"%2F.json", "%5C.json", "..json", "KEYPAD%20%2F.json"},
+		names,
+	)
+
+	player := newMacros(dir, "F12", 1, nil)
+	require.NoError(t, player.load())
+	require.Len(t, player.macros, 4)
+	for _, name := range []string{"/", "\\", ".", "KEYPAD /"} {
+		require.Contains(t, player.macros, name)
+	}
+}
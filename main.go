@@ -3,25 +3,112 @@ package main
 import (
 	"encoding/hex"
 	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/veandco/go-sdl2/sdl"
 )
 
 var deviceFlag = flag.String("device", "", "connect to given device")
+var reconnectIntervalFlag = flag.Duration("reconnect-interval", time.Second, "interval of trying to reconnect when the connection to the M8 is lost")
 var debugFlag = flag.Bool("debug", true, "enable debug logging")
+var logFileFlag = flag.String("log-file", "", "file to append the debug log to (default: stderr, or discarded when rendering in the terminal)")
 var softwareFlag = flag.Bool("software", true, "use software rendering")
 var widthFlag = flag.Int("width", 640, "width of the window")
 var heightFlag = flag.Int("height", 480, "height of the window")
 var fpsFlag = flag.Int("fps", 30, "target FPS")
+var scalingFlag = flag.String("scaling", "integer", "scaling of the screen to the window: integer, fit, or stretch")
+var filterFlag = flag.String("filter", "nearest", "filtering when scaling the screen: nearest or linear")
+var effectsFlag = flag.String("effects", "", "comma-separated post-processing effects: scanlines, curvature, glow, lcd")
+var letterboxColorFlag = flag.String("letterbox-color", "", "color around the scaled screen, as #rrggbb (default: the background color)")
+var waveformFlag = flag.String("waveform", "points", "style of the oscilloscope waveform: points, lines, filled, or thick")
+var waveformTrailFlag = flag.Int("waveform-trail", 1, "number of frames of the oscilloscope waveform persistence trail, 1 to disable")
+var scopeFlag = flag.Bool("scope", false, "show the oscilloscope waveform enlarged in a separate window")
+var scopeKeyFlag = flag.String("scope-key", "F9", "key which shows and hides the scope window")
+var scopeWidthFlag = flag.Int("scope-width", 960, "width of the scope window")
+var scopeHeightFlag = flag.Int("scope-height", 360, "height of the scope window")
+var terminalFlag = flag.Bool("terminal", false, "render in the terminal instead of a window")
+var dumpTextFlag = flag.Bool("dump-text", false, "print the text on the screen to stdout when it changes")
+var accessibilityFlag = flag.String("accessibility", "", "announce the view and the focused field: stdout or speechd")
+var accessibilityDebounceFlag = flag.Duration("accessibility-debounce", 300*time.Millisecond, "time the screen must be unchanged before announcing it")
+var speechdSocketFlag = flag.String("speechd-socket", "", "path of the speech-dispatcher socket (default: speech-dispatcher's default)")
+var apiFlag = flag.String("api", "", "serve the automation API on the given TCP address, or Unix socket path prefixed with unix:")
+var scriptsFlag = flag.String("scripts", defaultScriptsDirectory(), "directory of the Lua scripts to load")
+var macrosFlag = flag.String("macros", defaultMacrosDirectory(), "directory of the recorded macros")
+var macroRecordKeyFlag = flag.String("macro-record-key", "F12", "key which starts and stops recording a macro")
+var macroSpeedFlag = flag.Float64("macro-speed", 1, "speed factor for replaying macros")
+var shortcutFlag shortcutFlags
+var shortcutChordWindowFlag = flag.Duration("shortcut-chord-window", 200*time.Millisecond, "time keys which might start a shortcut chord are held back, before they are sent to the M8")
+var shortcutHoldFlag = flag.Duration("shortcut-hold", 500*time.Millisecond, "time a shortcut chord must be held for hold and repeat gestures")
+var shortcutDoubleTapFlag = flag.Duration("shortcut-double-tap", 300*time.Millisecond, "maximum time between the taps of a double tap gesture")
+var shortcutRepeatFlag = flag.Duration("shortcut-repeat", 100*time.Millisecond, "interval of the repeat gesture")
+var screenshotsFlag = flag.String("screenshots", ".", "directory to save screenshots to")
+var recordFlag = flag.String("record", "", "record the session to the given capture file, and with -audio, the audio to a WAV file of the same name")
+var flightRecorderFlag = flag.Int("flight-recorder", 10000, "number of recent packets and writes kept in memory, and dumped to a capture file on errors, 0 to disable")
+var flightRecorderKeyFlag = flag.String("flight-recorder-key", "F8", "key which dumps the flight recorder to a capture file")
+var capturesFlag = flag.String("captures", ".", "directory to save flight recorder captures to")
+var statsFlag = flag.Bool("stats", false, "show the statistics overlay")
+var statsKeyFlag = flag.String("stats-key", "F10", "key which toggles the statistics overlay")
+var statsLogFlag = flag.Duration("stats-log", 0, "interval of logging a statistics summary, 0 to disable")
+var metricsFlag = flag.String("metrics", "", "serve Prometheus metrics at /metrics on the given TCP address, or Unix socket path prefixed with unix:")
+var midiFlag = flag.Bool("midi", false, "enable MIDI input through an ALSA sequencer port")
+var midiConnectFlag = flag.String("midi-connect", "", "comma-separated ALSA sequencer ports to receive MIDI from, e.g. 20:0 or MPD218")
+var midiMappingFlag = flag.String("midi-mapping", "", "JSON file mapping MIDI notes and CCs to keys and keyjazz (default: all notes to keyjazz)")
+var audioFlag = flag.Bool("audio", false, "play the audio of the M8 on the default output device, and with -record, record it")
+var audioDeviceFlag = flag.String("audio-device", "M8", "name or part of the name of the audio capture device, or default for the default device")
+var audioBufferFlag = flag.Int("audio-buffer", 256, "size of the audio buffers, in samples. Smaller buffers have lower latency")
+var audioVolumeFlag = flag.Float64("audio-volume", 1, "volume of the audio, from 0 to 4")
+var listAudioDevicesFlag = flag.Bool("list-audio-devices", false, "list the audio devices and exit")
+
+func init() {
+	flag.Var(&shortcutFlag, "shortcut", "bind a shortcut, e.g. SHIFT+OPT+S=screenshot (repeatable)")
+}
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		runInspect(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "capture" {
+		runCapture(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 
-	if !*debugFlag {
+	switch {
+	case !*debugFlag:
 		log.SetFlags(0)
 		log.SetOutput(ioutil.Discard)
+
+	case *logFileFlag != "":
+		file, err := os.OpenFile(*logFileFlag, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+
+		log.SetOutput(file)
+	}
+
+	if *listAudioDevicesFlag {
+		err := listAudioDevices(os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	device := *deviceFlag
@@ -30,62 +117,746 @@ func main() {
 		return
 	}
 
-	var input input
+	var input inputHandler
+	var renderer renderer
+	var scope *scopeWindow
+
+	screen := newTextScreen()
+
+	waveformStyle, err := parseWaveformStyle(*waveformFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Key handlers get the chance to handle keys before they are mapped to M8 keys
+
+	var keyHandlers []keyHandler
+
+	var shortcutLayer *shortcuts
+
+	handleKey := func(name string, pressed bool, repeat bool) bool {
+		for _, keyHandler := range keyHandlers {
+			if keyHandler(name, pressed, repeat) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if *terminalFlag {
+		if *scopeFlag {
+			log.Fatal("the scope window is not supported in the terminal")
+		}
+
+		if *dumpTextFlag {
+			log.Fatal("-dump-text is not supported in the terminal, as it would be written over the screen")
+		}
+
+		tty := makeRaw(os.Stdin)
+		defer tty.restore()
+
+		// The log would be written over the screen
+		if *logFileFlag == "" {
+			log.SetOutput(ioutil.Discard)
+		}
+
+		terminalRenderer := newTerminalRenderer(os.Stdout, screen)
+		renderer = terminalRenderer
+
+		// Exiting does not run the deferred functions, so restore the terminal,
+		// and show the error, as the log is not written to the terminal
+		onFatal(func(message string) {
+			terminalRenderer.quit()
+			tty.restore()
+			fmt.Fprintln(os.Stderr, message)
+		})
+
+		input = newTerminalInput(os.Stdin, handleKey)
+	} else {
+		windowWidth := int32(*widthFlag)
+		windowHeight := int32(*heightFlag)
+
+		scaling, err := parseScalingMode(*scalingFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		filter, err := parseScaleFilter(*filterFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		effects, err := parseEffects(*effectsFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var letterboxColor *Color
+		if *letterboxColorFlag != "" {
+			color, err := parseColor(*letterboxColorFlag)
+			if err != nil {
+				log.Fatal(err)
+			}
+			letterboxColor = &color
+		}
 
-	windowWidth := int32(*widthFlag)
-	windowHeight := int32(*heightFlag)
+		sdlRenderer := newSDLRenderer(
+			windowWidth,
+			windowHeight,
+			*softwareFlag,
+			scaling,
+			filter,
+			letterboxColor,
+			effects,
+			waveformStyle,
+			*waveformTrailFlag,
+		)
+		renderer = sdlRenderer
 
-	renderer := newSDLRenderer(windowWidth, windowHeight, *softwareFlag)
+		if *scopeFlag {
+			scope = newScopeWindow(
+				int32(*scopeWidthFlag),
+				int32(*scopeHeightFlag),
+				*softwareFlag,
+				waveformStyle,
+				*waveformTrailFlag,
+			)
+			defer scope.quit()
+		}
+
+		redraw := func() {
+			sdlRenderer.invalidate()
+			sdlRenderer.render()
+			if scope != nil {
+				scope.invalidate()
+				scope.render()
+			}
+		}
+
+		closeWindow := func(windowID uint32) bool {
+			if scope != nil && windowID == scope.id {
+				scope.close()
+				return true
+			}
+			return false
+		}
+
+		focusLost := func() {
+			// Keys held back as the start of a chord were not pressed by the user
+			if shortcutLayer != nil {
+				shortcutLayer.dropPending()
+			}
+		}
+
+		input = newInput(handleKey, redraw, closeWindow, focusLost)
+	}
 	defer renderer.quit()
 
+	var access *accessibility
+
+	switch *accessibilityFlag {
+	case "":
+		break
+
+	case "stdout":
+		access = newAccessibility(lineAnnouncer{os.Stdout}, *accessibilityDebounceFlag)
+
+	case "speechd":
+		path := *speechdSocketFlag
+		if path == "" {
+			path = speechDispatcherSocketPath()
+		}
+
+		speechDispatcher, err := newSpeechDispatcher(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer speechDispatcher.close()
+
+		access = newAccessibility(speechDispatcher, *accessibilityDebounceFlag)
+
+	default:
+		log.Fatalf("invalid accessibility output: %s", *accessibilityFlag)
+	}
+
+	var exported *metrics
+
+	if *metricsFlag != "" {
+		listener, err := listen(*metricsFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer listener.Close()
+
+		exported = newMetrics()
+
+		go exported.serve(listener)
+	}
+
+	var flight *flightRecorder
+	if *flightRecorderFlag > 0 {
+		flight = newFlightRecorder(*flightRecorderFlag, *capturesFlag)
+	}
+
+	var session *captureWriter
+	if *recordFlag != "" {
+		file, err := os.Create(*recordFlag)
+		if err != nil {
+			fatal(err)
+		}
+		defer file.Close()
+
+		session, err = newCaptureWriter(file, time.Now())
+		if err != nil {
+			fatal(err)
+		}
+		defer session.flush()
+		onFatal(func(string) {
+			session.flush()
+		})
+	}
+
+	if *audioFlag {
+		audio, err := newAudioPassthrough(*audioDeviceFlag, *audioBufferFlag, *audioVolumeFlag)
+		if err != nil {
+			fatal(err)
+		}
+
+		// Record the audio alongside the session, see g0m8 capture render-video -audio
+
+		if *recordFlag != "" {
+			path := strings.TrimSuffix(*recordFlag, filepath.Ext(*recordFlag)) + ".wav"
+
+			file, err := os.Create(path)
+			if err != nil {
+				fatal(err)
+			}
+			defer file.Close()
+
+			recorder, err := newWAVWriter(file, audioFrequency, audioChannels)
+			if err != nil {
+				fatal(err)
+			}
+			audio.recorder = recorder
+
+			closeRecorder := func() {
+				err := recorder.close()
+				if err != nil {
+					log.Printf("failed to record audio: %s", err)
+				}
+			}
+			defer closeRecorder()
+			onFatal(func(string) {
+				closeRecorder()
+			})
+		}
+
+		audio.start()
+		defer audio.close()
+		onFatal(func(string) {
+			audio.close()
+		})
+	}
+
+	capture := func(kind captureRecordKind, data []byte) {
+		now := time.Now()
+
+		if flight != nil {
+			flight.record(kind, now, data)
+		}
+
+		if session != nil {
+			err := session.writeAt(kind, now, data)
+			if err != nil {
+				log.Printf("failed to record session: %s", err)
+			}
+		}
+	}
+
+	dumpFlightRecorder := func(onError bool) {
+		if flight == nil {
+			return
+		}
+
+		var path string
+		var err error
+		if onError {
+			path, err = flight.dumpOnError(time.Now())
+		} else {
+			path, err = flight.dump(time.Now())
+		}
+
+		if err != nil {
+			log.Printf("failed to dump the flight recorder: %s", err)
+		} else if path != "" {
+			log.Printf("Dumped the flight recorder to %s", path)
+		}
+	}
+
+	// Dump the flight recorder when crashing, or when failing fatally, e.g. when the connection fails
+
+	defer func() {
+		if r := recover(); r != nil {
+			dumpFlightRecorder(false)
+			panic(r)
+		}
+	}()
+
+	onFatal(func(message string) {
+		capture(captureError, []byte(message))
+		dumpFlightRecorder(false)
+	})
+
 	log.Printf("Opening serial port ...")
 
-	port := openSerialPort(device)
-	defer port.Close()
+	serial := newSerialConnection(
+		device,
+		openSerialPort(device),
+		func(device string) (io.ReadWriteCloser, error) {
+			f, err := tryOpenSerialPort(device)
+			if err != nil {
+				return nil, err
+			}
+			return f, nil
+		},
+		*reconnectIntervalFlag,
+	)
+	defer serial.Close()
+
+	if exported != nil {
+		serial.onWritten = exported.written
+		exported.setConnected(true)
+		defer exported.setConnected(false)
+	}
+
+	// Commands are written from a goroutine, so sending never blocks the main loop
+
+	queue := newCommandQueue(serial, serial.waitWritable)
+	go queue.run()
+	defer queue.close()
+
+	var port io.Writer = queue
+	if flight != nil || session != nil {
+		port = recordingWriter{
+			writer: queue,
+			record: func(data []byte) {
+				capture(captureWritten, data)
+			},
+		}
+	}
+
+	serial.onDisconnect = func() {
+		if exported != nil {
+			exported.setConnected(false)
+		}
+	}
+
+	// The controller state is the combination of the keys
+	// pressed through the input, the automation API, and scripts
+
+	controllerMixer := newControllerMixer(func(controller byte) {
+		sendController(port, controller)
+	})
+
+	sendInputController := controllerMixer.source()
+	controllerMixer.addReset(input.reset)
+
+	serial.onReconnect = func() {
+		if exported != nil {
+			exported.reconnected()
+			exported.setConnected(true)
+		}
+
+		enableAndResetDisplay(port)
+
+		// The M8 might have latched keys which were pressed when the connection got lost,
+		// and the sources still have them pressed, so release them everywhere
+		controllerMixer.reset()
+	}
+
 	defer disconnect(port)
 
 	enableAndResetDisplay(port)
 
-	read := newReader(port)
+	read := newReader(serial)
 
-	sendController := func(controller byte) {
-		sendController(port, controller)
+	var recorder *macros
+
+	sendRecordedController := func(controller byte) {
+		if recorder != nil {
+			recorder.record(controller, time.Now())
+		}
+		sendInputController(controller)
+	}
+
+	sendController := sendRecordedController
+
+	sendNoteOn := func(note byte, velocity byte) {
+		sendNoteOn(port, note, velocity)
+	}
+
+	sendNoteOff := func() {
+		sendNoteOff(port)
+	}
+
+	var automation *api
+	var mirror *framebuffer
+
+	if *apiFlag != "" || len(shortcutFlag) > 0 {
+		mirror = newFramebuffer()
+		mirror.waveformStyle = waveformStyle
+	}
+
+	if *apiFlag != "" {
+		listener, err := listen(*apiFlag)
+		if err != nil {
+			fatal(err)
+		}
+		defer listener.Close()
+
+		automation = newAPI(
+			controllerMixer.source(),
+			sendNoteOn,
+			sendNoteOff,
+			screen,
+			mirror,
+		)
+
+		controllerMixer.addReset(automation.reset)
+
+		go automation.serve(listener)
+	}
+
+	if *macrosFlag != "" {
+		if *macroSpeedFlag <= 0 {
+			fatalf("invalid macro speed: %f", *macroSpeedFlag)
+		}
+
+		recorder = newMacros(
+			*macrosFlag,
+			*macroRecordKeyFlag,
+			*macroSpeedFlag,
+			controllerMixer.source(),
+		)
+
+		err := recorder.load()
+		if err != nil && !os.IsNotExist(err) {
+			fatal(err)
+		}
+
+		keyHandlers = append(keyHandlers, recorder.handleKey)
+		controllerMixer.addReset(recorder.reset)
+	}
+
+	var scripting *scripts
+
+	if *scriptsFlag != "" {
+		scripting = newScripts(
+			controllerMixer.source(),
+			sendNoteOn,
+			sendNoteOff,
+			func(index byte, color Color) {
+				sendThemeColor(port, index, color)
+			},
+			screen,
+		)
+		defer scripting.close()
+
+		err := scripting.loadDirectory(*scriptsFlag)
+		if err != nil && !os.IsNotExist(err) {
+			fatal(err)
+		}
+
+		keyHandlers = append(keyHandlers, scripting.handleKey)
+		controllerMixer.addReset(scripting.reset)
+	}
+
+	var sequencer *alsaSequencer
+	var midi *midiInput
+
+	if *midiFlag {
+		mapping := defaultMIDIMapping
+		if *midiMappingFlag != "" {
+			var err error
+			mapping, err = loadMIDIMapping(*midiMappingFlag)
+			if err != nil {
+				fatal(err)
+			}
+		}
+
+		var err error
+		midi, err = newMIDIInput(
+			mapping,
+			controllerMixer.source(),
+			sendNoteOn,
+			sendNoteOff,
+		)
+		if err != nil {
+			fatal(err)
+		}
+
+		controllerMixer.addReset(midi.reset)
+
+		sequencer, err = openALSASequencer("g0m8")
+		if err != nil {
+			fatal(err)
+		}
+		defer sequencer.close()
+
+		log.Printf("Opened MIDI input %s", sequencer.address())
+
+		if *midiConnectFlag != "" {
+			for _, address := range strings.Split(*midiConnectFlag, ",") {
+				err = sequencer.connectFrom(strings.TrimSpace(address))
+				if err != nil {
+					fatal(err)
+				}
+			}
+		}
+
+		sequencer.listen()
+	}
+
+	toggleFullscreen := func() {
+		renderer.toggleFullscreen()
+		enableAndResetDisplay(port)
+	}
+
+	if len(shortcutFlag) > 0 {
+		shortcutLayer = newShortcuts(
+			shortcutFlag,
+			*shortcutChordWindowFlag,
+			*shortcutHoldFlag,
+			*shortcutDoubleTapFlag,
+			*shortcutRepeatFlag,
+			sendRecordedController,
+			func(action string) {
+				log.Printf("Shortcut: %s", action)
+
+				switch {
+				case action == "screenshot":
+					path, err := mirror.saveScreenshot(*screenshotsFlag, time.Now())
+					if err != nil {
+						log.Printf("failed to save screenshot: %s", err)
+						return
+					}
+					log.Printf("Saved screenshot %s", path)
+
+				case action == "fullscreen":
+					toggleFullscreen()
+
+				case action == "reset-display":
+					enableAndResetDisplay(port)
+
+				case strings.HasPrefix(action, "macro:") && recorder != nil:
+					recorder.play(macroKeyName(strings.TrimPrefix(action, "macro:")), time.Now())
+
+				case strings.HasPrefix(action, "script:") && scripting != nil:
+					name := strings.TrimPrefix(action, "script:")
+					scripting.handleKey(name, true, false)
+					scripting.handleKey(name, false, false)
+
+				default:
+					log.Printf("invalid shortcut action: %s", action)
+				}
+			},
+		)
+
+		sendController = func(controller byte) {
+			shortcutLayer.filter(controller, time.Now())
+		}
+
+		// Shortcuts take precedence over all other key handlers
+		keyHandlers = append([]keyHandler{shortcutLayer.handleKey}, keyHandlers...)
+		controllerMixer.addReset(shortcutLayer.reset)
+	}
+
+	statistics := newStats(time.Now())
+	statistics.totals = exported
+	showStats := *statsFlag
+
+	overlay, _ := renderer.(overlayRenderer)
+
+	setStatsOverlay := func() {
+		if overlay == nil {
+			return
+		}
+
+		if showStats {
+			overlay.setOverlay(statistics.lines())
+		} else {
+			overlay.setOverlay(nil)
+		}
+	}
+
+	if showStats {
+		setStatsOverlay()
 	}
 
+	statsKey := strings.ToUpper(*statsKeyFlag)
+
+	keyHandlers = append(keyHandlers, func(name string, pressed bool, repeat bool) bool {
+		if strings.ToUpper(name) != statsKey {
+			return false
+		}
+
+		if pressed && !repeat {
+			if overlay == nil {
+				log.Println("The statistics overlay is not supported by the renderer")
+				return true
+			}
+
+			showStats = !showStats
+			setStatsOverlay()
+
+			// The overlay changes the size of the screen, so it must be redrawn
+			enableAndResetDisplay(port)
+		}
+
+		return true
+	})
+
+	if flight != nil {
+		flightRecorderKey := strings.ToUpper(*flightRecorderKeyFlag)
+
+		keyHandlers = append(keyHandlers, func(name string, pressed bool, repeat bool) bool {
+			if strings.ToUpper(name) != flightRecorderKey {
+				return false
+			}
+
+			if pressed && !repeat {
+				dumpFlightRecorder(false)
+			}
+
+			return true
+		})
+	}
+
+	if scope != nil {
+		scopeKey := strings.ToUpper(*scopeKeyFlag)
+
+		keyHandlers = append(keyHandlers, func(name string, pressed bool, repeat bool) bool {
+			if strings.ToUpper(name) != scopeKey {
+				return false
+			}
+
+			if pressed && !repeat {
+				scope.toggle()
+			}
+
+			return true
+		})
+	}
+
+	lastStatsLog := time.Now()
+	lastSessionFlush := time.Now()
+
 	fps := *fpsFlag
 
 	var lastRender uint64
 	var skippedRender bool
+	var lastText string
 
 	for {
-		if !input.handle(func() {
-			renderer.toggleFullscreen()
-			enableAndResetDisplay(port)
-		}, sendController) {
+		if !input.handle(toggleFullscreen, sendController) {
 			log.Println("Quit")
 			return
 		}
 
+		if automation != nil {
+			automation.run()
+		}
+
+		if shortcutLayer != nil {
+			shortcutLayer.run(time.Now())
+		}
+
+		if sequencer != nil {
+			sequencer.run(midi.handle)
+		}
+
+		if recorder != nil {
+			recorder.run(time.Now())
+		}
+
+		if scripting != nil {
+			scripting.run(time.Now())
+		}
+
 		var render bool
 
-		read(func(packet []byte) {
+		n, err := read(func(packet []byte) {
+			capture(captureReceived, packet)
+
 			command, err := decodeCommand(packet)
+			statistics.packet(command, err, time.Now())
 			if err != nil {
 				log.Printf(
 					"failed to decode packet: %s. packet: %s",
 					err.Error(),
 					hex.Dump(packet),
 				)
+				dumpFlightRecorder(true)
 				if _, ok := err.(unknownCommandError); !ok {
 					return
 				}
 			}
 
+			screen.update(command)
+			if mirror != nil {
+				mirror.draw(command)
+			}
 			renderer.draw(command)
+			if scope != nil {
+				if waveform, ok := command.(DrawOscilloscopeWaveformCommand); ok {
+					scope.draw(waveform)
+				}
+			}
 
 			render = true
 		})
 
+		statistics.read(n)
+
+		if err, ok := err.(slipError); ok {
+			for i := 0; i < err.frames; i++ {
+				statistics.slipError()
+			}
+			log.Printf("failed to decode SLIP: %s", err)
+			capture(captureError, []byte(err.Error()))
+			dumpFlightRecorder(true)
+		}
+
+		if access != nil {
+			var err error
+			if render {
+				err = access.update(screen, time.Now())
+			} else {
+				err = access.flush(time.Now())
+			}
+			if err != nil {
+				log.Printf("failed to announce: %s", err)
+			}
+		}
+
+		if statistics.update(time.Now()) && showStats {
+			setStatsOverlay()
+			render = true
+		}
+
+		if *statsLogFlag > 0 && time.Since(lastStatsLog) >= *statsLogFlag {
+			lastStatsLog = time.Now()
+			log.Printf("Stats: %s", statistics.summary())
+		}
+
+		if session != nil && time.Since(lastSessionFlush) >= captureFlushInterval {
+			lastSessionFlush = time.Now()
+			err := session.flush()
+			if err != nil {
+				log.Printf("failed to record session: %s", err)
+			}
+		}
+
 		if skippedRender || render {
 			skippedRender = false
 
@@ -95,10 +866,26 @@ func main() {
 
 			if diff < (1.0 / float64(fps)) {
 				skippedRender = true
+				// Only count new frames, not the retries of a skipped one
+				if render {
+					statistics.skipped()
+				}
 			} else {
 				renderer.render()
+				if scope != nil {
+					scope.render()
+				}
+				statistics.rendered(time.Now())
 
 				lastRender = now
+
+				if *dumpTextFlag {
+					text := screen.String()
+					if text != lastText {
+						fmt.Printf("%s\n\n", text)
+						lastText = text
+					}
+				}
 			}
 		}
 	}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// metrics are totals since the start, which are exported
+// in the Prometheus text exposition format.
+// They are updated on the main loop, and read by the HTTP server concurrently
+//
+type metrics struct {
+	// The 64-bit counters must be first, as 64-bit atomic operations need 64-bit aligned values,
+	// which on 32-bit platforms (e.g. ARM and 386) is only guaranteed for the first word of an allocated struct
+	reconnects     uint64
+	packets        [len(metricsPacketTypes)]uint64
+	unknownCommand uint64
+	invalidPacket  uint64
+	slipErrors     uint64
+	framesRendered uint64
+	framesDropped  uint64
+	readBytes      uint64
+	writtenBytes   uint64
+	connected      int32
+
+	unknownCommandBytesMutex sync.Mutex
+	unknownCommandBytes      map[byte]uint64
+}
+
+var metricsPacketTypes = [...]string{
+	inspectRectangle,
+	inspectCharacter,
+	inspectWaveform,
+	inspectJoypad,
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		unknownCommandBytes: map[byte]uint64{},
+	}
+}
+
+func (m *metrics) setConnected(connected bool) {
+	var value int32
+	if connected {
+		value = 1
+	}
+	atomic.StoreInt32(&m.connected, value)
+}
+
+func (m *metrics) reconnected() {
+	atomic.AddUint64(&m.reconnects, 1)
+}
+
+func (m *metrics) read(n int) {
+	atomic.AddUint64(&m.readBytes, uint64(n))
+}
+
+func (m *metrics) written(n int) {
+	atomic.AddUint64(&m.writtenBytes, uint64(n))
+}
+
+func (m *metrics) packet(kind string, err error) {
+	if err != nil {
+		if unknown, ok := err.(unknownCommandError); ok {
+			atomic.AddUint64(&m.unknownCommand, 1)
+
+			m.unknownCommandBytesMutex.Lock()
+			m.unknownCommandBytes[unknown.command]++
+			m.unknownCommandBytesMutex.Unlock()
+		} else {
+			atomic.AddUint64(&m.invalidPacket, 1)
+		}
+		return
+	}
+
+	for i, packetType := range metricsPacketTypes {
+		if packetType == kind {
+			atomic.AddUint64(&m.packets[i], 1)
+			return
+		}
+	}
+}
+
+func (m *metrics) slipError() {
+	atomic.AddUint64(&m.slipErrors, 1)
+}
+
+func (m *metrics) rendered() {
+	atomic.AddUint64(&m.framesRendered, 1)
+}
+
+func (m *metrics) dropped() {
+	atomic.AddUint64(&m.framesDropped, 1)
+}
+
+type metricsWriter struct {
+	writer io.Writer
+	err    error
+}
+
+func (w *metricsWriter) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.writer, format, args...)
+}
+
+func (w *metricsWriter) header(name, kind, help string) {
+	w.printf("# HELP %s %s\n", name, help)
+	w.printf("# TYPE %s %s\n", name, kind)
+}
+
+func (w *metricsWriter) single(name, kind, help string, value interface{}) {
+	w.header(name, kind, help)
+	w.printf("%s %v\n", name, value)
+}
+
+// write writes the metrics in the Prometheus text exposition format
+//
+func (m *metrics) write(writer io.Writer) error {
+	w := &metricsWriter{writer: writer}
+
+	w.single(
+		"g0m8_connected",
+		"gauge",
+		"Whether the M8 is connected.",
+		atomic.LoadInt32(&m.connected),
+	)
+
+	w.single(
+		"g0m8_reconnects_total",
+		"counter",
+		"Number of reconnects to the M8.",
+		atomic.LoadUint64(&m.reconnects),
+	)
+
+	w.header(
+		"g0m8_packets_total",
+		"counter",
+		"Number of decoded packets by command type.",
+	)
+	for i, packetType := range metricsPacketTypes {
+		w.printf("g0m8_packets_total{type=%q} %d\n", packetType, atomic.LoadUint64(&m.packets[i]))
+	}
+
+	w.header(
+		"g0m8_decode_errors_total",
+		"counter",
+		"Number of packets which failed to decode by reason.",
+	)
+	w.printf("g0m8_decode_errors_total{reason=\"unknown_command\"} %d\n", atomic.LoadUint64(&m.unknownCommand))
+	w.printf("g0m8_decode_errors_total{reason=\"invalid_packet\"} %d\n", atomic.LoadUint64(&m.invalidPacket))
+
+	w.header(
+		"g0m8_unknown_commands_total",
+		"counter",
+		"Number of packets with an unknown command byte by command byte.",
+	)
+
+	m.unknownCommandBytesMutex.Lock()
+	commandBytes := make([]int, 0, len(m.unknownCommandBytes))
+	for commandByte := range m.unknownCommandBytes {
+		commandBytes = append(commandBytes, int(commandByte))
+	}
+	sort.Ints(commandBytes)
+	for _, commandByte := range commandBytes {
+		w.printf(
+			"g0m8_unknown_commands_total{command=\"0x%02x\"} %d\n",
+			commandByte,
+			m.unknownCommandBytes[byte(commandByte)],
+		)
+	}
+	m.unknownCommandBytesMutex.Unlock()
+
+	w.single(
+		"g0m8_slip_errors_total",
+		"counter",
+		"Number of SLIP protocol errors.",
+		atomic.LoadUint64(&m.slipErrors),
+	)
+
+	w.single(
+		"g0m8_frames_rendered_total",
+		"counter",
+		"Number of rendered frames.",
+		atomic.LoadUint64(&m.framesRendered),
+	)
+
+	w.single(
+		"g0m8_frames_dropped_total",
+		"counter",
+		"Number of frames which were not rendered to keep the target FPS.",
+		atomic.LoadUint64(&m.framesDropped),
+	)
+
+	w.single(
+		"g0m8_serial_read_bytes_total",
+		"counter",
+		"Number of bytes read from the serial port.",
+		atomic.LoadUint64(&m.readBytes),
+	)
+
+	w.single(
+		"g0m8_serial_written_bytes_total",
+		"counter",
+		"Number of bytes written to the serial port.",
+		atomic.LoadUint64(&m.writtenBytes),
+	)
+
+	return w.err
+}
+
+func (m *metrics) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = m.write(w)
+	})
+
+	return mux
+}
+
+func (m *metrics) serve(listener net.Listener) {
+	err := http.Serve(listener, m.handler())
+	if err != nil {
+		log.Printf("metrics server stopped: %s", err)
+	}
+}
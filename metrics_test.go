@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+
+	exported := newMetrics()
+
+	now := time.Now()
+	statistics := newStats(now)
+	statistics.totals = exported
+
+	exported.setConnected(true)
+	exported.reconnected()
+
+	statistics.read(1000)
+	statistics.packet(DrawCharacterCommand{}, nil, now)
+	statistics.packet(DrawCharacterCommand{}, nil, now)
+	statistics.packet(DrawRectangleCommand{}, nil, now)
+	statistics.packet(nil, unknownCommandError{0xAB}, now)
+	statistics.packet(nil, unknownCommandError{0x01}, now)
+	statistics.packet(nil, unknownCommandError{0xAB}, now)
+	statistics.packet(nil, errors.New("invalid"), now)
+	statistics.slipError()
+	statistics.skipped()
+	statistics.rendered(now)
+
+	// Totals are not reset with the statistics interval
+	statistics.update(now.Add(time.Second))
+	statistics.rendered(now.Add(time.Second))
+
+	// Only bytes which reach the port are counted, not the ones dropped while disconnected
+	var transport fakeTransport
+	port := newSerialConnection("test", &transport, nil, time.Hour)
+	port.onWritten = exported.written
+	sendController(port, keyUp)
+	enableAndResetDisplay(port)
+	transport.failed = true
+	sendController(port, keyDown)
+	sendController(port, 0)
+
+	server := httptest.NewServer(exported.handler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", response.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(response.Body)
+	require.NoError(t, err)
+
+	require.Equal(t,
+		`# HELP g0m8_connected Whether the M8 is connected.
+# TYPE g0m8_connected gauge
+g0m8_connected 1
+# HELP g0m8_reconnects_total Number of reconnects to the M8.
+# TYPE g0m8_reconnects_total counter
+g0m8_reconnects_total 1
+# HELP g0m8_packets_total Number of decoded packets by command type.
+# TYPE g0m8_packets_total counter
+g0m8_packets_total{type="rectangle"} 1
+g0m8_packets_total{type="character"} 2
+g0m8_packets_total{type="waveform"} 0
+g0m8_packets_total{type="joypad"} 0
+# HELP g0m8_decode_errors_total Number of packets which failed to decode by reason.
+# TYPE g0m8_decode_errors_total counter
+g0m8_decode_errors_total{reason="unknown_command"} 3
+g0m8_decode_errors_total{reason="invalid_packet"} 1
+# HELP g0m8_unknown_commands_total Number of packets with an unknown command byte by command byte.
+# TYPE g0m8_unknown_commands_total counter
+g0m8_unknown_commands_total{command="0x01"} 1
+g0m8_unknown_commands_total{command="0xab"} 2
+# HELP g0m8_slip_errors_total Number of SLIP protocol errors.
+# TYPE g0m8_slip_errors_total counter
+g0m8_slip_errors_total 1
+# HELP g0m8_frames_rendered_total Number of rendered frames.
+# TYPE g0m8_frames_rendered_total counter
+g0m8_frames_rendered_total 2
+# HELP g0m8_frames_dropped_total Number of frames which were not rendered to keep the target FPS.
+# TYPE g0m8_frames_dropped_total counter
+g0m8_frames_dropped_total 1
+# HELP g0m8_serial_read_bytes_total Number of bytes read from the serial port.
+# TYPE g0m8_serial_read_bytes_total counter
+g0m8_serial_read_bytes_total 1000
+# HELP g0m8_serial_written_bytes_total Number of bytes written to the serial port.
+# TYPE g0m8_serial_written_bytes_total counter
+g0m8_serial_written_bytes_total 4
+`,
+		string(body),
+	)
+
+	response, err = http.Post(server.URL+"/metrics", "text/plain", nil)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, response.StatusCode)
+}
+
+// TestMetricsAlignment checks that the 64-bit counters are 64-bit aligned on 32-bit platforms,
+// i.e. that they are before all smaller fields
+//
+func TestMetricsAlignment(t *testing.T) {
+
+	var m metrics
+
+	for _, offset := range []uintptr{
+		unsafe.Offsetof(m.reconnects),
+		unsafe.Offsetof(m.packets),
+		unsafe.Offsetof(m.unknownCommand),
+		unsafe.Offsetof(m.invalidPacket),
+		unsafe.Offsetof(m.slipErrors),
+		unsafe.Offsetof(m.framesRendered),
+		unsafe.Offsetof(m.framesDropped),
+		unsafe.Offsetof(m.readBytes),
+		unsafe.Offsetof(m.writtenBytes),
+	} {
+		require.True(t, offset < unsafe.Offsetof(m.connected))
+		require.Zero(t, offset%8)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// MIDI input maps note and control change messages of MIDI controllers,
+// e.g. pad controllers, to M8 keys, and notes to keyjazz notes.
+//
+// The mapping is a JSON file, e.g.:
+//
+//   {
+//     "buttons": [
+//       {"note": 36, "keys": ["LEFT"]},
+//       {"note": 37, "keys": ["SHIFT", "UP"]},
+//       {"channel": 2, "cc": 64, "keys": ["PLAY"]}
+//     ],
+//     "keyjazz": {"channel": 1, "transpose": -12}
+//   }
+//
+// Channels are 1 to 16, 0 matches all channels.
+// Control changes press the keys for values of 64 and above.
+// Notes which are not mapped to keys are sent as keyjazz notes,
+// if they are on the keyjazz channel.
+
+type midiEventKind int
+
+const (
+	midiNoteOn midiEventKind = iota
+	midiNoteOff
+	midiControlChange
+)
+
+// midiEvent is a MIDI message.
+// The channel is 1 to 16, the number is the note or the controller,
+// and the value is the velocity or the controller value
+//
+type midiEvent struct {
+	kind    midiEventKind
+	channel int
+	number  int
+	value   int
+}
+
+type midiButtonMapping struct {
+	Channel int      `json:"channel"`
+	Note    *int     `json:"note"`
+	CC      *int     `json:"cc"`
+	Keys    []string `json:"keys"`
+
+	keys uint8
+}
+
+func (m midiButtonMapping) matches(event midiEvent) bool {
+	if m.Channel != 0 && m.Channel != event.channel {
+		return false
+	}
+
+	switch event.kind {
+	case midiNoteOn, midiNoteOff:
+		return m.Note != nil && *m.Note == event.number
+
+	case midiControlChange:
+		return m.CC != nil && *m.CC == event.number
+	}
+
+	return false
+}
+
+type midiKeyjazzMapping struct {
+	Channel   int `json:"channel"`
+	Transpose int `json:"transpose"`
+}
+
+type midiMapping struct {
+	Buttons []midiButtonMapping `json:"buttons"`
+	Keyjazz *midiKeyjazzMapping `json:"keyjazz"`
+}
+
+// defaultMIDIMapping sends all notes as keyjazz notes
+//
+var defaultMIDIMapping = midiMapping{
+	Keyjazz: &midiKeyjazzMapping{},
+}
+
+// loadMIDIMapping loads the MIDI mapping from the given JSON file
+//
+func loadMIDIMapping(path string) (midiMapping, error) {
+	var mapping midiMapping
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return mapping, err
+	}
+
+	err = json.Unmarshal(data, &mapping)
+	if err != nil {
+		return mapping, fmt.Errorf("invalid MIDI mapping %s: %w", path, err)
+	}
+
+	return mapping, nil
+}
+
+// validate checks the mapping, and resolves the key names
+//
+func (m *midiMapping) validate() error {
+	for i := range m.Buttons {
+		button := &m.Buttons[i]
+
+		if button.Channel < 0 || button.Channel > 16 {
+			return fmt.Errorf("invalid MIDI channel: %d", button.Channel)
+		}
+
+		if (button.Note == nil) == (button.CC == nil) {
+			return fmt.Errorf("MIDI button mapping needs either a note or a cc: %v", button.Keys)
+		}
+
+		keys, err := parseKeys(button.Keys)
+		if err != nil {
+			return err
+		}
+		if keys == 0 {
+			return fmt.Errorf("MIDI button mapping has no keys")
+		}
+		button.keys = keys
+	}
+
+	if m.Keyjazz != nil && (m.Keyjazz.Channel < 0 || m.Keyjazz.Channel > 16) {
+		return fmt.Errorf("invalid MIDI keyjazz channel: %d", m.Keyjazz.Channel)
+	}
+
+	return nil
+}
+
+type midiNote struct {
+	note     byte
+	velocity byte
+}
+
+type midiInput struct {
+	mapping midiMapping
+	pressed []bool
+	// notes are the held keyjazz notes, the last one is playing
+	notes          []midiNote
+	sendController func(uint8)
+	sendNoteOn     func(note byte, velocity byte)
+	sendNoteOff    func()
+}
+
+func newMIDIInput(
+	mapping midiMapping,
+	sendController func(uint8),
+	sendNoteOn func(note byte, velocity byte),
+	sendNoteOff func(),
+) (*midiInput, error) {
+	err := mapping.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &midiInput{
+		mapping:        mapping,
+		pressed:        make([]bool, len(mapping.Buttons)),
+		sendController: sendController,
+		sendNoteOn:     sendNoteOn,
+		sendNoteOff:    sendNoteOff,
+	}, nil
+}
+
+// handle maps the given MIDI event to M8 keys or keyjazz notes
+//
+func (m *midiInput) handle(event midiEvent) {
+	// A note on with zero velocity is a note off
+	if event.kind == midiNoteOn && event.value == 0 {
+		event.kind = midiNoteOff
+	}
+
+	var mapped bool
+	var changed bool
+
+	for i, button := range m.mapping.Buttons {
+		if !button.matches(event) {
+			continue
+		}
+		mapped = true
+
+		var pressed bool
+		switch event.kind {
+		case midiNoteOn:
+			pressed = true
+		case midiControlChange:
+			pressed = event.value >= 64
+		}
+
+		if m.pressed[i] != pressed {
+			m.pressed[i] = pressed
+			changed = true
+		}
+	}
+
+	if changed {
+		var keys uint8
+		for i, button := range m.mapping.Buttons {
+			if m.pressed[i] {
+				keys |= button.keys
+			}
+		}
+		m.sendController(keys)
+	}
+
+	if !mapped {
+		m.keyjazz(event)
+	}
+}
+
+// keyjazz sends the given note event as a keyjazz note.
+// Keyjazz is monophonic, so a note off only stops the note
+// if it is the last held note, otherwise the previous held note is played again
+//
+func (m *midiInput) keyjazz(event midiEvent) {
+	keyjazz := m.mapping.Keyjazz
+	if keyjazz == nil || event.kind == midiControlChange {
+		return
+	}
+
+	if keyjazz.Channel != 0 && keyjazz.Channel != event.channel {
+		return
+	}
+
+	note := event.number + keyjazz.Transpose
+	if checkNote(note, event.value) != nil {
+		return
+	}
+
+	switch event.kind {
+	case midiNoteOn:
+		m.removeNote(byte(note))
+		m.notes = append(m.notes, midiNote{
+			note:     byte(note),
+			velocity: byte(event.value),
+		})
+		m.sendNoteOn(byte(note), byte(event.value))
+
+	case midiNoteOff:
+		last := len(m.notes) > 0 && m.notes[len(m.notes)-1].note == byte(note)
+		m.removeNote(byte(note))
+		if !last {
+			return
+		}
+
+		if len(m.notes) == 0 {
+			m.sendNoteOff()
+		} else {
+			previous := m.notes[len(m.notes)-1]
+			m.sendNoteOn(previous.note, previous.velocity)
+		}
+	}
+}
+
+// reset forgets the pressed buttons and the held notes, e.g. after reconnecting
+//
+func (m *midiInput) reset() {
+	for i := range m.pressed {
+		m.pressed[i] = false
+	}
+	m.notes = nil
+}
+
+func (m *midiInput) removeNote(note byte) {
+	for i, held := range m.notes {
+		if held.note == note {
+			m.notes = append(m.notes[:i], m.notes[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type midiInputTest struct {
+	input       *midiInput
+	controllers []uint8
+	notes       []string
+}
+
+func newMIDIInputTest(t *testing.T, mapping midiMapping) *midiInputTest {
+	test := &midiInputTest{}
+
+	input, err := newMIDIInput(
+		mapping,
+		func(controller uint8) {
+			test.controllers = append(test.controllers, controller)
+		},
+		func(note byte, velocity byte) {
+			test.notes = append(test.notes, fmt.Sprintf("on %d %d", note, velocity))
+		},
+		func() {
+			test.notes = append(test.notes, "off")
+		},
+	)
+	require.NoError(t, err)
+
+	test.input = input
+	return test
+}
+
+func TestMIDIMapping(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "g0m8-midi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Run("load", func(t *testing.T) {
+		path := filepath.Join(dir, "mapping.json")
+
+		err := ioutil.WriteFile(path, []byte(`
+		  {
+		    "buttons": [
+		      {"note": 36, "keys": ["SHIFT", "UP"]},
+		      {"channel": 2, "cc": 64, "keys": ["PLAY"]}
+		    ],
+		    "keyjazz": {"channel": 1, "transpose": -12}
+		  }
+		`), 0644)
+		require.NoError(t, err)
+
+		mapping, err := loadMIDIMapping(path)
+		require.NoError(t, err)
+		require.NoError(t, mapping.validate())
+
+		require.Len(t, mapping.Buttons, 2)
+		require.Equal(t, uint8(keySelect|keyUp), mapping.Buttons[0].keys)
+		require.Equal(t, uint8(keyStart), mapping.Buttons[1].keys)
+		require.Equal(t, &midiKeyjazzMapping{Channel: 1, Transpose: -12}, mapping.Keyjazz)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		note := 36
+
+		for _, mapping := range []midiMapping{
+			{Buttons: []midiButtonMapping{{Keys: []string{"UP"}}}},
+			{Buttons: []midiButtonMapping{{Note: &note, CC: &note, Keys: []string{"UP"}}}},
+			{Buttons: []midiButtonMapping{{Note: &note}}},
+			{Buttons: []midiButtonMapping{{Note: &note, Keys: []string{"FOO"}}}},
+			{Buttons: []midiButtonMapping{{Channel: 17, Note: &note, Keys: []string{"UP"}}}},
+			{Keyjazz: &midiKeyjazzMapping{Channel: 17}},
+		} {
+			require.Error(t, mapping.validate())
+		}
+	})
+}
+
+func TestMIDIInput(t *testing.T) {
+
+	note := 36
+	cc := 64
+
+	mapping := midiMapping{
+		Buttons: []midiButtonMapping{
+			{Note: &note, Keys: []string{"SHIFT"}},
+			{Channel: 2, CC: &cc, Keys: []string{"UP"}},
+		},
+		Keyjazz: &midiKeyjazzMapping{
+			Channel:   1,
+			Transpose: -12,
+		},
+	}
+
+	t.Run("buttons", func(t *testing.T) {
+		test := newMIDIInputTest(t, mapping)
+
+		test.input.handle(midiEvent{kind: midiNoteOn, channel: 3, number: 36, value: 100})
+		test.input.handle(midiEvent{kind: midiControlChange, channel: 2, number: 64, value: 127})
+		test.input.handle(midiEvent{kind: midiControlChange, channel: 2, number: 64, value: 100})
+		// Control change on another channel is ignored
+		test.input.handle(midiEvent{kind: midiControlChange, channel: 1, number: 64, value: 0})
+		test.input.handle(midiEvent{kind: midiControlChange, channel: 2, number: 64, value: 0})
+		// Note on with zero velocity is a note off
+		test.input.handle(midiEvent{kind: midiNoteOn, channel: 3, number: 36, value: 0})
+
+		require.Equal(t,
+			[]uint8{keySelect, keySelect | keyUp, keySelect, 0},
+			test.controllers,
+		)
+		require.Empty(t, test.notes)
+	})
+
+	t.Run("keyjazz", func(t *testing.T) {
+		test := newMIDIInputTest(t, mapping)
+
+		test.input.handle(midiEvent{kind: midiNoteOn, channel: 1, number: 60, value: 100})
+		test.input.handle(midiEvent{kind: midiNoteOn, channel: 1, number: 64, value: 80})
+		// Releasing a note which is not playing keeps the playing note
+		test.input.handle(midiEvent{kind: midiNoteOff, channel: 1, number: 60, value: 0})
+		test.input.handle(midiEvent{kind: midiNoteOn, channel: 1, number: 67, value: 90})
+		// Releasing the playing note plays the previous held note
+		test.input.handle(midiEvent{kind: midiNoteOff, channel: 1, number: 67, value: 0})
+		test.input.handle(midiEvent{kind: midiNoteOff, channel: 1, number: 64, value: 0})
+		// Notes on other channels are ignored
+		test.input.handle(midiEvent{kind: midiNoteOn, channel: 2, number: 60, value: 100})
+		// Notes which are out of range after transposing are ignored,
+		// including note 0, which would stop the note
+		test.input.handle(midiEvent{kind: midiNoteOn, channel: 1, number: 12, value: 100})
+		test.input.handle(midiEvent{kind: midiNoteOn, channel: 1, number: 5, value: 100})
+		test.input.handle(midiEvent{kind: midiNoteOff, channel: 1, number: 12, value: 0})
+
+		require.Equal(t,
+			[]string{
+				"on 48 100",
+				"on 52 80",
+				"on 55 90",
+				"on 52 80",
+				"off",
+			},
+			test.notes,
+		)
+		require.Empty(t, test.controllers)
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+// The overlay shows lines of text in a strip below the M8 screen,
+// so it does not cover the M8 UI. It uses a small 3x5 pixel font,
+// which only has the characters needed for the statistics and the replay status
+
+const overlayCharWidth = 4
+const overlayCharHeight = 6
+const overlayPadding = 1
+
+// overlayFont are the rows of the glyphs, with the leftmost pixel as the highest of 3 bits
+var overlayFont = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 3, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 2, 2},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'A': {2, 5, 7, 5, 5},
+	'B': {6, 5, 6, 5, 6},
+	'C': {3, 4, 4, 4, 3},
+	'D': {6, 5, 5, 5, 6},
+	'E': {7, 4, 6, 4, 7},
+	'F': {7, 4, 6, 4, 4},
+	'G': {3, 4, 5, 5, 3},
+	'H': {5, 5, 7, 5, 5},
+	'I': {7, 2, 2, 2, 7},
+	'J': {1, 1, 1, 5, 2},
+	'K': {5, 5, 6, 5, 5},
+	'L': {4, 4, 4, 4, 7},
+	'M': {5, 7, 7, 5, 5},
+	'N': {6, 5, 5, 5, 5},
+	'O': {2, 5, 5, 5, 2},
+	'P': {6, 5, 6, 4, 4},
+	'Q': {2, 5, 5, 6, 3},
+	'R': {6, 5, 6, 5, 5},
+	'S': {3, 4, 2, 1, 6},
+	'T': {7, 2, 2, 2, 2},
+	'U': {5, 5, 5, 5, 7},
+	'V': {5, 5, 5, 5, 2},
+	'W': {5, 5, 7, 7, 5},
+	'X': {5, 5, 2, 5, 5},
+	'Y': {5, 5, 2, 2, 2},
+	'Z': {7, 1, 2, 4, 7},
+	'.': {0, 0, 0, 0, 2},
+	'/': {1, 1, 2, 4, 4},
+	':': {0, 2, 0, 2, 0},
+	'-': {0, 0, 7, 0, 0},
+	'%': {5, 1, 2, 4, 5},
+	'=': {0, 7, 0, 7, 0},
+	'#': {5, 7, 5, 7, 5},
+	',': {0, 0, 0, 2, 4},
+	'!': {2, 2, 2, 0, 2},
+	'"': {5, 5, 0, 0, 0},
+}
+
+// overlayHeight returns the height of the overlay strip for the given number of lines
+//
+func overlayHeight(lines int) int {
+	if lines == 0 {
+		return 0
+	}
+	return lines*overlayCharHeight + overlayPadding
+}
+
+// drawOverlayText calls set for each pixel of the given lines of text,
+// relative to the top left corner of the overlay strip.
+// Unknown characters are drawn as spaces
+//
+func drawOverlayText(lines []string, set func(x, y int)) {
+	for row, line := range lines {
+		y := overlayPadding + row*overlayCharHeight
+
+		for column, c := range line {
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+
+			glyph, ok := overlayFont[c]
+			if !ok {
+				continue
+			}
+
+			x := overlayPadding + column*overlayCharWidth
+
+			for glyphY, bits := range glyph {
+				for glyphX := 0; glyphX < 3; glyphX++ {
+					if bits&(4>>glyphX) != 0 {
+						set(x+glyphX, y+glyphY)
+					}
+				}
+			}
+		}
+	}
+}
+
+// overlayRenderer is a renderer which can show an overlay
+//
+type overlayRenderer interface {
+	// setOverlay shows the given lines in the overlay, or hides it if there are none.
+	// The M8 screen must be redrawn when the overlay is shown or hidden
+	setOverlay(lines []string)
+}
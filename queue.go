@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// commandQueueSize is the maximum number of queued commands.
+// When the queue is full, further commands are dropped
+const commandQueueSize = 1024
+
+// commandQueueRetryTimeout is the maximum time to wait for the port
+// to become writable again, before retrying a write
+const commandQueueRetryTimeout = 100 * time.Millisecond
+
+// commandQueueCloseTimeout is the maximum time to wait for the queued commands
+// to be written when closing the queue
+const commandQueueCloseTimeout = time.Second
+
+var errCommandQueueClosed = errors.New("command queue closed")
+
+// commandQueue queues the commands sent to the M8, and writes them in order from a goroutine,
+// so sending a command never blocks, or fails when the port is temporarily not writable.
+// When the queue backs up, consecutive controller states are coalesced into the latest one,
+// unless a key press or release would be lost, e.g. a quick tap.
+// All other commands, like keyjazz note on and off, are written exactly and in order
+//
+type commandQueue struct {
+	port         io.Writer
+	waitWritable func(timeout time.Duration)
+	mutex        sync.Mutex
+	commands     [][]byte
+	// controller is the latest queued controller state,
+	// and previousController is the state before it
+	controller         byte
+	previousController byte
+	closed             bool
+	signal             chan struct{}
+	done               chan struct{}
+}
+
+// newCommandQueue returns a new queue which writes to the given port.
+// When the port is not writable, waitWritable is called to wait until it is,
+// or the given timeout elapsed. Call run to start writing
+//
+func newCommandQueue(port io.Writer, waitWritable func(timeout time.Duration)) *commandQueue {
+	return &commandQueue{
+		port:         port,
+		waitWritable: waitWritable,
+		signal:       make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+}
+
+func isControllerCommand(command []byte) bool {
+	return len(command) == len(sendControllerCommand) &&
+		command[0] == sendControllerCommand[0]
+}
+
+// canCoalesceControllerStates returns true if the pending controller state, which follows the previous state,
+// can be replaced by the next state, i.e. if no key is both changed by the pending state and changed back by the next state
+//
+func canCoalesceControllerStates(previous, pending, next byte) bool {
+	return (previous^pending)&(pending^next) == 0
+}
+
+// isWouldBlock returns true if the given error is because the port is not writable
+//
+func isWouldBlock(err error) bool {
+	return errors.Is(err, syscall.EAGAIN)
+}
+
+// Write queues the given command
+//
+func (q *commandQueue) Write(command []byte) (int, error) {
+	q.mutex.Lock()
+
+	if q.closed {
+		q.mutex.Unlock()
+		return 0, errCommandQueueClosed
+	}
+
+	last := len(q.commands) - 1
+
+	switch {
+	case isControllerCommand(command) &&
+		last >= 0 &&
+		isControllerCommand(q.commands[last]) &&
+		canCoalesceControllerStates(q.previousController, q.controller, command[1]):
+
+		// Only the latest controller state matters
+		copy(q.commands[last], command)
+		q.controller = command[1]
+
+	case len(q.commands) >= commandQueueSize:
+		log.Printf("command queue is full, dropping command: %q", command)
+
+	default:
+		q.commands = append(q.commands, append([]byte(nil), command...))
+		if isControllerCommand(command) {
+			q.previousController = q.controller
+			q.controller = command[1]
+		}
+	}
+
+	q.mutex.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+
+	return len(command), nil
+}
+
+// run writes the queued commands until the queue is closed
+//
+func (q *commandQueue) run() {
+	defer close(q.done)
+
+	for {
+		command, ok := q.next()
+		if !ok {
+			return
+		}
+
+		q.write(command)
+	}
+}
+
+// next removes the next command from the queue and returns it.
+// It waits until there is a command, and returns false if the queue is closed and empty
+//
+func (q *commandQueue) next() ([]byte, bool) {
+	for {
+		q.mutex.Lock()
+
+		if len(q.commands) > 0 {
+			command := q.commands[0]
+			q.commands = q.commands[1:]
+			q.mutex.Unlock()
+			return command, true
+		}
+
+		closed := q.closed
+		q.mutex.Unlock()
+
+		if closed {
+			return nil, false
+		}
+
+		<-q.signal
+	}
+}
+
+// write writes the whole command, retrying partial writes,
+// and waiting while the port is not writable
+//
+func (q *commandQueue) write(command []byte) {
+	for len(command) > 0 {
+		n, err := q.port.Write(command)
+		command = command[n:]
+
+		switch {
+		case err == nil && n > 0:
+			continue
+
+		case err == nil || isWouldBlock(err):
+			q.waitWritable(commandQueueRetryTimeout)
+
+		default:
+			log.Printf("failed to write command: %s", err)
+			return
+		}
+	}
+}
+
+// close stops queueing commands, and waits until the queued commands are written
+//
+func (q *commandQueue) close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-q.done:
+	case <-time.After(commandQueueCloseTimeout):
+		log.Println("timed out writing the queued commands")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyPort writes at most one byte per write,
+// and is not writable every other write
+//
+type flakyPort struct {
+	written bytes.Buffer
+	writes  int
+}
+
+func (p *flakyPort) Write(data []byte) (int, error) {
+	p.writes++
+	if p.writes%2 == 0 {
+		return 0, &os.PathError{Op: "write", Path: "/dev/m8", Err: syscall.EAGAIN}
+	}
+	return p.written.Write(data[:1])
+}
+
+// blockingPort blocks the first write until unblocked
+//
+type blockingPort struct {
+	written bytes.Buffer
+	writing chan struct{}
+	unblock chan struct{}
+	blocked bool
+}
+
+func (p *blockingPort) Write(data []byte) (int, error) {
+	if !p.blocked {
+		p.blocked = true
+		close(p.writing)
+		<-p.unblock
+	}
+	return p.written.Write(data)
+}
+
+func TestCommandQueue(t *testing.T) {
+
+	t.Run("coalesces consecutive controller states, keeps notes in order", func(t *testing.T) {
+		var port bytes.Buffer
+		queue := newCommandQueue(&port, func(time.Duration) {})
+
+		sendController(queue, keyUp)
+		sendController(queue, keyUp|keyEdit)
+		sendNoteOn(queue, 60, 100)
+		sendController(queue, keyEdit)
+		sendController(queue, 0)
+		sendController(queue, keyDown)
+		sendNoteOff(queue)
+		sendNoteOn(queue, 62, 90)
+		sendNoteOff(queue)
+		disconnect(queue)
+
+		go queue.run()
+		queue.close()
+
+		require.Equal(t,
+			[]byte{
+				'C', keyUp | keyEdit,
+				'K', 60, 100,
+				'C', keyDown,
+				'K', 0,
+				'K', 62, 90,
+				'K', 0,
+				// Not coalesced with the previous controller state,
+				// as a note off is in between
+				'C', 0,
+				'D',
+			},
+			port.Bytes(),
+		)
+	})
+
+	t.Run("keeps taps while the writer is blocked", func(t *testing.T) {
+		port := &blockingPort{
+			writing: make(chan struct{}),
+			unblock: make(chan struct{}),
+		}
+		queue := newCommandQueue(port, func(time.Duration) {})
+		go queue.run()
+
+		sendController(queue, keyUp)
+		<-port.writing
+
+		// Tap edit
+		sendController(queue, keyUp|keyEdit)
+		sendController(queue, keyUp)
+		// Release up, then press up and down
+		sendController(queue, 0)
+		sendController(queue, keyUp|keyDown)
+		// Release both
+		sendController(queue, keyUp)
+		sendController(queue, 0)
+
+		close(port.unblock)
+		queue.close()
+
+		require.Equal(t,
+			[]byte{
+				'C', keyUp,
+				'C', keyUp | keyEdit,
+				// The releases of edit and up are coalesced
+				'C', 0,
+				'C', keyUp | keyDown,
+				// The releases of down and up are coalesced
+				'C', 0,
+			},
+			port.written.Bytes(),
+		)
+	})
+
+	t.Run("retries partial writes and unwritable port", func(t *testing.T) {
+		var port flakyPort
+		var waits int
+		queue := newCommandQueue(&port, func(timeout time.Duration) {
+			require.Equal(t, commandQueueRetryTimeout, timeout)
+			waits++
+		})
+
+		go queue.run()
+
+		enableAndResetDisplay(queue)
+		sendNoteOn(queue, 60, 100)
+
+		queue.close()
+
+		require.Equal(t, []byte{'E', 'R', 'K', 60, 100}, port.written.Bytes())
+		require.Equal(t, 4, waits)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		var port bytes.Buffer
+		queue := newCommandQueue(&port, func(time.Duration) {})
+		go queue.run()
+		queue.close()
+
+		_, err := queue.Write([]byte{'D'})
+		require.True(t, errors.Is(err, errCommandQueueClosed))
+	})
+}
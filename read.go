@@ -1,37 +1,28 @@
 package main
 
-import (
-	"log"
-	"os"
-)
+import "io"
 
-func newReader(port *os.File) func(handle func(packet []byte)) {
+// newReader returns a function which reads from the given port,
+// and calls the given handler for each received packet.
+// It returns the number of bytes read, and a slipError if invalid frames were dropped
+//
+func newReader(port io.Reader) func(handle func(packet []byte)) (int, error) {
 
 	buf := make([]byte, 4*1024)
-	readStartIndex := 0
 
-	return func(handle func(packet []byte)) {
+	var decoder slipDecoder
+
+	return func(handle func(packet []byte)) (int, error) {
 
 		// Read raw data from serial port
 
-		n, err := port.Read(buf[readStartIndex:])
+		n, err := port.Read(buf)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 
 		// Read the raw data as a SLIP packets
 
-		data := buf[:readStartIndex+n]
-
-		remaining, err := decodeSLIP(data, handle)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// There might be an incomplete packet,
-		// copy it to the start
-
-		readStartIndex = len(remaining)
-		copy(buf[:], remaining)
+		return n, decoder.decode(buf[:n], handle)
 	}
 }
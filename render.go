@@ -1,7 +1,9 @@
 package main
 
 import (
+	"image"
 	"math"
+	"unsafe"
 
 	"github.com/veandco/go-sdl2/sdl"
 )
@@ -9,18 +11,72 @@ import (
 const screenWidth = 320
 const screenHeight = 240
 
+type renderer interface {
+	draw(command Command)
+	render()
+	toggleFullscreen()
+	quit()
+}
+
+// sdlRenderer draws into a target texture of the size of the M8 screen,
+// which is scaled to the window when rendering
+//
 type sdlRenderer struct {
 	backgroundColor Color
 	fullscreen      bool
 	window          *sdl.Window
 	renderer        *sdl.Renderer
 	font            *sdl.Texture
-	waveform        [screenWidth]sdl.Point
+	target          *sdl.Texture
+	targetHeight    int32
+	scaling         scalingMode
+	filter          scaleFilter
+	// letterboxColor is the color around the scaled screen. If nil, the background color is used
+	letterboxColor *Color
+	waveformStyle  waveformStyle
+	waveformTrail  *waveformTrail
+	waveformSpans  []waveformSpan
+	waveformRects  []sdl.Rect
+	overlay        []string
+	overlayPoints  []sdl.Point
+	software       bool
+	effects        effects
+	// effectsTexture is the target texture scaled by effectsScale, with the effects applied.
+	// For the software renderer, it is a streaming texture updated by postProcessor
+	effectsTexture    *sdl.Texture
+	effectsScale      int
+	effectsHeight     int32
+	effectsSource     *image.RGBA
+	postProcessor     *postProcessor
+	scanlineRects     []sdl.Rect
+	lcdGridRects      []sdl.Rect
+	curvatureVertices []sdl.Vertex
+	curvatureIndices  []int32
+	// dirty are the areas of the target texture which changed since the last render.
+	// Nothing is presented if nothing changed
+	dirty dirtyRegion
 }
 
-func newSDLRenderer(width, height int32, software bool) *sdlRenderer {
-
-	r := &sdlRenderer{}
+func newSDLRenderer(
+	width, height int32,
+	software bool,
+	scaling scalingMode,
+	filter scaleFilter,
+	letterboxColor *Color,
+	effects effects,
+	waveformStyle waveformStyle,
+	waveformTrail int,
+) *sdlRenderer {
+
+	r := &sdlRenderer{
+		scaling:        scaling,
+		filter:         filter,
+		letterboxColor: letterboxColor,
+		software:       software,
+		effects:        effects,
+		waveformStyle:  waveformStyle,
+		waveformTrail:  newWaveformTrail(waveformTrail),
+	}
 
 	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
 		panic(err)
@@ -33,7 +89,7 @@ func newSDLRenderer(width, height int32, software bool) *sdlRenderer {
 		"M8",
 		sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED,
 		width, height,
-		sdl.WINDOW_SHOWN,
+		sdl.WINDOW_SHOWN|sdl.WINDOW_RESIZABLE,
 	)
 	if err != nil {
 		panic(err)
@@ -43,19 +99,59 @@ func newSDLRenderer(width, height int32, software bool) *sdlRenderer {
 	if software {
 		flags = sdl.RENDERER_SOFTWARE
 	}
-	r.renderer, err = sdl.CreateRenderer(r.window, -1, flags)
+	r.renderer, err = sdl.CreateRenderer(r.window, -1, flags|sdl.RENDERER_TARGETTEXTURE)
 	if err != nil {
 		panic(err)
 	}
 
-	err = r.renderer.SetLogicalSize(screenWidth, screenHeight)
+	r.initFont()
+	r.initTarget(screenHeight)
+
+	return r
+}
+
+// createTexture creates a texture which is scaled with the scale filter
+//
+func (r *sdlRenderer) createTexture(format uint32, access int, width, height int32) *sdl.Texture {
+	// The scale quality of a texture is determined when it is created
+	sdl.SetHint(sdl.HINT_RENDER_SCALE_QUALITY, string(r.filter))
+
+	texture, err := r.renderer.CreateTexture(format, access, width, height)
 	if err != nil {
 		panic(err)
 	}
+	return texture
+}
 
-	r.initFont()
+// initTarget (re)creates the target texture with the given height.
+// The content of a previous target texture is lost
+//
+func (r *sdlRenderer) initTarget(height int32) {
+	if r.target != nil {
+		_ = r.target.Destroy()
+	}
 
-	return r
+	r.target = r.createTexture(
+		sdl.PIXELFORMAT_ARGB8888,
+		sdl.TEXTUREACCESS_TARGET,
+		screenWidth,
+		height,
+	)
+	r.targetHeight = height
+	r.invalidate()
+
+	err := r.renderer.SetRenderTarget(r.target)
+	if err != nil {
+		panic(err)
+	}
+
+	_ = r.renderer.SetDrawColor(
+		r.backgroundColor.r,
+		r.backgroundColor.g,
+		r.backgroundColor.b,
+		math.MaxUint8,
+	)
+	_ = r.renderer.Clear()
 }
 
 func (r *sdlRenderer) initFont() {
@@ -92,6 +188,10 @@ func (r *sdlRenderer) initFont() {
 }
 
 func (r *sdlRenderer) quit() {
+	if r.effectsTexture != nil {
+		_ = r.effectsTexture.Destroy()
+	}
+	_ = r.target.Destroy()
 	_ = r.font.Destroy()
 	_ = r.renderer.Destroy()
 	_ = r.window.Destroy()
@@ -99,6 +199,8 @@ func (r *sdlRenderer) quit() {
 }
 
 func (r *sdlRenderer) draw(command Command) {
+	r.dirty.add(commandBounds(command).Intersect(r.targetRect()))
+
 	switch command := command.(type) {
 	case DrawRectangleCommand:
 		r.drawRectangle(command)
@@ -118,17 +220,362 @@ func (r *sdlRenderer) toggleFullscreen() {
 	}
 	_ = r.window.SetFullscreen(flags)
 	r.fullscreen = !r.fullscreen
+	r.invalidate()
+}
+
+func (r *sdlRenderer) targetRect() image.Rectangle {
+	return image.Rect(0, 0, screenWidth, int(r.targetHeight))
+}
+
+// invalidate marks the whole target texture as changed,
+// so it is presented on the next render, e.g. after the window got resized
+//
+func (r *sdlRenderer) invalidate() {
+	r.dirty.add(r.targetRect())
 }
 
 func (r *sdlRenderer) render() {
-	r.renderer.Present()
+	if r.dirty.empty() {
+		return
+	}
+
+	renderer := r.renderer
+
+	var rect image.Rectangle
+	width, height, err := renderer.GetOutputSize()
+	if err == nil {
+		rect = scaleRect(
+			r.scaling,
+			int(width),
+			int(height),
+			screenWidth,
+			int(r.targetHeight),
+		)
+	}
+
+	texture := r.target
+	if r.effects.enabled() && !rect.Empty() {
+		texture = r.applyEffects(rect)
+	}
+
+	_ = renderer.SetRenderTarget(nil)
+
+	letterboxColor := r.backgroundColor
+	if r.letterboxColor != nil {
+		letterboxColor = *r.letterboxColor
+	}
+
+	_ = renderer.SetDrawColor(
+		letterboxColor.r,
+		letterboxColor.g,
+		letterboxColor.b,
+		math.MaxUint8,
+	)
+	_ = renderer.Clear()
+
+	if !rect.Empty() {
+		// The software renderer already applied the curvature when post-processing
+		if r.effects.curvature && !r.software {
+			r.drawCurved(texture, rect)
+		} else {
+			_ = renderer.Copy(texture, nil, &sdl.Rect{
+				X: int32(rect.Min.X),
+				Y: int32(rect.Min.Y),
+				W: int32(rect.Dx()),
+				H: int32(rect.Dy()),
+			})
+		}
+	}
+
+	renderer.Present()
+
+	_ = renderer.SetRenderTarget(r.target)
+
+	r.dirty.reset()
+}
+
+// initEffects (re)creates the effects texture for the given scale,
+// if the scale or the height of the target texture changed
+//
+func (r *sdlRenderer) initEffects(scale int) {
+	if r.effectsTexture != nil &&
+		r.effectsScale == scale &&
+		r.effectsHeight == r.targetHeight {
+
+		return
+	}
+
+	if r.effectsTexture != nil {
+		_ = r.effectsTexture.Destroy()
+	}
+
+	width := int32(screenWidth * scale)
+	height := r.targetHeight * int32(scale)
+
+	var format uint32 = sdl.PIXELFORMAT_ARGB8888
+	access := sdl.TEXTUREACCESS_TARGET
+	if r.software {
+		// The byte order of image.RGBA
+		format = sdl.PIXELFORMAT_ABGR8888
+		access = sdl.TEXTUREACCESS_STREAMING
+
+		r.effectsSource = image.NewRGBA(image.Rect(0, 0, screenWidth, int(r.targetHeight)))
+		r.postProcessor = newPostProcessor(r.effects, screenWidth, int(r.targetHeight), scale)
+	} else {
+		r.initEffectRects(scale)
+	}
+
+	r.effectsTexture = r.createTexture(format, access, width, height)
+
+	r.effectsScale = scale
+	r.effectsHeight = r.targetHeight
+
+	// The new texture has no content yet
+	r.invalidate()
+}
+
+// initEffectRects computes the rectangles which are darkened for the scanlines and the LCD grid
+//
+func (r *sdlRenderer) initEffectRects(scale int) {
+	width := int32(screenWidth * scale)
+	height := r.targetHeight * int32(scale)
+	pixelSize := int32(scale)
+
+	r.scanlineRects = r.scanlineRects[:0]
+	r.lcdGridRects = r.lcdGridRects[:0]
+
+	for y := int32(0); y < r.targetHeight; y++ {
+		r.scanlineRects = append(r.scanlineRects, sdl.Rect{
+			X: 0,
+			Y: y*pixelSize + pixelSize/2,
+			W: width,
+			H: pixelSize - pixelSize/2,
+		})
+
+		r.lcdGridRects = append(r.lcdGridRects, sdl.Rect{
+			X: 0,
+			Y: y*pixelSize + pixelSize - 1,
+			W: width,
+			H: 1,
+		})
+	}
+
+	for x := int32(0); x < screenWidth; x++ {
+		r.lcdGridRects = append(r.lcdGridRects, sdl.Rect{
+			X: x*pixelSize + pixelSize - 1,
+			Y: 0,
+			W: 1,
+			H: height,
+		})
+	}
+}
+
+// applyEffects applies the effects to the target texture, which must be the current render target,
+// and returns the texture with the effects applied
+//
+func (r *sdlRenderer) applyEffects(rect image.Rectangle) *sdl.Texture {
+	r.initEffects(effectsScale(rect.Dy(), int(r.targetHeight)))
+
+	if r.software {
+		r.processEffects(r.dirty.bounds())
+	} else {
+		r.drawEffects()
+	}
+
+	return r.effectsTexture
+}
+
+// processEffects applies the effects in software to the given changed rectangle
+//
+func (r *sdlRenderer) processEffects(rect image.Rectangle) {
+	source := r.effectsSource
+
+	err := r.renderer.ReadPixels(
+		&sdl.Rect{
+			X: int32(rect.Min.X),
+			Y: int32(rect.Min.Y),
+			W: int32(rect.Dx()),
+			H: int32(rect.Dy()),
+		},
+		sdl.PIXELFORMAT_ABGR8888,
+		unsafe.Pointer(&source.Pix[source.PixOffset(rect.Min.X, rect.Min.Y)]),
+		source.Stride,
+	)
+	if err != nil {
+		return
+	}
+
+	outputRect := r.postProcessor.processRect(source, rect)
+	output := r.postProcessor.output
+
+	_ = r.effectsTexture.Update(
+		&sdl.Rect{
+			X: int32(outputRect.Min.X),
+			Y: int32(outputRect.Min.Y),
+			W: int32(outputRect.Dx()),
+			H: int32(outputRect.Dy()),
+		},
+		output.Pix[output.PixOffset(outputRect.Min.X, outputRect.Min.Y):],
+		output.Stride,
+	)
+}
+
+// drawEffects applies the effects by drawing the target texture
+// into the effects texture and blending over it
+//
+func (r *sdlRenderer) drawEffects() {
+	renderer := r.renderer
+
+	_ = renderer.SetRenderTarget(r.effectsTexture)
+	_ = renderer.Copy(r.target, nil, nil)
+
+	scale := int32(r.effectsScale)
+
+	if r.effects.glow {
+		// Add the 3x3 box blur of the screen
+		_ = r.target.SetBlendMode(sdl.BLENDMODE_ADD)
+		_ = r.target.SetAlphaMod(uint8(math.Round(glowIntensity * math.MaxUint8 / 9)))
+
+		for dy := int32(-1); dy <= 1; dy++ {
+			for dx := int32(-1); dx <= 1; dx++ {
+				_ = renderer.Copy(r.target, nil, &sdl.Rect{
+					X: dx * scale,
+					Y: dy * scale,
+					W: screenWidth * scale,
+					H: r.targetHeight * scale,
+				})
+			}
+		}
+
+		_ = r.target.SetAlphaMod(math.MaxUint8)
+		_ = r.target.SetBlendMode(sdl.BLENDMODE_NONE)
+	}
+
+	_ = renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+
+	if r.effects.scanlines {
+		_ = renderer.SetDrawColor(0, 0, 0, uint8(math.Round(scanlineIntensity*math.MaxUint8)))
+		_ = renderer.FillRects(r.scanlineRects)
+	}
+
+	if r.effects.lcd {
+		_ = renderer.SetDrawColor(0, 0, 0, uint8(math.Round(lcdGridIntensity*math.MaxUint8)))
+		_ = renderer.FillRects(r.lcdGridRects)
+	}
+
+	_ = renderer.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+}
+
+const curvatureMeshColumns = 32
+const curvatureMeshRows = 24
+
+// drawCurved draws the given texture into the given rectangle,
+// as a mesh which is curved like the glass of a CRT
+//
+func (r *sdlRenderer) drawCurved(texture *sdl.Texture, rect image.Rectangle) {
+	if r.curvatureIndices == nil {
+		r.initCurvatureMesh()
+	}
+
+	i := 0
+	for row := 0; row <= curvatureMeshRows; row++ {
+		for column := 0; column <= curvatureMeshColumns; column++ {
+			position := &r.curvatureVertices[i].Position
+			position.X = float32(rect.Min.X) + float32(rect.Dx()*column)/curvatureMeshColumns
+			position.Y = float32(rect.Min.Y) + float32(rect.Dy()*row)/curvatureMeshRows
+			i++
+		}
+	}
+
+	_ = r.renderer.RenderGeometry(texture, r.curvatureVertices, r.curvatureIndices)
+}
+
+// initCurvatureMesh computes the texture coordinates of the curvature mesh.
+// Vertices which are curved outside of the screen are clamped and black
+//
+func (r *sdlRenderer) initCurvatureMesh() {
+	for row := 0; row <= curvatureMeshRows; row++ {
+		for column := 0; column <= curvatureMeshColumns; column++ {
+			u := float64(column)/curvatureMeshColumns*2 - 1
+			v := float64(row)/curvatureMeshRows*2 - 1
+			u, v = curve(u, v)
+
+			color := sdl.Color{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+			if u < -1 || u > 1 || v < -1 || v > 1 {
+				color = sdl.Color{A: 0xff}
+				u = math.Max(-1, math.Min(1, u))
+				v = math.Max(-1, math.Min(1, v))
+			}
+
+			r.curvatureVertices = append(r.curvatureVertices, sdl.Vertex{
+				Color: color,
+				TexCoord: sdl.FPoint{
+					X: float32((u + 1) / 2),
+					Y: float32((v + 1) / 2),
+				},
+			})
+		}
+	}
+
+	for row := 0; row < curvatureMeshRows; row++ {
+		for column := 0; column < curvatureMeshColumns; column++ {
+			topLeft := int32(row*(curvatureMeshColumns+1) + column)
+			bottomLeft := topLeft + curvatureMeshColumns + 1
+
+			r.curvatureIndices = append(r.curvatureIndices,
+				topLeft, topLeft+1, bottomLeft,
+				topLeft+1, bottomLeft+1, bottomLeft,
+			)
+		}
+	}
+}
+
+func (r *sdlRenderer) setOverlay(lines []string) {
+	if len(lines) != len(r.overlay) {
+		// Make room for the overlay below the M8 screen
+		r.initTarget(int32(screenHeight + overlayHeight(len(lines))))
+	}
+
+	r.overlay = lines
+
+	if len(lines) > 0 {
+		r.drawOverlay()
+		r.dirty.add(image.Rect(0, screenHeight, screenWidth, int(r.targetHeight)))
+	}
+}
+
+func (r *sdlRenderer) drawOverlay() {
+	renderer := r.renderer
+
+	_ = renderer.SetDrawColor(0, 0, 0, math.MaxUint8)
+
+	renderRect := sdl.Rect{
+		X: 0,
+		Y: screenHeight,
+		W: screenWidth,
+		H: int32(overlayHeight(len(r.overlay))),
+	}
+
+	_ = renderer.FillRect(&renderRect)
+
+	r.overlayPoints = r.overlayPoints[:0]
+
+	drawOverlayText(r.overlay, func(x, y int) {
+		r.overlayPoints = append(r.overlayPoints, sdl.Point{
+			X: int32(x),
+			Y: int32(screenHeight + y),
+		})
+	})
+
+	_ = renderer.SetDrawColor(0xff, 0xff, 0xff, math.MaxUint8)
+	_ = renderer.DrawPoints(r.overlayPoints)
 }
 
 func (r *sdlRenderer) drawCharacter(command DrawCharacterCommand) {
 	renderer := r.renderer
 
-	x := int32(command.pos.x)
-	y := int32(command.pos.y)
+	x := int(command.pos.x)
+	y := int(command.pos.y)
 
 	if command.background != command.foreground {
 		_ = renderer.SetDrawColor(
@@ -138,12 +585,7 @@ func (r *sdlRenderer) drawCharacter(command DrawCharacterCommand) {
 			math.MaxUint8,
 		)
 
-		var renderRect = sdl.Rect{
-			X: x - 1,
-			Y: y + 2,
-			W: fontCharWidth - 1,
-			H: fontCharHeight + 1,
-		}
+		var renderRect = toSDLRect(characterBackgroundRect(x, y))
 		_ = renderer.FillRect(&renderRect)
 	}
 
@@ -153,24 +595,19 @@ func (r *sdlRenderer) drawCharacter(command DrawCharacterCommand) {
 		command.foreground.b,
 	)
 
-	row := command.c / fontCharsByRow
-	column := command.c % fontCharsByRow
+	var sourceRect = toSDLRect(fontGlyphRect(command.c))
+	var renderRect = toSDLRect(characterGlyphRect(x, y))
 
-	var sourceRect = sdl.Rect{
-		X: int32(column * 8),
-		Y: int32(row * 8),
-		W: fontCharWidth,
-		H: fontCharHeight,
-	}
+	_ = renderer.Copy(r.font, &sourceRect, &renderRect)
+}
 
-	var renderRect = sdl.Rect{
-		X: x,
-		Y: y + 3,
-		W: fontCharWidth,
-		H: fontCharHeight,
+func toSDLRect(rect image.Rectangle) sdl.Rect {
+	return sdl.Rect{
+		X: int32(rect.Min.X),
+		Y: int32(rect.Min.Y),
+		W: int32(rect.Dx()),
+		H: int32(rect.Dy()),
 	}
-
-	_ = renderer.Copy(r.font, &sourceRect, &renderRect)
 }
 
 func (r *sdlRenderer) drawRectangle(command DrawRectangleCommand) {
@@ -210,7 +647,7 @@ func (r *sdlRenderer) drawWaveform(command DrawOscilloscopeWaveformCommand) {
 		X: 0,
 		Y: 0,
 		W: screenWidth,
-		H: screenHeight / 10,
+		H: waveformHeight,
 	}
 
 	_ = renderer.SetDrawColor(
@@ -223,20 +660,44 @@ func (r *sdlRenderer) drawWaveform(command DrawOscilloscopeWaveformCommand) {
 	_ = renderer.FillRect(&renderRect)
 
 	if len(command.waveform) == 0 {
+		r.waveformTrail.clear()
 		return
 	}
 
-	_ = renderer.SetDrawColor(
-		command.color.r,
-		command.color.g,
-		command.color.b,
-		math.MaxUint8,
-	)
+	r.waveformTrail.push(command.waveform)
+
+	r.waveformTrail.each(func(waveform []byte, brightness float64) {
+		color := fadeColor(r.backgroundColor, command.color, brightness)
+
+		_ = renderer.SetDrawColor(
+			color.r,
+			color.g,
+			color.b,
+			math.MaxUint8,
+		)
+
+		r.waveformSpans = appendWaveformSpans(r.waveformSpans[:0], r.waveformStyle, waveform)
+		r.waveformRects = appendWaveformRects(r.waveformRects[:0], r.waveformSpans, screenWidth, waveformHeight)
+
+		_ = renderer.FillRects(r.waveformRects)
+	})
+}
 
-	for i, y := range command.waveform {
-		r.waveform[i].X = int32(i)
-		r.waveform[i].Y = int32(y)
+// appendWaveformRects appends the rectangles for the given waveform spans,
+// scaled from the oscilloscope strip to the given size
+//
+func appendWaveformRects(rects []sdl.Rect, spans []waveformSpan, width, height int32) []sdl.Rect {
+	for _, span := range spans {
+		x := int32(span.x) * width / screenWidth
+		y := int32(span.top) * height / waveformHeight
+
+		rects = append(rects, sdl.Rect{
+			X: x,
+			Y: y,
+			W: int32(span.x+1)*width/screenWidth - x,
+			H: int32(span.bottom+1)*height/waveformHeight - y,
+		})
 	}
 
-	_ = renderer.DrawPoints(r.waveform[:])
+	return rects
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var trafficFlag = flag.String("traffic", "", "file of recorded serial traffic from the M8 for the benchmarks (default: synthetic traffic)")
+
+// appendSLIPPacket appends the given packet, SLIP encoded
+//
+func appendSLIPPacket(data []byte, packet []byte) []byte {
+	for _, b := range packet {
+		switch b {
+		case slipEnd:
+			data = append(data, slipEsc, slipEscEnd)
+		case slipEsc:
+			data = append(data, slipEsc, slipEscEsc)
+		default:
+			data = append(data, b)
+		}
+	}
+	return append(data, slipEnd)
+}
+
+// syntheticTraffic returns serial traffic like the M8 sends for the given number of frames
+// of a playing song: a waveform, a moving cursor, and a few changing characters each frame,
+// after a full redraw of the screen
+//
+func syntheticTraffic(frames int) []byte {
+	var data []byte
+
+	rectangle := func(x, y, width, height int, color Color) {
+		packet := []byte{drawRectangleCommand}
+		for _, value := range []int{x, y, width, height} {
+			packet = append(packet, 0, 0)
+			binary.LittleEndian.PutUint16(packet[len(packet)-2:], uint16(value))
+		}
+		packet = append(packet, color.r, color.g, color.b)
+		data = appendSLIPPacket(data, packet)
+	}
+
+	character := func(c byte, x, y int, foreground, background Color) {
+		packet := []byte{drawCharacterCommand, c}
+		for _, value := range []int{x, y} {
+			packet = append(packet, 0, 0)
+			binary.LittleEndian.PutUint16(packet[len(packet)-2:], uint16(value))
+		}
+		packet = append(packet,
+			foreground.r, foreground.g, foreground.b,
+			background.r, background.g, background.b,
+		)
+		data = appendSLIPPacket(data, packet)
+	}
+
+	background := Color{}
+	foreground := Color{r: 0x60, g: 0xff, b: 0xff}
+	highlight := Color{r: 0x30, g: 0x30, b: 0x30}
+
+	rectangle(0, 0, screenWidth, screenHeight, background)
+
+	const columns = 39
+	const rows = 24
+
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			c := byte('0' + (row+column)%10)
+			character(c, column*fontCharWidth, row*10, foreground, background)
+		}
+	}
+
+	waveform := make([]byte, screenWidth)
+
+	for frame := 0; frame < frames; frame++ {
+		packet := []byte{drawOscilloscopeWaveformCommand, foreground.r, foreground.g, foreground.b}
+		for x := range waveform {
+			waveform[x] = byte((x*7 + frame*13) % waveformHeight)
+		}
+		data = appendSLIPPacket(data, append(packet, waveform...))
+
+		// The cursor moves down a row of the phrase
+		row := 3 + frame%16
+		rectangle(0, (row-1)*10+2, screenWidth, 10, background)
+		rectangle(0, row*10+2, screenWidth, 10, highlight)
+
+		for column := 0; column < 4; column++ {
+			c := byte('A' + (frame+column)%26)
+			character(c, (8+column*5)*fontCharWidth, row*10, foreground, highlight)
+		}
+	}
+
+	return data
+}
+
+func benchmarkTraffic(b *testing.B) []byte {
+	if *trafficFlag == "" {
+		return syntheticTraffic(300)
+	}
+
+	data, err := ioutil.ReadFile(*trafficFlag)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+// benchmarkDecode decodes the traffic, and calls the given function for each command.
+// After each waveform command, i.e. each frame, it calls the given frame function
+//
+func benchmarkDecode(b *testing.B, data []byte, draw func(Command), frame func()) {
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		var decoder slipDecoder
+		err := decoder.decode(data, func(packet []byte) {
+			command, err := decodeCommand(packet)
+			if err != nil {
+				return
+			}
+
+			draw(command)
+
+			if _, ok := command.(DrawOscilloscopeWaveformCommand); ok {
+				frame()
+			}
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	benchmarkDecode(b, benchmarkTraffic(b), func(Command) {}, func() {})
+}
+
+func BenchmarkDecodeAndDrawFramebuffer(b *testing.B) {
+	f := newFramebuffer()
+	var dirty dirtyRegion
+
+	benchmarkDecode(
+		b,
+		benchmarkTraffic(b),
+		func(command Command) {
+			dirty.add(commandBounds(command))
+			f.draw(command)
+		},
+		dirty.reset,
+	)
+}
+
+// BenchmarkDecodeAndRender renders with the SDL renderer after each frame,
+// presenting only when something changed ("dirty"), or always ("full").
+// It needs a video device, e.g. SDL_VIDEODRIVER=dummy
+//
+func BenchmarkDecodeAndRender(b *testing.B) {
+	if err := sdl.Init(sdl.INIT_VIDEO); err != nil {
+		b.Skipf("SDL is not available: %s", err)
+	}
+
+	data := benchmarkTraffic(b)
+
+	for _, software := range []bool{true, false} {
+		for _, full := range []bool{false, true} {
+
+			name := "accelerated"
+			if software {
+				name = "software"
+			}
+			if full {
+				name += "/full"
+			} else {
+				name += "/dirty"
+			}
+
+			b.Run(name, func(b *testing.B) {
+				r := newSDLRenderer(
+					screenWidth*2,
+					screenHeight*2,
+					software,
+					scalingInteger,
+					scaleFilterNearest,
+					nil,
+					effects{},
+					waveformPoints,
+					1,
+				)
+				defer r.quit()
+
+				benchmarkDecode(b, data, r.draw, func() {
+					if full {
+						r.invalidate()
+					}
+					r.render()
+				})
+			})
+		}
+	}
+}
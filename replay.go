@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// replayKeyframeInterval is the capture time between keyframes,
+// from which seeking continues, instead of replaying from the start
+const replayKeyframeInterval = 10 * time.Second
+
+// replayFrameGap is the minimum time between packets of separate frames.
+// The M8 sends the packets of a frame in a burst
+const replayFrameGap = 5 * time.Millisecond
+
+const replayMinSpeed = 0.25
+const replayMaxSpeed = 16
+
+// replayRenderInterval is the interval of rendering the replay
+const replayRenderInterval = time.Second / 60
+
+type replayPacket struct {
+	time time.Duration
+	data []byte
+}
+
+// replayKeyframe is the screen before a packet was applied
+//
+type replayKeyframe struct {
+	// index is the index of the packet to apply after restoring the keyframe
+	index           int
+	backgroundColor Color
+	// pixels are the compressed pixels of the screen
+	pixels []byte
+}
+
+// replay plays back the packets received from the M8 of a capture.
+// The packets are applied through the given draw function, exactly like in live mode
+//
+type replay struct {
+	packets   []replayPacket
+	keyframes []replayKeyframe
+	draw      func(Command)
+	// index is the index of the next packet to apply
+	index    int
+	position time.Duration
+	playing  bool
+	speed    float64
+}
+
+func newReplay(records []captureRecord, draw func(Command)) (*replay, error) {
+	r := &replay{
+		draw:  draw,
+		speed: 1,
+	}
+
+	for _, record := range records {
+		if record.kind != captureReceived {
+			continue
+		}
+		r.packets = append(r.packets, replayPacket{
+			time: record.time,
+			data: record.data,
+		})
+	}
+
+	err := r.initKeyframes()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// initKeyframes draws all packets into a framebuffer, and keeps the screen periodically
+//
+func (r *replay) initKeyframes() error {
+	f := newFramebuffer()
+
+	var nextKeyframe time.Duration
+
+	for i, packet := range r.packets {
+		if i == 0 || packet.time >= nextKeyframe {
+			keyframe, err := newReplayKeyframe(i, f)
+			if err != nil {
+				return err
+			}
+			r.keyframes = append(r.keyframes, keyframe)
+
+			nextKeyframe = packet.time + replayKeyframeInterval
+		}
+
+		command, err := decodeCommand(packet.data)
+		if err == nil {
+			f.draw(command)
+		}
+	}
+
+	return nil
+}
+
+func newReplayKeyframe(index int, f *framebuffer) (replayKeyframe, error) {
+	keyframe := replayKeyframe{
+		index:           index,
+		backgroundColor: f.backgroundColor,
+	}
+
+	var buf bytes.Buffer
+
+	writer, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return keyframe, err
+	}
+
+	_, err = writer.Write(f.image.Pix)
+	if err != nil {
+		return keyframe, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return keyframe, err
+	}
+
+	keyframe.pixels = buf.Bytes()
+
+	return keyframe, nil
+}
+
+// restore draws the screen of the keyframe
+//
+func (k replayKeyframe) restore(draw func(Command)) error {
+	screen := image.NewRGBA(image.Rect(0, 0, screenWidth, screenHeight))
+
+	_, err := io.ReadFull(flate.NewReader(bytes.NewReader(k.pixels)), screen.Pix)
+	if err != nil {
+		return err
+	}
+
+	// Clear the screen with the background color
+
+	draw(DrawRectangleCommand{
+		size: Size{
+			width:  screenWidth,
+			height: screenHeight,
+		},
+		color: k.backgroundColor,
+	})
+
+	// Draw all runs of pixels of the same color in each row,
+	// which are not the background color
+
+	for y := 0; y < screenHeight; y++ {
+		for x := 0; x < screenWidth; {
+			pixel := screen.RGBAAt(x, y)
+			color := Color{r: pixel.R, g: pixel.G, b: pixel.B}
+
+			end := x + 1
+			for end < screenWidth && screen.RGBAAt(end, y) == pixel {
+				end++
+			}
+
+			if color != k.backgroundColor {
+				draw(DrawRectangleCommand{
+					pos: Position{
+						x: int16(x),
+						y: int16(y),
+					},
+					size: Size{
+						width:  int16(end - x),
+						height: 1,
+					},
+					color: color,
+				})
+			}
+
+			x = end
+		}
+	}
+
+	return nil
+}
+
+func (r *replay) duration() time.Duration {
+	if len(r.packets) == 0 {
+		return 0
+	}
+	return r.packets[len(r.packets)-1].time
+}
+
+// apply applies the next packet
+//
+func (r *replay) apply() {
+	packet := r.packets[r.index]
+	r.index++
+	r.position = packet.time
+
+	command, err := decodeCommand(packet.data)
+	if err != nil {
+		return
+	}
+
+	r.draw(command)
+}
+
+// step pauses and applies the next packet
+//
+func (r *replay) step() {
+	r.playing = false
+
+	if r.index < len(r.packets) {
+		r.apply()
+	}
+}
+
+// stepFrame pauses and applies the packets of the next frame
+//
+func (r *replay) stepFrame() {
+	r.playing = false
+
+	if r.index >= len(r.packets) {
+		return
+	}
+
+	r.apply()
+
+	for r.index < len(r.packets) &&
+		r.packets[r.index].time-r.position < replayFrameGap {
+
+		r.apply()
+	}
+}
+
+// seekIndex restores the screen before the packet at the given index was applied,
+// from the closest keyframe
+//
+func (r *replay) seekIndex(index int) error {
+	if index < 0 {
+		index = 0
+	}
+	if index > len(r.packets) {
+		index = len(r.packets)
+	}
+
+	if len(r.keyframes) == 0 {
+		return nil
+	}
+
+	keyframeIndex := sort.Search(len(r.keyframes), func(i int) bool {
+		return r.keyframes[i].index > index
+	}) - 1
+
+	keyframe := r.keyframes[keyframeIndex]
+
+	err := keyframe.restore(r.draw)
+	if err != nil {
+		return err
+	}
+
+	r.index = keyframe.index
+	r.position = 0
+	if r.index > 0 {
+		r.position = r.packets[r.index-1].time
+	}
+
+	for r.index < index {
+		r.apply()
+	}
+
+	return nil
+}
+
+// seek restores the screen at the given time
+//
+func (r *replay) seek(position time.Duration) error {
+	if position < 0 {
+		position = 0
+	}
+
+	index := sort.Search(len(r.packets), func(i int) bool {
+		return r.packets[i].time > position
+	})
+
+	err := r.seekIndex(index)
+	if err != nil {
+		return err
+	}
+
+	r.position = position
+
+	return nil
+}
+
+// stepBack pauses and restores the screen before the last applied packet
+//
+func (r *replay) stepBack() error {
+	r.playing = false
+	return r.seekIndex(r.index - 1)
+}
+
+// advance applies the packets up to the position after the given elapsed time, if playing
+//
+func (r *replay) advance(elapsed time.Duration) {
+	if !r.playing {
+		return
+	}
+
+	position := r.position + time.Duration(float64(elapsed)*r.speed)
+
+	for r.index < len(r.packets) && r.packets[r.index].time <= position {
+		r.apply()
+	}
+
+	r.position = position
+
+	if r.index >= len(r.packets) {
+		r.playing = false
+		r.position = r.duration()
+	}
+}
+
+func (r *replay) togglePlaying() {
+	if !r.playing && r.index >= len(r.packets) {
+		// At the end, restart from the start
+		_ = r.seekIndex(0)
+	}
+
+	r.playing = !r.playing
+}
+
+func (r *replay) setSpeed(speed float64) {
+	switch {
+	case speed < replayMinSpeed:
+		speed = replayMinSpeed
+	case speed > replayMaxSpeed:
+		speed = replayMaxSpeed
+	}
+	r.speed = speed
+}
+
+// formatReplayTime formats the given time as minutes, seconds, and milliseconds
+//
+func formatReplayTime(t time.Duration) string {
+	t = t.Round(time.Millisecond)
+	return fmt.Sprintf(
+		"%02d:%02d.%03d",
+		int(t/time.Minute),
+		int(t%time.Minute/time.Second),
+		int(t%time.Second/time.Millisecond),
+	)
+}
+
+// status returns the status lines: the packet index, the time, the speed, and whether it is playing,
+// and the last applied packet
+//
+func (r *replay) status() []string {
+	state := "paused"
+	if r.playing {
+		state = "playing"
+	}
+
+	last := "-"
+	if r.index > 0 {
+		last = inspectPacket(r.packets[r.index-1].data).describe()
+	}
+
+	return []string{
+		fmt.Sprintf(
+			"%d/%d %s/%s %gx %s",
+			r.index,
+			len(r.packets),
+			formatReplayTime(r.position),
+			formatReplayTime(r.duration()),
+			r.speed,
+			state,
+		),
+		last,
+	}
+}
+
+// replayKeys are the replay controls, by key name
+//
+var replayKeys = map[string]func(r *replay) error{
+	"Space": func(r *replay) error {
+		r.togglePlaying()
+		return nil
+	},
+	"Right": func(r *replay) error {
+		r.step()
+		return nil
+	},
+	"Left": func(r *replay) error {
+		return r.stepBack()
+	},
+	"Down": func(r *replay) error {
+		r.stepFrame()
+		return nil
+	},
+	"]": func(r *replay) error {
+		r.setSpeed(r.speed * 2)
+		return nil
+	},
+	"[": func(r *replay) error {
+		r.setSpeed(r.speed / 2)
+		return nil
+	},
+	"PageDown": func(r *replay) error {
+		return r.seek(r.position + replayKeyframeInterval)
+	},
+	"PageUp": func(r *replay) error {
+		return r.seek(r.position - replayKeyframeInterval)
+	},
+	"Home": func(r *replay) error {
+		return r.seekIndex(0)
+	},
+	"End": func(r *replay) error {
+		return r.seekIndex(len(r.packets))
+	},
+}
+
+// handleKey handles the replay controls.
+// It returns false for other keys, e.g. for quitting and toggling fullscreen
+//
+func (r *replay) handleKey(name string, pressed bool) bool {
+	action := replayKeys[name]
+
+	if action == nil && len(name) == 1 && name[0] >= '0' && name[0] <= '9' {
+		// Seek to 0% to 90%
+		action = func(r *replay) error {
+			return r.seek(r.duration() * time.Duration(name[0]-'0') / 10)
+		}
+	}
+
+	if action == nil {
+		return false
+	}
+
+	if !pressed {
+		return true
+	}
+
+	err := action(r)
+	if err != nil {
+		log.Printf("failed to seek: %s", err)
+	}
+
+	return true
+}
+
+// runReplay runs the replay subcommand with the given arguments
+//
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: g0m8 replay [flags] CAPTURE\n\n")
+		fmt.Fprintf(flags.Output(), "Keys: Space play/pause, Right/Left step packet, Down step frame,\n")
+		fmt.Fprintf(flags.Output(), "[/] speed, PageUp/PageDown seek, Home/End start/end, 0-9 seek to 0%%-90%%\n\n")
+		flags.PrintDefaults()
+	}
+
+	width := flags.Int("width", 640, "width of the window")
+	height := flags.Int("height", 480, "height of the window")
+	software := flags.Bool("software", true, "use software rendering")
+	speed := flags.Float64("speed", 1, "playback speed, from 0.25 to 16")
+	seek := flags.Duration("seek", 0, "time to start at")
+	paused := flags.Bool("paused", false, "start paused")
+
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, records, err := readCapture(file)
+	_ = file.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sdlRenderer := newSDLRenderer(
+		int32(*width),
+		int32(*height),
+		*software,
+		scalingInteger,
+		scaleFilterNearest,
+		nil,
+		effects{},
+		waveformPoints,
+		1,
+	)
+	defer sdlRenderer.quit()
+
+	var renderer renderer = sdlRenderer
+
+	player, err := newReplay(records, renderer.draw)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	player.setSpeed(*speed)
+
+	// Set the status first, as it changes the size of the screen, which clears it
+	status := player.status()
+	sdlRenderer.setOverlay(status)
+
+	err = player.seek(*seek)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	player.playing = !*paused
+
+	redraw := func() {
+		sdlRenderer.invalidate()
+		sdlRenderer.render()
+	}
+
+	input := newInput(
+		func(name string, pressed bool, repeat bool) bool {
+			return player.handleKey(name, pressed)
+		},
+		redraw,
+		nil,
+		nil,
+	)
+
+	last := time.Now()
+
+	for {
+		if !input.handle(renderer.toggleFullscreen, func(uint8) {}) {
+			log.Println("Quit")
+			return
+		}
+
+		now := time.Now()
+		player.advance(now.Sub(last))
+		last = now
+
+		newStatus := player.status()
+		if strings.Join(newStatus, "\n") != strings.Join(status, "\n") {
+			status = newStatus
+			sdlRenderer.setOverlay(status)
+		}
+
+		renderer.render()
+
+		time.Sleep(replayRenderInterval)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// replayRecords returns the synthetic traffic for the given number of frames as capture records.
+// The packets of a frame are 100µs apart, and frames are 20ms apart
+//
+func replayRecords(t *testing.T, frames int) []captureRecord {
+	var records []captureRecord
+	var now time.Duration
+
+	var decoder slipDecoder
+	err := decoder.decode(syntheticTraffic(frames), func(packet []byte) {
+		records = append(records, captureRecord{
+			kind: captureReceived,
+			time: now,
+			data: packet,
+		})
+
+		now += 100 * time.Microsecond
+		if packet[0] == drawOscilloscopeWaveformCommand {
+			now += 20 * time.Millisecond
+		}
+	})
+	require.NoError(t, err)
+
+	return records
+}
+
+func framebufferColors(f *framebuffer) []Color {
+	colors := make([]Color, 0, screenWidth*screenHeight)
+	for y := 0; y < screenHeight; y++ {
+		for x := 0; x < screenWidth; x++ {
+			colors = append(colors, f.at(x, y))
+		}
+	}
+	return colors
+}
+
+func TestReplay(t *testing.T) {
+
+	records := replayRecords(t, 1000)
+
+	// Records of other kinds are ignored
+	records = append(
+		[]captureRecord{
+			{kind: captureWritten, data: []byte{'C', keyEdit}},
+			{kind: captureError, data: []byte("SLIP protocol error")},
+		},
+		records...,
+	)
+
+	t.Run("keyframes", func(t *testing.T) {
+		r, err := newReplay(records, func(Command) {})
+		require.NoError(t, err)
+
+		require.Len(t, r.packets, len(records)-2)
+		require.Len(t, r.keyframes, 3)
+		require.Equal(t, 0, r.keyframes[0].index)
+
+		for i := 1; i < len(r.keyframes); i++ {
+			previous := r.packets[r.keyframes[i-1].index].time
+			require.GreaterOrEqual(t, int64(r.packets[r.keyframes[i].index].time-previous), int64(replayKeyframeInterval))
+		}
+	})
+
+	t.Run("seek matches playback", func(t *testing.T) {
+		f := newFramebuffer()
+		r, err := newReplay(records, f.draw)
+		require.NoError(t, err)
+
+		for _, index := range []int{
+			len(r.packets),
+			r.keyframes[2].index,
+			r.keyframes[1].index + 100,
+			r.keyframes[1].index - 1,
+			1,
+			0,
+		} {
+			expected := newFramebuffer()
+			for _, packet := range r.packets[:index] {
+				command, err := decodeCommand(packet.data)
+				require.NoError(t, err)
+				expected.draw(command)
+			}
+
+			require.NoError(t, r.seekIndex(index))
+			require.Equal(t, index, r.index)
+			require.Equal(t, framebufferColors(expected), framebufferColors(f), "index %d", index)
+		}
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		r, err := newReplay(records, func(Command) {})
+		require.NoError(t, err)
+
+		position := r.packets[500].time
+		require.NoError(t, r.seek(position))
+		require.Equal(t, 501, r.index)
+		require.Equal(t, position, r.position)
+
+		// The first packet is at the start
+		require.NoError(t, r.seek(-time.Second))
+		require.Equal(t, 1, r.index)
+		require.Equal(t, time.Duration(0), r.position)
+
+		require.NoError(t, r.seek(time.Hour))
+		require.Equal(t, len(r.packets), r.index)
+		require.Equal(t, time.Hour, r.position)
+	})
+
+	t.Run("step", func(t *testing.T) {
+		var drawn int
+		r, err := newReplay(records, func(Command) {
+			drawn++
+		})
+		require.NoError(t, err)
+
+		r.playing = true
+		r.step()
+		require.False(t, r.playing)
+		require.Equal(t, 1, r.index)
+		require.Equal(t, 1, drawn)
+
+		// The first frame is the full redraw and the first waveform,
+		// the second frame starts after the gap
+		r.stepFrame()
+		waveform := r.index
+		require.Equal(t, byte(drawOscilloscopeWaveformCommand), r.packets[waveform-1].data[0])
+
+		r.stepFrame()
+		require.Equal(t, waveform+7, r.index)
+		require.Equal(t, byte(drawOscilloscopeWaveformCommand), r.packets[r.index-1].data[0])
+
+		require.NoError(t, r.stepBack())
+		require.Equal(t, waveform+6, r.index)
+		require.Equal(t, r.packets[waveform+5].time, r.position)
+	})
+
+	t.Run("advance", func(t *testing.T) {
+		r, err := newReplay(records, func(Command) {})
+		require.NoError(t, err)
+
+		// Paused
+		r.advance(time.Second)
+		require.Equal(t, 0, r.index)
+
+		r.togglePlaying()
+		r.setSpeed(2)
+		r.advance(time.Second)
+		require.Equal(t, 2*time.Second, r.position)
+		require.LessOrEqual(t, int64(r.packets[r.index-1].time), int64(2*time.Second))
+		require.Greater(t, int64(r.packets[r.index].time), int64(2*time.Second))
+
+		// Playing stops at the end
+		r.advance(time.Hour)
+		require.False(t, r.playing)
+		require.Equal(t, len(r.packets), r.index)
+		require.Equal(t, r.duration(), r.position)
+
+		// Playing again restarts
+		r.togglePlaying()
+		require.True(t, r.playing)
+		require.Equal(t, 0, r.index)
+	})
+
+	t.Run("speed", func(t *testing.T) {
+		r, err := newReplay(records, func(Command) {})
+		require.NoError(t, err)
+
+		require.True(t, r.handleKey("]", true))
+		require.Equal(t, 2.0, r.speed)
+
+		require.True(t, r.handleKey("]", false))
+		require.Equal(t, 2.0, r.speed)
+
+		// Other keys, e.g. for quitting and toggling fullscreen, are not handled
+		require.False(t, r.handleKey("Q", true))
+		require.False(t, r.handleKey("Return", false))
+
+		r.setSpeed(100)
+		require.Equal(t, 16.0, r.speed)
+
+		r.setSpeed(0)
+		require.Equal(t, 0.25, r.speed)
+	})
+
+	t.Run("status", func(t *testing.T) {
+		r, err := newReplay(
+			[]captureRecord{
+				{
+					kind: captureReceived,
+					time: 61*time.Second + 5*time.Millisecond,
+					data: []byte{drawRectangleCommand, 1, 0, 2, 0, 3, 0, 4, 0, 0xff, 0, 0x10},
+				},
+				{
+					kind: captureReceived,
+					time: 62 * time.Second,
+					data: []byte{0x42},
+				},
+			},
+			func(Command) {},
+		)
+		require.NoError(t, err)
+
+		require.Equal(t,
+			[]string{
+				"0/2 00:00.000/01:02.000 1x paused",
+				"-",
+			},
+			r.status(),
+		)
+
+		r.step()
+		r.setSpeed(0.5)
+		r.playing = true
+
+		require.Equal(t,
+			[]string{
+				"1/2 01:01.005/01:02.000 0.5x playing",
+				"rectangle x=1 y=2 width=3 height=4 color=#ff0010",
+			},
+			r.status(),
+		)
+
+		r.step()
+		require.Equal(t, "unknown !!! UNKNOWN COMMAND BYTE !!! command=0x42", r.status()[1])
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// scalingMode is how the M8 screen is scaled to the window
+//
+type scalingMode string
+
+const (
+	// scalingInteger scales by the largest integer factor which fits,
+	// so all pixels have the same size, and letterboxes the rest
+	scalingInteger scalingMode = "integer"
+	// scalingFit scales as large as possible, keeping the aspect ratio,
+	// and letterboxes the rest
+	scalingFit scalingMode = "fit"
+	// scalingStretch scales to the whole window, ignoring the aspect ratio
+	scalingStretch scalingMode = "stretch"
+)
+
+func parseScalingMode(s string) (scalingMode, error) {
+	mode := scalingMode(strings.ToLower(s))
+	switch mode {
+	case scalingInteger, scalingFit, scalingStretch:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid scaling mode %s, expected integer, fit, or stretch", s)
+}
+
+// scaleFilter is how pixels are interpolated when scaling
+//
+type scaleFilter string
+
+const (
+	scaleFilterNearest scaleFilter = "nearest"
+	scaleFilterLinear  scaleFilter = "linear"
+)
+
+func parseScaleFilter(s string) (scaleFilter, error) {
+	filter := scaleFilter(strings.ToLower(s))
+	switch filter {
+	case scaleFilterNearest, scaleFilterLinear:
+		return filter, nil
+	}
+	return "", fmt.Errorf("invalid filter %s, expected nearest or linear", s)
+}
+
+// scaleRect returns the rectangle of the window of the given size
+// which the content of the given size is scaled to, centered
+//
+func scaleRect(mode scalingMode, windowWidth, windowHeight, contentWidth, contentHeight int) image.Rectangle {
+	if mode == scalingStretch {
+		return image.Rect(0, 0, windowWidth, windowHeight)
+	}
+
+	var width, height int
+
+	if mode == scalingInteger {
+		scale := windowWidth / contentWidth
+		if verticalScale := windowHeight / contentHeight; verticalScale < scale {
+			scale = verticalScale
+		}
+
+		width = contentWidth * scale
+		height = contentHeight * scale
+	}
+
+	// Fit, or the window is smaller than the content
+	if width == 0 || height == 0 {
+		if windowWidth*contentHeight < windowHeight*contentWidth {
+			width = windowWidth
+			height = windowWidth * contentHeight / contentWidth
+		} else {
+			width = windowHeight * contentWidth / contentHeight
+			height = windowHeight
+		}
+	}
+
+	x := (windowWidth - width) / 2
+	y := (windowHeight - height) / 2
+
+	return image.Rect(x, y, x+width, y+height)
+}
+
+// parseColor parses a color given as #rrggbb
+//
+func parseColor(s string) (Color, error) {
+	var c Color
+	_, err := fmt.Sscanf(s, "#%02x%02x%02x", &c.r, &c.g, &c.b)
+	if err != nil || len(s) != 7 {
+		return c, fmt.Errorf("invalid color %s, expected #rrggbb", s)
+	}
+	return c, nil
+}
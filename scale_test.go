@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScaleRect(t *testing.T) {
+
+	t.Run("integer", func(t *testing.T) {
+		require.Equal(t,
+			image.Rect(0, 0, 640, 480),
+			scaleRect(scalingInteger, 640, 480, screenWidth, screenHeight),
+		)
+
+		// 1920x1080 fits 4x vertically, letterboxed horizontally and vertically
+		require.Equal(t,
+			image.Rect(320, 60, 1600, 1020),
+			scaleRect(scalingInteger, 1920, 1080, screenWidth, screenHeight),
+		)
+
+		require.Equal(t,
+			image.Rect(40, 30, 680, 510),
+			scaleRect(scalingInteger, 720, 540, screenWidth, screenHeight),
+		)
+	})
+
+	t.Run("integer, window smaller than screen", func(t *testing.T) {
+		require.Equal(t,
+			image.Rect(0, 5, 160, 125),
+			scaleRect(scalingInteger, 160, 130, screenWidth, screenHeight),
+		)
+	})
+
+	t.Run("fit", func(t *testing.T) {
+		require.Equal(t,
+			image.Rect(240, 0, 1680, 1080),
+			scaleRect(scalingFit, 1920, 1080, screenWidth, screenHeight),
+		)
+
+		require.Equal(t,
+			image.Rect(0, 60, 720, 600),
+			scaleRect(scalingFit, 720, 660, screenWidth, screenHeight),
+		)
+	})
+
+	t.Run("stretch", func(t *testing.T) {
+		require.Equal(t,
+			image.Rect(0, 0, 1920, 1080),
+			scaleRect(scalingStretch, 1920, 1080, screenWidth, screenHeight),
+		)
+	})
+}
+
+func TestParseScaling(t *testing.T) {
+
+	mode, err := parseScalingMode("Integer")
+	require.NoError(t, err)
+	require.Equal(t, scalingInteger, mode)
+
+	_, err = parseScalingMode("zoom")
+	require.Error(t, err)
+
+	filter, err := parseScaleFilter("linear")
+	require.NoError(t, err)
+	require.Equal(t, scaleFilterLinear, filter)
+
+	_, err = parseScaleFilter("cubic")
+	require.Error(t, err)
+
+	color, err := parseColor("#1a2B3c")
+	require.NoError(t, err)
+	require.Equal(t, Color{r: 0x1a, g: 0x2b, b: 0x3c}, color)
+
+	for _, s := range []string{"1a2b3c", "#1a2b", "#1a2b3c4d", "#gg0000"} {
+		_, err = parseColor(s)
+		require.Error(t, err, s)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// scopeGridColor is the color of the center line and the divisions of the scope window
+var scopeGridColor = Color{r: 0x30, g: 0x30, b: 0x30}
+
+// scopeDivisions is the number of horizontal divisions of the scope window
+const scopeDivisions = 8
+
+// scopeWindow is a separate, resizable window which shows the oscilloscope waveform enlarged.
+// It must be created after SDL is initialized
+//
+type scopeWindow struct {
+	window   *sdl.Window
+	renderer *sdl.Renderer
+	id       uint32
+	hidden   bool
+	// changed is true if the scope must be rendered
+	changed bool
+	style   waveformStyle
+	trail   *waveformTrail
+	color   Color
+	spans   []waveformSpan
+	rects   []sdl.Rect
+}
+
+func newScopeWindow(
+	width, height int32,
+	software bool,
+	style waveformStyle,
+	trail int,
+) *scopeWindow {
+
+	s := &scopeWindow{
+		style:   style,
+		trail:   newWaveformTrail(trail),
+		changed: true,
+	}
+
+	var err error
+	s.window, err = sdl.CreateWindow(
+		"M8 Scope",
+		sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED,
+		width, height,
+		sdl.WINDOW_SHOWN|sdl.WINDOW_RESIZABLE,
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	s.id, err = s.window.GetID()
+	if err != nil {
+		panic(err)
+	}
+
+	var flags uint32 = sdl.RENDERER_ACCELERATED
+	if software {
+		flags = sdl.RENDERER_SOFTWARE
+	}
+	s.renderer, err = sdl.CreateRenderer(s.window, -1, flags)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+func (s *scopeWindow) quit() {
+	_ = s.renderer.Destroy()
+	_ = s.window.Destroy()
+}
+
+// close hides the window. It is shown again when the scope is toggled
+//
+func (s *scopeWindow) close() {
+	s.window.Hide()
+	s.hidden = true
+}
+
+func (s *scopeWindow) toggle() {
+	if s.hidden {
+		s.window.Show()
+		s.changed = true
+	} else {
+		s.window.Hide()
+	}
+	s.hidden = !s.hidden
+}
+
+func (s *scopeWindow) invalidate() {
+	s.changed = true
+}
+
+func (s *scopeWindow) draw(command DrawOscilloscopeWaveformCommand) {
+	s.changed = true
+
+	if len(command.waveform) == 0 {
+		s.trail.clear()
+		return
+	}
+
+	s.color = command.color
+	s.trail.push(command.waveform)
+}
+
+func (s *scopeWindow) render() {
+	if s.hidden || !s.changed {
+		return
+	}
+	s.changed = false
+
+	renderer := s.renderer
+
+	_ = renderer.SetDrawColor(0, 0, 0, math.MaxUint8)
+	_ = renderer.Clear()
+
+	width, height, err := renderer.GetOutputSize()
+	if err != nil {
+		return
+	}
+
+	_ = renderer.SetDrawColor(
+		scopeGridColor.r,
+		scopeGridColor.g,
+		scopeGridColor.b,
+		math.MaxUint8,
+	)
+
+	for i := int32(1); i < scopeDivisions; i++ {
+		x := width * i / scopeDivisions
+		_ = renderer.DrawLine(x, 0, x, height)
+	}
+	_ = renderer.DrawLine(0, height/2, width, height/2)
+
+	s.trail.each(func(waveform []byte, brightness float64) {
+		color := fadeColor(Color{}, s.color, brightness)
+
+		_ = renderer.SetDrawColor(
+			color.r,
+			color.g,
+			color.b,
+			math.MaxUint8,
+		)
+
+		s.spans = appendWaveformSpans(s.spans[:0], s.style, waveform)
+		s.rects = appendWaveformRects(s.rects[:0], s.spans, width, height)
+
+		_ = renderer.FillRects(s.rects)
+	})
+
+	renderer.Present()
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Scripts are Lua files in the scripts directory, loaded in alphabetical order.
+// They run in a sandbox, without access to files, the OS, or other modules,
+// and can use the following functions:
+//
+// bind(key, function(pressed) ... end)   Bind a function to a key of the keyboard, e.g. "F1".
+//                                        It is called when the key is pressed (true) and released (false)
+// press(key, ...)                        Press M8 keys, e.g. press("SHIFT", "UP")
+// release(key, ...)                      Release M8 keys, all if none are given
+// tap(key, ...)                          Press M8 keys, and release them after the tap duration
+// sleep(milliseconds)                    Wait for the given duration
+// note_on(note, velocity)                Send a keyjazz note on, 1 to 127, with the velocity 0 to 127 (default 100)
+// note_off()                             Send a keyjazz note off
+// theme_color(index, r, g, b)            Set a theme color
+// screen_contains(text)                  Return true if the screen contains the given text
+// screen_line(row)                       Return the text of the given row, starting at 1
+// screen_text()                          Return the text of the whole screen
+// log(...)                               Log the given values
+//
+// Functions run as coroutines on the main loop, so sleeping does not block it
+
+const scriptTapDuration = 50 * time.Millisecond
+
+// scriptTimeout is the maximum time a script may run without sleeping
+const scriptTimeout = time.Second
+
+const scriptPrelude = `
+function tap(...)
+	press(...)
+	sleep(tap_duration)
+	release(...)
+end
+`
+
+type scriptThread struct {
+	thread   *lua.LState
+	cancel   context.CancelFunc
+	function *lua.LFunction
+	args     []lua.LValue
+	started  bool
+	wake     time.Time
+}
+
+func (t *scriptThread) close() {
+	// Threads only have a cancel function if the state had a context when they were created
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+type scripts struct {
+	state          *lua.LState
+	bindings       map[string]*lua.LFunction
+	threads        []*scriptThread
+	current        *scriptThread
+	keys           uint8
+	sendController func(uint8)
+	sendNoteOn     func(note byte, velocity byte)
+	sendNoteOff    func()
+	sendThemeColor func(index byte, color Color)
+	screen         *textScreen
+	now            time.Time
+}
+
+func newScripts(
+	sendController func(uint8),
+	sendNoteOn func(note byte, velocity byte),
+	sendNoteOff func(),
+	sendThemeColor func(index byte, color Color),
+	screen *textScreen,
+) *scripts {
+	s := &scripts{
+		state: lua.NewState(lua.Options{
+			SkipOpenLibs: true,
+		}),
+		bindings:       map[string]*lua.LFunction{},
+		sendController: sendController,
+		sendNoteOn:     sendNoteOn,
+		sendNoteOff:    sendNoteOff,
+		sendThemeColor: sendThemeColor,
+		screen:         screen,
+	}
+
+	s.openLibraries()
+
+	err := s.state.DoString(scriptPrelude)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+// defaultScriptsDirectory returns the scripts directory in the user's configuration directory
+//
+func defaultScriptsDirectory() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "g0m8", "scripts")
+}
+
+// openLibraries opens the safe subset of the standard libraries,
+// and registers the M8 functions
+//
+func (s *scripts) openLibraries() {
+	L := s.state
+
+	for _, library := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(library.open))
+		L.Push(lua.LString(library.name))
+		L.Call(1, 0)
+	}
+
+	// Remove functions which load code from files or strings
+
+	for _, name := range []string{
+		"dofile",
+		"loadfile",
+		"load",
+		"loadstring",
+		"module",
+		"require",
+	} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	L.SetGlobal("tap_duration", lua.LNumber(scriptTapDuration/time.Millisecond))
+
+	for name, function := range map[string]lua.LGFunction{
+		"bind":            s.bind,
+		"press":           s.press,
+		"release":         s.release,
+		"sleep":           s.sleep,
+		"note_on":         s.noteOn,
+		"note_off":        s.noteOff,
+		"theme_color":     s.themeColor,
+		"screen_contains": s.screenContains,
+		"screen_line":     s.screenLine,
+		"screen_text":     s.screenText,
+		"log":             s.log,
+	} {
+		L.SetGlobal(name, L.NewFunction(function))
+	}
+}
+
+// loadDirectory loads all scripts in the given directory
+//
+func (s *scripts) loadDirectory(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".lua" {
+			continue
+		}
+		names = append(names, file.Name())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+
+		source, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		err = s.load(path, string(source))
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Loaded script %s", path)
+	}
+
+	return nil
+}
+
+// load runs the given script, e.g. to bind functions to keys
+//
+func (s *scripts) load(name, source string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+
+	s.state.SetContext(ctx)
+	defer s.state.RemoveContext()
+
+	function, err := s.state.Load(strings.NewReader(source), name)
+	if err != nil {
+		return err
+	}
+
+	s.state.Push(function)
+	return s.state.PCall(0, lua.MultRet, nil)
+}
+
+// handleKey runs the function bound to the given key, if any
+//
+func (s *scripts) handleKey(name string, pressed bool, repeat bool) bool {
+	function, ok := s.bindings[strings.ToUpper(name)]
+	if !ok {
+		return false
+	}
+
+	if !repeat {
+		s.start(function, lua.LBool(pressed))
+	}
+
+	return true
+}
+
+// start starts running the given function as a new coroutine
+//
+func (s *scripts) start(function *lua.LFunction, args ...lua.LValue) {
+	thread, cancel := s.state.NewThread()
+	s.threads = append(s.threads, &scriptThread{
+		thread:   thread,
+		cancel:   cancel,
+		function: function,
+		args:     args,
+	})
+}
+
+// run resumes all coroutines which are due
+//
+func (s *scripts) run(now time.Time) {
+	s.now = now
+
+	threads := s.threads
+	s.threads = nil
+
+	for _, thread := range threads {
+		if now.Before(thread.wake) || !s.resume(thread) {
+			s.threads = append(s.threads, thread)
+			continue
+		}
+
+		thread.close()
+	}
+}
+
+// resume resumes the given coroutine, and returns true if it finished
+//
+func (s *scripts) resume(thread *scriptThread) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+
+	thread.thread.SetContext(ctx)
+
+	s.current = thread
+	defer func() {
+		s.current = nil
+	}()
+
+	var state lua.ResumeState
+	var err error
+
+	if thread.started {
+		state, err, _ = s.state.Resume(thread.thread, nil)
+	} else {
+		thread.started = true
+		state, err, _ = s.state.Resume(thread.thread, thread.function, thread.args...)
+	}
+
+	if err != nil {
+		log.Printf("script error: %s", err)
+		return true
+	}
+
+	return state != lua.ResumeYield
+}
+
+func (s *scripts) checkKeys(L *lua.LState) uint8 {
+	names := make([]string, L.GetTop())
+	for i := range names {
+		names[i] = L.CheckString(i + 1)
+	}
+
+	keys, err := parseKeys(names)
+	if err != nil {
+		L.RaiseError("%s", err)
+	}
+
+	return keys
+}
+
+func (s *scripts) bind(L *lua.LState) int {
+	name := L.CheckString(1)
+	function := L.CheckFunction(2)
+
+	s.bindings[strings.ToUpper(name)] = function
+
+	return 0
+}
+
+func (s *scripts) press(L *lua.LState) int {
+	s.keys |= s.checkKeys(L)
+	s.sendController(s.keys)
+	return 0
+}
+
+func (s *scripts) release(L *lua.LState) int {
+	keys := uint8(255)
+	if L.GetTop() > 0 {
+		keys = s.checkKeys(L)
+	}
+
+	s.keys &= 255 ^ keys
+	s.sendController(s.keys)
+	return 0
+}
+
+func (s *scripts) sleep(L *lua.LState) int {
+	milliseconds := L.CheckNumber(1)
+
+	thread := s.current
+	if thread == nil || thread.thread != L {
+		L.RaiseError("sleep is only allowed in bound functions")
+		return 0
+	}
+
+	thread.wake = s.now.Add(time.Duration(float64(milliseconds) * float64(time.Millisecond)))
+	return L.Yield()
+}
+
+func checkByte(L *lua.LState, n int) byte {
+	value := L.CheckInt(n)
+	if value < 0 || value > 255 {
+		L.ArgError(n, "must be between 0 and 255")
+	}
+	return byte(value)
+}
+
+func (s *scripts) noteOn(L *lua.LState) int {
+	note := L.CheckInt(1)
+	velocity := 100
+	if L.GetTop() >= 2 {
+		velocity = L.CheckInt(2)
+	}
+
+	err := checkNote(note, velocity)
+	if err != nil {
+		L.RaiseError("%s", err)
+	}
+
+	s.sendNoteOn(byte(note), byte(velocity))
+	return 0
+}
+
+func (s *scripts) noteOff(L *lua.LState) int {
+	s.sendNoteOff()
+	return 0
+}
+
+func (s *scripts) themeColor(L *lua.LState) int {
+	index := checkByte(L, 1)
+	color := Color{
+		r: checkByte(L, 2),
+		g: checkByte(L, 3),
+		b: checkByte(L, 4),
+	}
+
+	s.sendThemeColor(index, color)
+	return 0
+}
+
+func (s *scripts) screenContains(L *lua.LState) int {
+	text := L.CheckString(1)
+	L.Push(lua.LBool(s.screen.contains(text)))
+	return 1
+}
+
+func (s *scripts) screenLine(L *lua.LState) int {
+	row := L.CheckInt(1)
+	if row < 1 || row > textRows {
+		L.ArgError(1, fmt.Sprintf("must be between 1 and %d", textRows))
+	}
+
+	L.Push(lua.LString(s.screen.line(row - 1)))
+	return 1
+}
+
+func (s *scripts) screenText(L *lua.LState) int {
+	L.Push(lua.LString(s.screen.String()))
+	return 1
+}
+
+func (s *scripts) log(L *lua.LState) int {
+	values := make([]string, L.GetTop())
+	for i := range values {
+		values[i] = L.ToStringMeta(L.Get(i + 1)).String()
+	}
+
+	log.Printf("script: %s", strings.Join(values, " "))
+	return 0
+}
+
+// reset forgets the keys pressed by scripts, e.g. after reconnecting
+//
+func (s *scripts) reset() {
+	s.keys = 0
+}
+
+func (s *scripts) close() {
+	for _, thread := range s.threads {
+		thread.close()
+	}
+	s.state.Close()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testScripts struct {
+	*scripts
+	controllers []uint8
+	notes       [][2]byte
+	themeColors map[byte]Color
+}
+
+func newTestScripts(t *testing.T, source string) *testScripts {
+	test := &testScripts{
+		themeColors: map[byte]Color{},
+	}
+
+	test.scripts = newScripts(
+		func(controller uint8) {
+			test.controllers = append(test.controllers, controller)
+		},
+		func(note byte, velocity byte) {
+			test.notes = append(test.notes, [2]byte{note, velocity})
+		},
+		func() {
+			test.notes = append(test.notes, [2]byte{})
+		},
+		func(index byte, color Color) {
+			test.themeColors[index] = color
+		},
+		newTextScreen(),
+	)
+	t.Cleanup(test.close)
+
+	require.NoError(t, test.load("test.lua", source))
+
+	return test
+}
+
+func TestScripts(t *testing.T) {
+
+	t.Run("macro with timing", func(t *testing.T) {
+		test := newTestScripts(t, `
+			bind("F1", function(pressed)
+				if not pressed then
+					return
+				end
+				for i = 1, 3 do
+					tap("SHIFT", "UP")
+				end
+				press("EDIT")
+				sleep(100)
+				release()
+			end)
+		`)
+
+		require.True(t, test.handleKey("f1", true, false))
+		require.True(t, test.handleKey("F1", true, true))
+		require.False(t, test.handleKey("F2", true, false))
+
+		start := time.Now()
+
+		test.run(start)
+		require.Equal(t, []uint8{keySelect | keyUp}, test.controllers)
+
+		// Not due yet
+		test.run(start.Add(scriptTapDuration / 2))
+		require.Len(t, test.controllers, 1)
+
+		now := start
+		for i := 0; i < 3; i++ {
+			now = now.Add(scriptTapDuration)
+			test.run(now)
+		}
+
+		require.Equal(t,
+			[]uint8{
+				keySelect | keyUp, 0,
+				keySelect | keyUp, 0,
+				keySelect | keyUp, 0,
+				keyEdit,
+			},
+			test.controllers,
+		)
+
+		test.run(now.Add(100 * time.Millisecond))
+		require.Equal(t, uint8(0), test.controllers[len(test.controllers)-1])
+		require.Empty(t, test.threads)
+
+		// Released
+		require.True(t, test.handleKey("F1", false, false))
+		test.run(now.Add(200 * time.Millisecond))
+		require.Len(t, test.controllers, 8)
+	})
+
+	t.Run("remap depending on screen", func(t *testing.T) {
+		test := newTestScripts(t, `
+			bind("A", function(pressed)
+				local key = "OPT"
+				if screen_contains("PHRASE") then
+					key = "EDIT"
+				end
+				if pressed then
+					press(key)
+				else
+					release(key)
+				end
+			end)
+		`)
+
+		now := time.Now()
+
+		test.handleKey("A", true, false)
+		test.handleKey("A", false, false)
+		test.run(now)
+
+		drawText(test.screen, 0, 0, "PHRASE 00")
+
+		test.handleKey("A", true, false)
+		test.handleKey("A", false, false)
+		test.run(now)
+
+		require.Equal(t, []uint8{keyOpt, 0, keyEdit, 0}, test.controllers)
+	})
+
+	t.Run("notes and theme colors", func(t *testing.T) {
+		test := newTestScripts(t, `
+			bind("B", function()
+				note_on(60, 80)
+				note_off()
+				theme_color(2, 255, 0, 128)
+			end)
+		`)
+
+		test.handleKey("B", true, false)
+		test.run(time.Now())
+
+		require.Equal(t, [][2]byte{{60, 80}, {0, 0}}, test.notes)
+		require.Equal(t, map[byte]Color{2: {r: 255, g: 0, b: 128}}, test.themeColors)
+	})
+
+	t.Run("sandbox", func(t *testing.T) {
+		test := newTestScripts(t, `
+			assert(dofile == nil)
+			assert(loadfile == nil)
+			assert(load == nil)
+			assert(require == nil)
+			assert(io == nil)
+			assert(os == nil)
+		`)
+		require.NotNil(t, test)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		test := newTestScripts(t, "")
+		require.Error(t, test.load("test.lua", `press("FOO")`))
+	})
+
+	t.Run("invalid note", func(t *testing.T) {
+		test := newTestScripts(t, "")
+		// Note 0 stops the note, and has no velocity
+		require.Error(t, test.load("test.lua", `note_on(0)`))
+		require.Error(t, test.load("test.lua", `note_on(128)`))
+		require.Error(t, test.load("test.lua", `note_on(60, 128)`))
+	})
+
+	t.Run("endless loop", func(t *testing.T) {
+		test := newTestScripts(t, "")
+		require.Error(t, test.load("test.lua", `while true do end`))
+	})
+}
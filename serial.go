@@ -5,37 +5,47 @@ package main
 import "C"
 
 import (
-	"log"
+	"fmt"
 	"os"
 
 	"golang.org/x/sys/unix"
 )
 
 func openSerialPort(device string) *os.File {
+	f, err := tryOpenSerialPort(device)
+	if err != nil {
+		fatal(err)
+	}
+	return f
+}
+
+// tryOpenSerialPort opens the given device as a raw serial port
+//
+func tryOpenSerialPort(device string) (*os.File, error) {
 	f, err := os.OpenFile(device, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0666)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	fd := C.int(f.Fd())
 	if C.isatty(fd) != 1 {
 		_ = f.Close()
-		log.Fatalf("device is not a TTY: %s", device)
+		return nil, fmt.Errorf("device is not a TTY: %s", device)
 	}
 
 	var settings C.struct_termios
 	_, err = C.tcgetattr(fd, &settings)
 	if err != nil {
 		_ = f.Close()
-		log.Fatal(err)
+		return nil, err
 	}
 
 	C.cfmakeraw(&settings)
 	_, err = C.tcsetattr(fd, C.TCSANOW, &settings)
 	if err != nil {
 		_ = f.Close()
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return f
+	return f, nil
 }
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Shortcuts trigger host-side actions, e.g. taking a screenshot,
+// when a chord of keys is tapped, double-tapped, held, or auto-repeated.
+//
+// A shortcut is given as <chord>[:<gesture>]=<action>, e.g. SHIFT+OPT+S=screenshot,
+// where the chord consists of M8 keys (e.g. SHIFT, OPT, UP),
+// and optionally one key of the keyboard (e.g. S, F5),
+// and the gesture is one of tap (the default), double, hold, or repeat.
+//
+// The shortcut layer is in front of sendController, so chords do not leak to the M8:
+// Controller states which might be the start of a chord are held back for the chord window.
+// They are dropped when the chord is completed, and sent in order when a key is pressed or released
+// which cannot be part of the chord, or when the chord window elapsed.
+// Keys which were sent after the chord window do not complete a chord until all keys are released,
+// so a chord is never sent partially.
+// Keys of a completed chord are not sent until they are released.
+
+type shortcutGesture int
+
+const (
+	shortcutTap shortcutGesture = iota
+	shortcutDoubleTap
+	shortcutHold
+	shortcutRepeat
+)
+
+var shortcutGestureNames = map[string]shortcutGesture{
+	"tap":    shortcutTap,
+	"double": shortcutDoubleTap,
+	"hold":   shortcutHold,
+	"repeat": shortcutRepeat,
+}
+
+// shortcutChord is a combination of M8 keys,
+// and optionally a key of the keyboard
+//
+type shortcutChord struct {
+	keys uint8
+	key  string
+}
+
+type shortcut struct {
+	chord   shortcutChord
+	gesture shortcutGesture
+	action  string
+}
+
+// parseShortcut parses a shortcut, e.g. SHIFT+OPT+S:double=screenshot
+//
+func parseShortcut(s string) (shortcut, error) {
+	var result shortcut
+
+	index := strings.Index(s, "=")
+	if index < 0 {
+		return result, fmt.Errorf("invalid shortcut, missing action: %s", s)
+	}
+
+	chord := s[:index]
+	result.action = strings.TrimSpace(s[index+1:])
+	if result.action == "" {
+		return result, fmt.Errorf("invalid shortcut, missing action: %s", s)
+	}
+
+	if index := strings.LastIndex(chord, ":"); index >= 0 {
+		gesture, ok := shortcutGestureNames[strings.ToLower(chord[index+1:])]
+		if !ok {
+			return result, fmt.Errorf("invalid shortcut, unknown gesture: %s", s)
+		}
+		result.gesture = gesture
+		chord = chord[:index]
+	}
+
+	for _, name := range strings.Split(chord, "+") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			return result, fmt.Errorf("invalid shortcut, empty key: %s", s)
+		}
+
+		if key, ok := keyNames[name]; ok {
+			result.chord.keys |= key
+			continue
+		}
+
+		if result.chord.key != "" {
+			return result, fmt.Errorf("invalid shortcut, more than one keyboard key: %s", s)
+		}
+		result.chord.key = name
+	}
+
+	if result.chord.keys == 0 && result.chord.key == "" {
+		return result, fmt.Errorf("invalid shortcut, missing keys: %s", s)
+	}
+
+	return result, nil
+}
+
+// shortcutFlags are the values of a repeated shortcut flag
+//
+type shortcutFlags []shortcut
+
+func (f *shortcutFlags) String() string {
+	return ""
+}
+
+func (f *shortcutFlags) Set(value string) error {
+	shortcut, err := parseShortcut(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, shortcut)
+	return nil
+}
+
+type shortcuts struct {
+	shortcuts         []shortcut
+	chordWindow       time.Duration
+	holdDuration      time.Duration
+	doubleTapInterval time.Duration
+	repeatInterval    time.Duration
+	sendController    func(uint8)
+	runAction         func(action string)
+
+	// input is the controller state received from the input
+	input uint8
+	// sent is the controller state last sent
+	sent uint8
+	// pending are the controller states which are held back, as they might be the start of a chord
+	pending      []uint8
+	pendingSince time.Time
+	// forwarded is true if held back keys were sent after the chord window,
+	// so no chord is completed until all keys are released
+	forwarded bool
+	// suppressed are the keys of the active chord, which are not sent until released
+	suppressed uint8
+
+	// active is the chord which is currently held
+	active      *shortcutChord
+	activeSince time.Time
+	held        bool
+	nextRepeat  time.Time
+	// lastTap is the chord tapped once, which might become a double tap
+	lastTap     *shortcutChord
+	lastTapTime time.Time
+}
+
+func newShortcuts(
+	definitions []shortcut,
+	chordWindow time.Duration,
+	holdDuration time.Duration,
+	doubleTapInterval time.Duration,
+	repeatInterval time.Duration,
+	sendController func(uint8),
+	runAction func(action string),
+) *shortcuts {
+	return &shortcuts{
+		shortcuts:         definitions,
+		chordWindow:       chordWindow,
+		holdDuration:      holdDuration,
+		doubleTapInterval: doubleTapInterval,
+		repeatInterval:    repeatInterval,
+		sendController:    sendController,
+		runAction:         runAction,
+	}
+}
+
+// find returns the shortcut for the given chord and gesture, if any
+//
+func (s *shortcuts) find(chord shortcutChord, gesture shortcutGesture) *shortcut {
+	for i, shortcut := range s.shortcuts {
+		if shortcut.chord == chord && shortcut.gesture == gesture {
+			return &s.shortcuts[i]
+		}
+	}
+	return nil
+}
+
+func (s *shortcuts) has(chord shortcutChord) bool {
+	for _, shortcut := range s.shortcuts {
+		if shortcut.chord == chord {
+			return true
+		}
+	}
+	return false
+}
+
+// isChordStart returns true if the given controller state
+// might be the start of a chord
+//
+func (s *shortcuts) isChordStart(controller uint8) bool {
+	if controller == 0 {
+		return false
+	}
+
+	for _, shortcut := range s.shortcuts {
+		keys := shortcut.chord.keys
+		if controller&keys != controller {
+			continue
+		}
+
+		// A strict subset of the M8 keys, or all M8 keys if a keyboard key completes the chord
+		if controller != keys || shortcut.chord.key != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *shortcuts) fire(chord shortcutChord, gesture shortcutGesture) bool {
+	shortcut := s.find(chord, gesture)
+	if shortcut == nil {
+		return false
+	}
+	s.runAction(shortcut.action)
+	return true
+}
+
+func (s *shortcuts) send(controller uint8) {
+	controller &= 255 ^ s.suppressed
+	if controller == s.sent {
+		return
+	}
+	s.sent = controller
+	s.sendController(controller)
+}
+
+// filter handles the controller state from the input,
+// and sends it unless it is part of a chord
+//
+func (s *shortcuts) filter(controller uint8, now time.Time) {
+	// Ignore repeated states
+	if controller == s.input {
+		return
+	}
+	s.input = controller
+
+	// Keys of the active chord are sent again once they are released
+	s.suppressed &= controller
+
+	if s.active != nil && s.active.key == "" && controller&s.active.keys != s.active.keys {
+		s.release(now)
+	}
+
+	if s.forwarded {
+		s.forwarded = controller != 0
+		s.send(controller)
+		return
+	}
+
+	chord := shortcutChord{keys: controller}
+	if s.active == nil && s.has(chord) {
+		s.pending = nil
+		s.press(chord, now)
+		s.send(controller)
+		return
+	}
+
+	if s.isChordStart(controller) {
+		s.hold(controller, now)
+		return
+	}
+
+	s.sendPending()
+	s.send(controller)
+}
+
+// hold holds back the given controller state, without the keys of the active chord
+//
+func (s *shortcuts) hold(controller uint8, now time.Time) {
+	controller &= 255 ^ s.suppressed
+	if controller == 0 {
+		return
+	}
+
+	count := len(s.pending)
+	if count == 0 {
+		s.pendingSince = now
+	}
+
+	if count > 0 && s.pending[count-1] == controller {
+		return
+	}
+
+	s.pending = append(s.pending, controller)
+}
+
+// sendPending sends the held back controller states, in order
+//
+func (s *shortcuts) sendPending() {
+	for _, controller := range s.pending {
+		s.send(controller)
+	}
+	s.pending = nil
+}
+
+// dropPending drops the held back controller states,
+// e.g. when all keys are released because the window lost focus
+//
+func (s *shortcuts) dropPending() {
+	s.pending = nil
+}
+
+// handleKey handles chords completed by keys of the keyboard
+//
+func (s *shortcuts) handleKey(name string, pressed bool, repeat bool) bool {
+	chord := shortcutChord{
+		keys: s.input,
+		key:  strings.ToUpper(name),
+	}
+
+	if s.active != nil && s.active.key == chord.key {
+		if !pressed {
+			s.release(time.Now())
+		}
+		return true
+	}
+
+	if s.forwarded || !s.has(chord) {
+		return false
+	}
+
+	if pressed && !repeat {
+		s.pending = nil
+		s.press(chord, time.Now())
+		s.send(s.input)
+	}
+
+	return true
+}
+
+func (s *shortcuts) press(chord shortcutChord, now time.Time) {
+	s.active = &chord
+	s.activeSince = now
+	s.held = false
+	s.suppressed |= chord.keys
+
+	if s.fire(chord, shortcutRepeat) {
+		s.nextRepeat = now.Add(s.holdDuration)
+	}
+}
+
+func (s *shortcuts) release(now time.Time) {
+	chord := *s.active
+	s.active = nil
+
+	if s.held || s.find(chord, shortcutRepeat) != nil {
+		return
+	}
+
+	if s.find(chord, shortcutDoubleTap) == nil {
+		s.fire(chord, shortcutTap)
+		return
+	}
+
+	// Wait for a second tap
+
+	if s.lastTap != nil && *s.lastTap == chord && now.Sub(s.lastTapTime) <= s.doubleTapInterval {
+		s.lastTap = nil
+		s.fire(chord, shortcutDoubleTap)
+		return
+	}
+
+	s.lastTap = &chord
+	s.lastTapTime = now
+}
+
+// reset forgets the held keys and the active chord, e.g. after reconnecting
+//
+func (s *shortcuts) reset() {
+	s.input = 0
+	s.sent = 0
+	s.pending = nil
+	s.forwarded = false
+	s.suppressed = 0
+	s.active = nil
+	s.held = false
+	s.lastTap = nil
+}
+
+// run handles time-based gestures and the chord window
+//
+func (s *shortcuts) run(now time.Time) {
+	if len(s.pending) > 0 && now.Sub(s.pendingSince) >= s.chordWindow {
+		s.sendPending()
+		s.forwarded = true
+	}
+
+	if s.active != nil {
+		chord := *s.active
+		held := now.Sub(s.activeSince)
+
+		if !s.held && held >= s.holdDuration && s.find(chord, shortcutHold) != nil {
+			s.held = true
+			s.fire(chord, shortcutHold)
+		}
+
+		if s.find(chord, shortcutRepeat) != nil {
+			for !now.Before(s.nextRepeat) {
+				s.fire(chord, shortcutRepeat)
+				s.nextRepeat = s.nextRepeat.Add(s.repeatInterval)
+			}
+		}
+	}
+
+	if s.lastTap != nil && now.Sub(s.lastTapTime) > s.doubleTapInterval {
+		chord := *s.lastTap
+		s.lastTap = nil
+		s.fire(chord, shortcutTap)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseShortcut(t *testing.T) {
+
+	t.Run("M8 keys", func(t *testing.T) {
+		result, err := parseShortcut("SHIFT+OPT=screenshot")
+		require.NoError(t, err)
+		require.Equal(t,
+			shortcut{
+				chord:   shortcutChord{keys: keySelect | keyOpt},
+				gesture: shortcutTap,
+				action:  "screenshot",
+			},
+			result,
+		)
+	})
+
+	t.Run("keyboard key and gesture", func(t *testing.T) {
+		result, err := parseShortcut("shift+opt+s:double=macro:F5")
+		require.NoError(t, err)
+		require.Equal(t,
+			shortcut{
+				chord:   shortcutChord{keys: keySelect | keyOpt, key: "S"},
+				gesture: shortcutDoubleTap,
+				action:  "macro:F5",
+			},
+			result,
+		)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{
+			"SHIFT+OPT",
+			"SHIFT+OPT=",
+			"SHIFT+OPT:long=screenshot",
+			"SHIFT++OPT=screenshot",
+			"S+T=screenshot",
+		} {
+			_, err := parseShortcut(s)
+			require.Error(t, err, s)
+		}
+	})
+}
+
+type shortcutsTest struct {
+	shortcuts *shortcuts
+	sent      []uint8
+	actions   []string
+	now       time.Time
+}
+
+func newShortcutsTest(t *testing.T, definitions ...string) *shortcutsTest {
+	test := &shortcutsTest{
+		now: time.Now(),
+	}
+
+	var parsed []shortcut
+	for _, definition := range definitions {
+		shortcut, err := parseShortcut(definition)
+		require.NoError(t, err)
+		parsed = append(parsed, shortcut)
+	}
+
+	test.shortcuts = newShortcuts(
+		parsed,
+		200*time.Millisecond,
+		500*time.Millisecond,
+		300*time.Millisecond,
+		100*time.Millisecond,
+		func(controller uint8) {
+			test.sent = append(test.sent, controller)
+		},
+		func(action string) {
+			test.actions = append(test.actions, action)
+		},
+	)
+
+	return test
+}
+
+func (test *shortcutsTest) wait(duration time.Duration) {
+	test.now = test.now.Add(duration)
+	test.shortcuts.run(test.now)
+}
+
+func (test *shortcutsTest) input(controller uint8) {
+	test.shortcuts.filter(controller, test.now)
+}
+
+func TestShortcuts(t *testing.T) {
+
+	t.Run("other keys are sent, repeated states are dropped", func(t *testing.T) {
+		test := newShortcutsTest(t, "SHIFT+OPT=screenshot")
+
+		test.input(keyUp)
+		test.input(keyUp)
+		test.input(0)
+
+		require.Equal(t, []uint8{keyUp, 0}, test.sent)
+		require.Empty(t, test.actions)
+	})
+
+	t.Run("tap does not leak to the M8", func(t *testing.T) {
+		test := newShortcutsTest(t, "SHIFT+OPT=screenshot")
+
+		test.input(keySelect)
+		test.wait(50 * time.Millisecond)
+		test.input(keySelect | keyOpt)
+		test.wait(50 * time.Millisecond)
+		test.input(keySelect)
+		test.input(0)
+		test.wait(time.Second)
+
+		require.Empty(t, test.sent)
+		require.Equal(t, []string{"screenshot"}, test.actions)
+	})
+
+	t.Run("chord start held alone is sent after the chord window", func(t *testing.T) {
+		test := newShortcutsTest(t, "SHIFT+OPT=screenshot")
+
+		test.input(keySelect)
+		test.wait(100 * time.Millisecond)
+		require.Empty(t, test.sent)
+
+		test.wait(100 * time.Millisecond)
+		require.Equal(t, []uint8{keySelect}, test.sent)
+
+		// The chord is not completed, as its start was already sent
+		test.input(keySelect | keyOpt)
+		test.input(keySelect)
+		test.input(0)
+		test.wait(time.Second)
+
+		require.Equal(t, []uint8{keySelect, keySelect | keyOpt, keySelect, 0}, test.sent)
+		require.Empty(t, test.actions)
+
+		// Once all keys are released, chords are completed again
+		test.input(keySelect)
+		test.input(keySelect | keyOpt)
+		test.input(0)
+		test.wait(time.Second)
+
+		require.Len(t, test.sent, 4)
+		require.Equal(t, []string{"screenshot"}, test.actions)
+	})
+
+	t.Run("chord start is dropped when the window loses focus", func(t *testing.T) {
+		test := newShortcutsTest(t, "SHIFT+OPT=screenshot")
+
+		test.input(keySelect)
+		test.wait(50 * time.Millisecond)
+
+		// See input.focusLost
+		test.shortcuts.dropPending()
+		test.input(0)
+		test.wait(time.Second)
+
+		require.Empty(t, test.sent)
+		require.Empty(t, test.actions)
+	})
+
+	t.Run("chord start is sent when another key is pressed", func(t *testing.T) {
+		test := newShortcutsTest(t, "SHIFT+OPT=screenshot")
+
+		test.input(keySelect)
+		test.input(keySelect | keyUp)
+
+		require.Equal(t, []uint8{keySelect, keySelect | keyUp}, test.sent)
+	})
+
+	t.Run("chord start is sent when released", func(t *testing.T) {
+		test := newShortcutsTest(t, "SHIFT+OPT+UP=screenshot")
+
+		test.input(keySelect)
+		test.input(keySelect | keyOpt)
+		test.input(keySelect)
+		test.wait(100 * time.Millisecond)
+		require.Empty(t, test.sent)
+
+		test.input(0)
+
+		require.Equal(t, []uint8{keySelect, keySelect | keyOpt, keySelect, 0}, test.sent)
+		require.Empty(t, test.actions)
+	})
+
+	t.Run("keyboard key completes chord", func(t *testing.T) {
+		test := newShortcutsTest(t, "SHIFT+OPT+S=screenshot")
+
+		require.False(t, test.shortcuts.handleKey("S", true, false))
+
+		test.input(keySelect)
+		test.input(keySelect | keyOpt)
+		require.True(t, test.shortcuts.handleKey("S", true, false))
+		require.True(t, test.shortcuts.handleKey("S", true, true))
+		require.True(t, test.shortcuts.handleKey("S", false, false))
+		test.input(keySelect)
+		test.input(0)
+
+		require.Empty(t, test.sent)
+		require.Equal(t, []string{"screenshot"}, test.actions)
+	})
+
+	t.Run("double tap", func(t *testing.T) {
+		test := newShortcutsTest(t,
+			"SHIFT+OPT=screenshot",
+			"SHIFT+OPT:double=fullscreen",
+		)
+
+		test.input(keySelect | keyOpt)
+		test.input(0)
+		test.wait(100 * time.Millisecond)
+		require.Empty(t, test.actions)
+
+		test.input(keySelect | keyOpt)
+		test.input(0)
+		test.wait(time.Second)
+		require.Equal(t, []string{"fullscreen"}, test.actions)
+
+		// A single tap fires after the double tap interval
+
+		test.input(keySelect | keyOpt)
+		test.input(0)
+		test.wait(100 * time.Millisecond)
+		require.Equal(t, []string{"fullscreen"}, test.actions)
+
+		test.wait(time.Second)
+		require.Equal(t, []string{"fullscreen", "screenshot"}, test.actions)
+		require.Empty(t, test.sent)
+	})
+
+	t.Run("hold", func(t *testing.T) {
+		test := newShortcutsTest(t,
+			"SHIFT+OPT=screenshot",
+			"SHIFT+OPT:hold=reset-display",
+		)
+
+		test.input(keySelect | keyOpt)
+		test.wait(400 * time.Millisecond)
+		require.Empty(t, test.actions)
+
+		test.wait(100 * time.Millisecond)
+		test.wait(100 * time.Millisecond)
+		require.Equal(t, []string{"reset-display"}, test.actions)
+
+		test.input(0)
+		test.wait(time.Second)
+		require.Equal(t, []string{"reset-display"}, test.actions)
+	})
+
+	t.Run("repeat", func(t *testing.T) {
+		test := newShortcutsTest(t, "SHIFT+OPT:repeat=macro:F5")
+
+		test.input(keySelect | keyOpt)
+		require.Len(t, test.actions, 1)
+
+		test.wait(400 * time.Millisecond)
+		require.Len(t, test.actions, 1)
+
+		test.wait(100 * time.Millisecond)
+		require.Len(t, test.actions, 2)
+
+		test.wait(250 * time.Millisecond)
+		require.Len(t, test.actions, 4)
+
+		test.input(0)
+		test.wait(time.Second)
+		require.Len(t, test.actions, 4)
+		require.Empty(t, test.sent)
+	})
+
+	t.Run("chord keys are sent again once released", func(t *testing.T) {
+		test := newShortcutsTest(t, "SHIFT+OPT=screenshot")
+
+		test.input(keySelect | keyOpt)
+		test.input(keyOpt)
+		test.input(keyOpt | keyUp)
+		test.input(keyUp)
+		test.input(keyUp | keyOpt)
+
+		require.Equal(t, []uint8{keyUp, keyUp | keyOpt}, test.sent)
+	})
+}
@@ -9,43 +9,73 @@ const slipEsc = 0xDB
 const slipEscEnd = 0xDC
 const slipEscEsc = 0xDD
 
-func decodeSLIP(data []byte, handle func(packet []byte)) (rest []byte, err error) {
-	var packet []byte
+// slipError is returned when frames with invalid escapes were dropped
+//
+type slipError struct {
+	frames int
+}
+
+func (e slipError) Error() string {
+	return fmt.Sprintf("SLIP protocol error: dropped %d invalid frames", e.frames)
+}
 
-	escaped := false
+// slipDecoder decodes a SLIP stream, which may be split into arbitrary chunks.
+// The state is kept between chunks, so frames and escapes may span chunks.
+// A frame with an invalid escape is dropped, and decoding resynchronizes at the next END
+//
+type slipDecoder struct {
+	packet  []byte
+	escaped bool
+	// dropping is true if the current frame is invalid, and bytes are ignored until the next END
+	dropping bool
+}
 
-	var index, lastEndIndex int
-	var b byte
+// decode decodes the given chunk, and calls the given handler for each complete packet.
+// It returns a slipError if frames were dropped
+//
+func (d *slipDecoder) decode(data []byte, handle func(packet []byte)) error {
+	var dropped int
 
-	for index, b = range data {
-		switch b {
-		case slipEnd:
-			lastEndIndex = index + 1
-			if len(packet) > 0 {
-				handle(packet)
-				packet = nil
+	for _, b := range data {
+		if b == slipEnd {
+			if !d.dropping && len(d.packet) > 0 {
+				handle(d.packet)
 			}
+			d.packet = nil
+			d.escaped = false
+			d.dropping = false
 			continue
-		case slipEsc:
-			escaped = true
+		}
+
+		if d.dropping {
 			continue
-		case slipEscEnd:
-			if escaped {
+		}
+
+		if d.escaped {
+			d.escaped = false
+
+			switch b {
+			case slipEscEnd:
 				b = slipEnd
-				escaped = false
-			}
-		case slipEscEsc:
-			if escaped {
+			case slipEscEsc:
 				b = slipEsc
-				escaped = false
-			}
-		default:
-			if escaped {
-				return data, fmt.Errorf("SLIP protocol error")
+			default:
+				d.packet = nil
+				d.dropping = true
+				dropped++
+				continue
 			}
+		} else if b == slipEsc {
+			d.escaped = true
+			continue
 		}
-		packet = append(packet, b)
+
+		d.packet = append(d.packet, b)
+	}
+
+	if dropped > 0 {
+		return slipError{frames: dropped}
 	}
 
-	return data[lastEndIndex:], nil
+	return nil
 }
@@ -8,54 +8,123 @@ import (
 
 func TestDecodeSLIP(t *testing.T) {
 
-	t.Run("no packets -> rest", func(t *testing.T) {
-		packets, rest, err := decodeSLIP([]byte{
+	decode := func(decoder *slipDecoder, data []byte) ([][]byte, error) {
+		var packets [][]byte
+		err := decoder.decode(data, func(packet []byte) {
+			packets = append(packets, packet)
+		})
+		return packets, err
+	}
+
+	t.Run("incomplete packet", func(t *testing.T) {
+		var decoder slipDecoder
+
+		packets, err := decode(&decoder, []byte{
 			0xA, 0xB,
 		})
 
 		require.NoError(t, err)
 		require.Empty(t, packets)
-		require.Equal(t, []byte{0xA, 0xB}, rest)
+
+		packets, err = decode(&decoder, []byte{
+			0xC, slipEnd,
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{{0xA, 0xB, 0xC}}, packets)
 	})
 
-	t.Run("multiple packets and rest", func(t *testing.T) {
-		packets, rest, err := decodeSLIP([]byte{
+	t.Run("multiple packets", func(t *testing.T) {
+		var decoder slipDecoder
+
+		packets, err := decode(&decoder, []byte{
 			0xA, 0xB, slipEnd,
 			0xC, 0xD, slipEnd,
+			slipEnd,
 			0xE, 0xF,
 		})
 
 		require.NoError(t, err)
 		require.Equal(t, [][]byte{{0xA, 0xB}, {0xC, 0xD}}, packets)
-		require.Equal(t, []byte{0xE, 0xF}, rest)
 	})
 
 	t.Run("escaped end", func(t *testing.T) {
-		packets, rest, err := decodeSLIP([]byte{
+		var decoder slipDecoder
+
+		packets, err := decode(&decoder, []byte{
 			0xA, 0xB, slipEsc, slipEscEnd, 0xC, 0xD, slipEnd,
 		})
 
 		require.NoError(t, err)
 		require.Equal(t, [][]byte{{0xA, 0xB, slipEnd, 0xC, 0xD}}, packets)
-		require.Empty(t, rest)
 	})
 
 	t.Run("escaped escape", func(t *testing.T) {
-		packets, rest, err := decodeSLIP([]byte{
+		var decoder slipDecoder
+
+		packets, err := decode(&decoder, []byte{
 			0xA, 0xB, slipEsc, slipEscEsc, 0xC, 0xD, slipEnd,
 		})
 
 		require.NoError(t, err)
 		require.Equal(t, [][]byte{{0xA, 0xB, slipEsc, 0xC, 0xD}}, packets)
-		require.Empty(t, rest)
 	})
 
-	t.Run("escaped other", func(t *testing.T) {
-		_, _, err := decodeSLIP([]byte{
-			0xA, 0xB, slipEsc, 0xC, 0xD, slipEnd,
+	t.Run("escape at the end of a chunk", func(t *testing.T) {
+		var decoder slipDecoder
+
+		packets, err := decode(&decoder, []byte{
+			0xA, 0xB, slipEsc,
+		})
+
+		require.NoError(t, err)
+		require.Empty(t, packets)
+
+		packets, err = decode(&decoder, []byte{
+			slipEscEnd, 0xC, slipEnd,
 		})
 
-		require.Error(t, err)
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{{0xA, 0xB, slipEnd, 0xC}}, packets)
 	})
 
+	t.Run("escaped other, resynchronizes at the next end", func(t *testing.T) {
+		var decoder slipDecoder
+
+		packets, err := decode(&decoder, []byte{
+			0x1, slipEnd,
+			0xA, 0xB, slipEsc, 0xC, 0xD, slipEsc, slipEscEnd, slipEnd,
+			0xE, slipEnd,
+			slipEsc, slipEsc, slipEnd,
+			0xF,
+		})
+
+		require.Equal(t, slipError{frames: 2}, err)
+		require.Equal(t, [][]byte{{0x1}, {0xE}}, packets)
+
+		// The valid frame after the resynchronization, which started with 0xF,
+		// continues in the next chunk, and is decoded completely
+		packets, err = decode(&decoder, []byte{
+			0x10, slipEnd,
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{{0xF, 0x10}}, packets)
+	})
+
+	t.Run("invalid frame spanning chunks", func(t *testing.T) {
+		var decoder slipDecoder
+
+		_, err := decode(&decoder, []byte{
+			0xA, slipEsc, 0xB,
+		})
+		require.Equal(t, slipError{frames: 1}, err)
+
+		packets, err := decode(&decoder, []byte{
+			0xC, slipEnd, 0xD, slipEnd,
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{{0xD}}, packets)
+	})
 }
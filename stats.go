@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// statsInterval is the interval over which rates are computed
+const statsInterval = time.Second
+
+// statsCounters are the counters of a time interval
+//
+type statsCounters struct {
+	packets        map[string]int
+	bytes          int
+	slipErrors     int
+	decodeErrors   int
+	renderedFrames int
+	skippedFrames  int
+	latencyTotal   time.Duration
+	latencyMax     time.Duration
+}
+
+func newStatsCounters() statsCounters {
+	return statsCounters{
+		packets: map[string]int{},
+	}
+}
+
+func (c statsCounters) latencyAverage() time.Duration {
+	if c.renderedFrames == 0 {
+		return 0
+	}
+	return c.latencyTotal / time.Duration(c.renderedFrames)
+}
+
+// stats collects statistics about the serial link, decoding, and rendering
+//
+type stats struct {
+	// current are the counters of the current interval
+	current statsCounters
+	// last are the counters of the last complete interval
+	last  statsCounters
+	start time.Time
+	// firstRead is the time the oldest packet which is not rendered yet was read
+	firstRead time.Time
+	// totals are the exported metrics, if enabled
+	totals *metrics
+}
+
+func newStats(now time.Time) *stats {
+	return &stats{
+		current: newStatsCounters(),
+		last:    newStatsCounters(),
+		start:   now,
+	}
+}
+
+// read records that the given number of bytes were read
+//
+func (s *stats) read(n int) {
+	s.current.bytes += n
+
+	if s.totals != nil {
+		s.totals.read(n)
+	}
+}
+
+// packet records a packet, which was decoded into the given command,
+// or failed to decode with the given error
+//
+func (s *stats) packet(command Command, err error, now time.Time) {
+	var kind string
+
+	switch command.(type) {
+	case DrawRectangleCommand:
+		kind = inspectRectangle
+	case DrawCharacterCommand:
+		kind = inspectCharacter
+	case DrawOscilloscopeWaveformCommand:
+		kind = inspectWaveform
+	case JoypadKeyPressedStateCommand:
+		kind = inspectJoypad
+	}
+
+	if err != nil {
+		s.current.decodeErrors++
+		if _, ok := err.(unknownCommandError); ok {
+			kind = inspectUnknown
+		} else {
+			kind = inspectInvalid
+		}
+	}
+
+	s.current.packets[kind]++
+
+	if s.totals != nil {
+		s.totals.packet(kind, err)
+	}
+
+	if s.firstRead.IsZero() {
+		s.firstRead = now
+	}
+}
+
+func (s *stats) slipError() {
+	s.current.slipErrors++
+
+	if s.totals != nil {
+		s.totals.slipError()
+	}
+}
+
+func (s *stats) skipped() {
+	s.current.skippedFrames++
+
+	if s.totals != nil {
+		s.totals.dropped()
+	}
+}
+
+// rendered records that a frame was presented,
+// and the latency from reading the oldest packet in it
+//
+func (s *stats) rendered(now time.Time) {
+	s.current.renderedFrames++
+
+	if s.totals != nil {
+		s.totals.rendered()
+	}
+
+	if s.firstRead.IsZero() {
+		return
+	}
+
+	latency := now.Sub(s.firstRead)
+	s.firstRead = time.Time{}
+
+	s.current.latencyTotal += latency
+	if latency > s.current.latencyMax {
+		s.current.latencyMax = latency
+	}
+}
+
+// update starts a new interval if the current one is complete,
+// and returns true if it did
+//
+func (s *stats) update(now time.Time) bool {
+	if now.Sub(s.start) < statsInterval {
+		return false
+	}
+
+	s.last = s.current
+	s.current = newStatsCounters()
+	s.start = now
+
+	return true
+}
+
+// lines returns the statistics of the last interval, for the overlay
+//
+func (s *stats) lines() []string {
+	c := s.last
+
+	return []string{
+		fmt.Sprintf(
+			"PKT/S RECT %d CHAR %d WAVE %d JOY %d UNK %d INV %d  KB/S %.1f",
+			c.packets[inspectRectangle],
+			c.packets[inspectCharacter],
+			c.packets[inspectWaveform],
+			c.packets[inspectJoypad],
+			c.packets[inspectUnknown],
+			c.packets[inspectInvalid],
+			float64(c.bytes)/1024,
+		),
+		fmt.Sprintf(
+			"ERR SLIP %d DECODE %d  FPS %d SKIP %d  LAT %.1f/%.1f MS",
+			c.slipErrors,
+			c.decodeErrors,
+			c.renderedFrames,
+			c.skippedFrames,
+			float64(c.latencyAverage())/float64(time.Millisecond),
+			float64(c.latencyMax)/float64(time.Millisecond),
+		),
+	}
+}
+
+// summary returns the statistics of the last interval, for the log
+//
+func (s *stats) summary() string {
+	return strings.ToLower(strings.Join(s.lines(), ", "))
+}
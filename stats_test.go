@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+
+	start := time.Now()
+	statistics := newStats(start)
+
+	statistics.read(100)
+	statistics.packet(DrawCharacterCommand{}, nil, start)
+	statistics.packet(DrawCharacterCommand{}, nil, start)
+	statistics.packet(DrawRectangleCommand{}, nil, start)
+	statistics.packet(nil, unknownCommandError{0xAB}, start)
+	statistics.packet(nil, errors.New("invalid"), start)
+	statistics.slipError()
+	statistics.skipped()
+	statistics.rendered(start.Add(4 * time.Millisecond))
+
+	statistics.read(2048)
+	statistics.packet(DrawOscilloscopeWaveformCommand{}, nil, start.Add(10*time.Millisecond))
+	statistics.rendered(start.Add(12 * time.Millisecond))
+
+	// Nothing was read since the last render
+	statistics.rendered(start.Add(20 * time.Millisecond))
+
+	require.False(t, statistics.update(start.Add(500*time.Millisecond)))
+	require.True(t, statistics.update(start.Add(time.Second)))
+
+	require.Equal(t,
+		[]string{
+			"PKT/S RECT 1 CHAR 2 WAVE 1 JOY 0 UNK 1 INV 1  KB/S 2.1",
+			"ERR SLIP 1 DECODE 2  FPS 3 SKIP 1  LAT 2.0/4.0 MS",
+		},
+		statistics.lines(),
+	)
+
+	require.Equal(t,
+		"pkt/s rect 1 char 2 wave 1 joy 0 unk 1 inv 1  kb/s 2.1, err slip 1 decode 2  fps 3 skip 1  lat 2.0/4.0 ms",
+		statistics.summary(),
+	)
+
+	// The next interval starts empty
+
+	require.True(t, statistics.update(start.Add(2*time.Second)))
+	require.Equal(t,
+		"ERR SLIP 0 DECODE 0  FPS 0 SKIP 0  LAT 0.0/0.0 MS",
+		statistics.lines()[1],
+	)
+}
+
+func TestOverlayText(t *testing.T) {
+
+	var pixels [overlayCharHeight + overlayPadding][2 * overlayCharWidth]bool
+
+	drawOverlayText([]string{"1a"}, func(x, y int) {
+		pixels[y][x] = true
+	})
+
+	var rows []string
+	for _, row := range pixels {
+		var s string
+		for _, set := range row {
+			if set {
+				s += "#"
+			} else {
+				s += "."
+			}
+		}
+		rows = append(rows, s)
+	}
+
+	require.Equal(t,
+		[]string{
+			"........",
+			"..#...#.",
+			".##..#.#",
+			"..#..###",
+			"..#..#.#",
+			".###.#.#",
+			"........",
+		},
+		rows,
+	)
+
+	require.Equal(t, 0, overlayHeight(0))
+	require.Equal(t, 13, overlayHeight(2))
+}
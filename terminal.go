@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// The terminal renderer draws the screen as a grid of character cells,
+// each covering fontCharWidth x fontCharHeight pixels of the M8 screen.
+//
+// Cells containing a character are drawn as that character.
+// All other cells are drawn as upper half blocks,
+// with the foreground color being the top half of the cell,
+// and the background color being the bottom half of the cell.
+// The oscilloscope is drawn with braille patterns.
+
+// waveformRows is the number of rows covered by the oscilloscope
+const waveformRows = (screenHeight/10 + fontCharHeight - 1) / fontCharHeight
+
+const upperHalfBlock = '▀'
+const brailleBase = 0x2800
+
+type terminalCell struct {
+	r          rune
+	foreground Color
+	background Color
+}
+
+type terminalRenderer struct {
+	writer      *bufio.Writer
+	framebuffer *framebuffer
+	screen      *textScreen
+	waveform    DrawOscilloscopeWaveformCommand
+	cells       [textRows][textColumns]terminalCell
+	// drawn is false if the whole screen needs to be redrawn
+	drawn bool
+}
+
+// newTerminalRenderer returns a new terminal renderer,
+// which draws the characters of the given text screen.
+// The text screen must be updated with the commands before they are drawn
+//
+func newTerminalRenderer(w io.Writer, screen *textScreen) *terminalRenderer {
+	r := &terminalRenderer{
+		writer:      bufio.NewWriter(w),
+		framebuffer: newFramebuffer(),
+		screen:      screen,
+	}
+
+	// Switch to the alternate screen, clear it, and hide the cursor
+	_, _ = r.writer.WriteString("\x1b[?1049h\x1b[2J\x1b[?25l")
+	_ = r.writer.Flush()
+
+	return r
+}
+
+func (r *terminalRenderer) quit() {
+	// Reset attributes, show the cursor, and switch back to the main screen
+	_, _ = r.writer.WriteString("\x1b[0m\x1b[?25h\x1b[?1049l")
+	_ = r.writer.Flush()
+}
+
+func (r *terminalRenderer) toggleFullscreen() {
+	// The terminal is always "fullscreen",
+	// but the display gets reset, so redraw everything
+	r.drawn = false
+}
+
+func (r *terminalRenderer) draw(command Command) {
+	r.framebuffer.draw(command)
+
+	if command, ok := command.(DrawOscilloscopeWaveformCommand); ok {
+		r.waveform = command
+	}
+}
+
+func (r *terminalRenderer) render() {
+	for row := range r.cells {
+		// The column the cursor is at after the last written cell
+		next := -1
+
+		for column := range r.cells[row] {
+			cell := r.cell(row, column)
+			if r.drawn && cell == r.cells[row][column] {
+				continue
+			}
+			r.cells[row][column] = cell
+
+			if column != next {
+				_, _ = fmt.Fprintf(r.writer, "\x1b[%d;%dH", row+1, column+1)
+			}
+
+			_, _ = fmt.Fprintf(
+				r.writer,
+				"\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm%c",
+				cell.foreground.r, cell.foreground.g, cell.foreground.b,
+				cell.background.r, cell.background.g, cell.background.b,
+				cell.r,
+			)
+
+			next = column + 1
+		}
+	}
+
+	r.drawn = true
+
+	_ = r.writer.Flush()
+}
+
+// cell determines how the cell at the given row and column is drawn
+//
+func (r *terminalRenderer) cell(row, column int) terminalCell {
+	x := column * fontCharWidth
+	y := row * fontCharHeight
+
+	if character := r.screen.cell(row, column); !character.empty() {
+		background := character.background
+		if background == character.foreground {
+			background = r.framebuffer.at(x+fontCharWidth/2, y+fontCharHeight/2)
+		}
+		return terminalCell{
+			r:          glyphRune(character.c),
+			foreground: character.foreground,
+			background: background,
+		}
+	}
+
+	if row < waveformRows && len(r.waveform.waveform) != 0 {
+		if pattern := r.braille(x, y); pattern != 0 {
+			return terminalCell{
+				r:          brailleBase + rune(pattern),
+				foreground: r.waveform.color,
+				background: r.framebuffer.backgroundColor,
+			}
+		}
+	}
+
+	return terminalCell{
+		r:          upperHalfBlock,
+		foreground: r.framebuffer.at(x+fontCharWidth/2, y+fontCharHeight/4),
+		background: r.framebuffer.at(x+fontCharWidth/2, y+fontCharHeight*3/4),
+	}
+}
+
+// brailleDots are the bits of the braille pattern dots,
+// indexed by row and column
+var brailleDots = [4][2]byte{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// braille returns the braille pattern for the waveform
+// in the cell with the given top-left pixel position.
+// Each of the 2x4 dots covers a quarter of the cell's width and height
+//
+func (r *terminalRenderer) braille(x, y int) byte {
+	const dotWidth = fontCharWidth / 2
+	const dotHeight = fontCharHeight / 4
+
+	var pattern byte
+
+	for dx := 0; dx < fontCharWidth; dx++ {
+		i := x + dx
+		if i >= len(r.waveform.waveform) {
+			break
+		}
+
+		dy := int(r.waveform.waveform[i]) - y
+		if dy < 0 || dy >= fontCharHeight {
+			continue
+		}
+
+		pattern |= brailleDots[dy/dotHeight][dx/dotWidth]
+	}
+
+	return pattern
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Terminals only report key presses, not key releases,
+// so pressed keys are released after this duration,
+// unless the terminal repeats the key press
+//
+const terminalKeyHoldDuration = 150 * time.Millisecond
+
+const (
+	terminalEscape = 0x1b
+	terminalCtrlC  = 0x03
+	terminalCtrlL  = 0x0c
+)
+
+type terminalKey struct {
+	keys   uint8
+	quit   bool
+	redraw bool
+	// name is the name of the key, if it is a printable character
+	name string
+}
+
+type terminalInput struct {
+	controller input
+	keyHandler keyHandler
+	data       chan []byte
+	// releases are the times at which each of the keys gets released
+	releases [8]time.Time
+}
+
+func newTerminalInput(r io.Reader, keyHandler keyHandler) *terminalInput {
+	t := &terminalInput{
+		keyHandler: keyHandler,
+		data:       make(chan []byte, 16),
+	}
+
+	go func() {
+		defer close(t.data)
+
+		buf := make([]byte, 256)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				data := make([]byte, n)
+				copy(data, buf[:n])
+				t.data <- data
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return t
+}
+
+func (t *terminalInput) handle(
+	toggleFullscreen func(),
+	sendController func(uint8),
+) bool {
+	now := time.Now()
+
+	select {
+	case data, ok := <-t.data:
+		if !ok {
+			return false
+		}
+
+		for _, key := range parseTerminalKeys(data) {
+			// Terminals do not report key releases, so release immediately
+
+			if key.name != "" &&
+				t.keyHandler != nil &&
+				t.keyHandler(key.name, true, false) {
+
+				t.keyHandler(key.name, false, false)
+				continue
+			}
+
+			switch {
+			case key.quit:
+				return false
+
+			case key.redraw:
+				toggleFullscreen()
+
+			case key.keys != 0:
+				t.controller.update(key.keys, true, sendController)
+
+				for bit := range t.releases {
+					if key.keys&(1<<bit) != 0 {
+						t.releases[bit] = now.Add(terminalKeyHoldDuration)
+					}
+				}
+			}
+		}
+
+	default:
+	}
+
+	var released uint8
+	for bit, release := range t.releases {
+		if t.controller.input&(1<<bit) != 0 && now.After(release) {
+			released |= 1 << bit
+		}
+	}
+
+	if released != 0 {
+		t.controller.update(released, false, sendController)
+	}
+
+	return true
+}
+
+func (t *terminalInput) reset() {
+	t.controller.reset()
+}
+
+// parseTerminalKeys parses the key presses in the given terminal input.
+//
+// Arrow keys are mapped to the directional keys.
+// When they are modified, Shift adds SELECT, Alt adds OPT, and Ctrl adds EDIT,
+// just like the corresponding keys do in the window.
+//
+func parseTerminalKeys(data []byte) []terminalKey {
+	var keys []terminalKey
+
+	for len(data) > 0 {
+		b := data[0]
+		data = data[1:]
+
+		if b == terminalEscape && len(data) >= 2 && (data[0] == '[' || data[0] == 'O') {
+			// CSI or SS3 sequence: parameters, followed by a final byte
+
+			end := 1
+			for end < len(data) && data[end] >= 0x30 && data[end] <= 0x3f {
+				end++
+			}
+			if end == len(data) {
+				break
+			}
+
+			key := terminalArrowKey(data[end])
+			if key != 0 {
+				key |= terminalModifierKeys(data[1:end])
+				keys = append(keys, terminalKey{keys: key})
+			}
+
+			data = data[end+1:]
+			continue
+		}
+
+		switch b {
+		case 'q', terminalCtrlC:
+			keys = append(keys, terminalKey{quit: true})
+
+		case terminalCtrlL:
+			keys = append(keys, terminalKey{redraw: true})
+
+		default:
+			var name string
+			if b > ' ' && b <= '~' {
+				name = strings.ToUpper(string(b))
+			}
+
+			var key uint8
+
+			if b >= 'A' && b <= 'Z' {
+				key = keySelect
+				b += 'a' - 'A'
+			}
+
+			switch b {
+			case 'x', 'm':
+				key |= keyEdit
+			case 'z', 'n':
+				key |= keyOpt
+			case ' ':
+				key |= keyStart
+			case '\t':
+				key |= keySelect
+			default:
+				key = 0
+			}
+
+			if key != 0 || name != "" {
+				keys = append(keys, terminalKey{
+					keys: key,
+					name: name,
+				})
+			}
+		}
+	}
+
+	return keys
+}
+
+func terminalArrowKey(final byte) uint8 {
+	switch final {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	default:
+		return 0
+	}
+}
+
+// terminalModifierKeys returns the keys for the modifier
+// in the given parameters of a CSI sequence, e.g. "1;2"
+//
+func terminalModifierKeys(parameters []byte) uint8 {
+	index := bytes.LastIndexByte(parameters, ';')
+	if index < 0 {
+		return 0
+	}
+
+	modifier, err := strconv.Atoi(string(parameters[index+1:]))
+	if err != nil {
+		return 0
+	}
+
+	// The modifier parameter is 1 + a bitmask of Shift (1), Alt (2), Ctrl (4)
+	modifier--
+	if modifier <= 0 {
+		return 0
+	}
+
+	var keys uint8
+	if modifier&1 != 0 {
+		keys |= keySelect
+	}
+	if modifier&2 != 0 {
+		keys |= keyOpt
+	}
+	if modifier&4 != 0 {
+		keys |= keyEdit
+	}
+	return keys
+}
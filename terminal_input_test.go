@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTerminalKeys(t *testing.T) {
+
+	t.Run("arrows", func(t *testing.T) {
+		keys := parseTerminalKeys([]byte("\x1b[A\x1b[B\x1bOC\x1bOD"))
+		require.Equal(t,
+			[]terminalKey{
+				{keys: keyUp},
+				{keys: keyDown},
+				{keys: keyRight},
+				{keys: keyLeft},
+			},
+			keys,
+		)
+	})
+
+	t.Run("modified arrows", func(t *testing.T) {
+		keys := parseTerminalKeys([]byte("\x1b[1;2A\x1b[1;3B\x1b[1;5C\x1b[1;4D"))
+		require.Equal(t,
+			[]terminalKey{
+				{keys: keySelect | keyUp},
+				{keys: keyOpt | keyDown},
+				{keys: keyEdit | keyRight},
+				{keys: keySelect | keyOpt | keyLeft},
+			},
+			keys,
+		)
+	})
+
+	t.Run("letters", func(t *testing.T) {
+		keys := parseTerminalKeys([]byte("xzX \tb"))
+		require.Equal(t,
+			[]terminalKey{
+				{keys: keyEdit, name: "X"},
+				{keys: keyOpt, name: "Z"},
+				{keys: keySelect | keyEdit, name: "X"},
+				{keys: keyStart},
+				{keys: keySelect},
+				{name: "B"},
+			},
+			keys,
+		)
+	})
+
+	t.Run("quit and redraw", func(t *testing.T) {
+		keys := parseTerminalKeys([]byte{'q', terminalCtrlL, terminalCtrlC})
+		require.Equal(t,
+			[]terminalKey{
+				{quit: true},
+				{redraw: true},
+				{quit: true},
+			},
+			keys,
+		)
+	})
+
+	t.Run("incomplete sequence", func(t *testing.T) {
+		keys := parseTerminalKeys([]byte("x\x1b[1;"))
+		require.Equal(t,
+			[]terminalKey{
+				{keys: keyEdit, name: "X"},
+			},
+			keys,
+		)
+	})
+}
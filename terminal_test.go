@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTerminalRendererTest() (*terminalRenderer, *textScreen, *bytes.Buffer) {
+	var buf bytes.Buffer
+	screen := newTextScreen()
+	r := newTerminalRenderer(&buf, screen)
+	buf.Reset()
+	return r, screen, &buf
+}
+
+func drawTerminalTest(r *terminalRenderer, screen *textScreen, command Command) {
+	screen.update(command)
+	r.draw(command)
+}
+
+func TestTerminalRenderer(t *testing.T) {
+
+	red := Color{r: 0xff}
+	green := Color{g: 0xff}
+	blue := Color{b: 0xff}
+
+	t.Run("half blocks", func(t *testing.T) {
+		r, screen, _ := newTerminalRendererTest()
+
+		// The top half of the cell at row 5, column 3 is red, the bottom half is blue
+		drawTerminalTest(r, screen, DrawRectangleCommand{
+			pos:   Position{x: 3 * fontCharWidth, y: 5 * fontCharHeight},
+			size:  Size{width: fontCharWidth, height: fontCharHeight / 2},
+			color: red,
+		})
+		drawTerminalTest(r, screen, DrawRectangleCommand{
+			pos:   Position{x: 3 * fontCharWidth, y: 5*fontCharHeight + fontCharHeight/2},
+			size:  Size{width: fontCharWidth, height: fontCharHeight / 2},
+			color: blue,
+		})
+
+		require.Equal(t,
+			terminalCell{r: upperHalfBlock, foreground: red, background: blue},
+			r.cell(5, 3),
+		)
+		require.Equal(t,
+			terminalCell{r: upperHalfBlock},
+			r.cell(5, 4),
+		)
+	})
+
+	t.Run("characters", func(t *testing.T) {
+		r, screen, _ := newTerminalRendererTest()
+
+		drawTerminalTest(r, screen, DrawRectangleCommand{
+			pos:   Position{x: 0, y: 0},
+			size:  Size{width: screenWidth, height: screenHeight},
+			color: blue,
+		})
+		drawTerminalTest(r, screen, DrawCharacterCommand{
+			c:          'A',
+			pos:        Position{x: 2 * fontCharWidth, y: 6 * fontCharHeight},
+			foreground: red,
+			background: green,
+		})
+		// Without a background, the color behind the character is used
+		drawTerminalTest(r, screen, DrawCharacterCommand{
+			c:          '.',
+			pos:        Position{x: 3 * fontCharWidth, y: 6 * fontCharHeight},
+			foreground: red,
+			background: red,
+		})
+
+		require.Equal(t,
+			terminalCell{r: 'A', foreground: red, background: green},
+			r.cell(6, 2),
+		)
+		require.Equal(t,
+			terminalCell{r: '.', foreground: red, background: blue},
+			r.cell(6, 3),
+		)
+	})
+
+	t.Run("braille scope", func(t *testing.T) {
+		r, screen, _ := newTerminalRendererTest()
+
+		waveform := make([]byte, screenWidth)
+		for i := range waveform {
+			// Outside of the first row of cells
+			waveform[i] = fontCharHeight
+		}
+		// Top-left dot
+		waveform[0] = 0
+		// Second dot of the left column
+		waveform[1] = 2
+		// Bottom-right dot
+		waveform[4] = 7
+
+		drawTerminalTest(r, screen, DrawOscilloscopeWaveformCommand{
+			color:    green,
+			waveform: waveform,
+		})
+
+		require.Equal(t,
+			terminalCell{r: brailleBase + 0x01 + 0x02 + 0x80, foreground: green},
+			r.cell(0, 0),
+		)
+		// The second row of cells has a dot in the top row of each column
+		require.Equal(t,
+			terminalCell{r: brailleBase + 0x01 + 0x08, foreground: green},
+			r.cell(1, 1),
+		)
+	})
+
+	t.Run("SGR output", func(t *testing.T) {
+		r, screen, buf := newTerminalRendererTest()
+
+		r.render()
+
+		// All cells are drawn, moving the cursor to the start of each row
+		output := buf.String()
+		cell := fmt.Sprintf("\x1b[38;2;0;0;0m\x1b[48;2;0;0;0m%c", upperHalfBlock)
+		require.Equal(t, textRows*textColumns, strings.Count(output, cell))
+		require.Equal(t, textRows, strings.Count(output, "H"))
+		require.True(t, strings.HasPrefix(output, "\x1b[1;1H"+cell+cell))
+
+		// Nothing changed, so nothing is drawn
+
+		buf.Reset()
+		r.render()
+		require.Empty(t, buf.String())
+
+		// Only the changed cell is drawn
+
+		drawTerminalTest(r, screen, DrawCharacterCommand{
+			c:          'A',
+			pos:        Position{x: 2 * fontCharWidth, y: 6 * fontCharHeight},
+			foreground: red,
+			background: green,
+		})
+
+		buf.Reset()
+		r.render()
+		require.Equal(t,
+			"\x1b[7;3H\x1b[38;2;255;0;0m\x1b[48;2;0;255;0mA"+
+				// The background of the character extends into the top half of the cell below
+				fmt.Sprintf("\x1b[8;3H\x1b[38;2;0;255;0m\x1b[48;2;0;0;0m%c", upperHalfBlock),
+			buf.String(),
+		)
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"strings"
+)
+
+// The text screen is a grid of character cells,
+// each covering fontCharWidth x fontCharHeight pixels of the M8 screen,
+// reconstructed from the draw character commands.
+
+const textColumns = screenWidth / fontCharWidth
+const textRows = screenHeight / fontCharHeight
+
+// textCell is a character cell of the text screen.
+// An empty cell has no character
+//
+type textCell struct {
+	c          byte
+	pos        Position
+	foreground Color
+	background Color
+}
+
+func (c textCell) empty() bool {
+	return c.c == 0
+}
+
+// textScreen
+//
+type textScreen struct {
+	backgroundColor Color
+	cells           [textRows][textColumns]textCell
+	// fills are the colors of the rectangles behind the cells
+	fills [textRows][textColumns]Color
+}
+
+func newTextScreen() *textScreen {
+	return &textScreen{}
+}
+
+// textCellIndex returns the row and column of the cell
+// for the character drawn at the given position
+//
+func textCellIndex(pos Position) (row, column int, ok bool) {
+	if pos.x < 0 || pos.y < 0 {
+		return 0, 0, false
+	}
+
+	row = int(pos.y) / fontCharHeight
+	column = int(pos.x) / fontCharWidth
+
+	if row >= textRows || column >= textColumns {
+		return 0, 0, false
+	}
+
+	return row, column, true
+}
+
+func (s *textScreen) update(command Command) {
+	switch command := command.(type) {
+	case DrawCharacterCommand:
+		row, column, ok := textCellIndex(command.pos)
+		if !ok {
+			return
+		}
+
+		s.cells[row][column] = textCell{
+			c:          command.c,
+			pos:        command.pos,
+			foreground: command.foreground,
+			background: command.background,
+		}
+
+	case DrawRectangleCommand:
+		if command.pos.x == 0 &&
+			command.pos.y == 0 &&
+			command.size.width == screenWidth &&
+			command.size.height == screenHeight {
+
+			s.backgroundColor = command.color
+		}
+
+		s.fill(command)
+	}
+}
+
+// fill removes all characters which are covered by the given rectangle,
+// and remembers its color as the fill of the covered cells.
+// A full-screen rectangle clears the whole screen
+//
+func (s *textScreen) fill(command DrawRectangleCommand) {
+	for row := range s.cells {
+		for column, cell := range s.cells[row] {
+			pos := cell.pos
+			if cell.empty() {
+				pos = Position{
+					x: int16(column * fontCharWidth),
+					y: int16(row * fontCharHeight),
+				}
+			}
+
+			// Use the center of the glyph
+			x := pos.x + fontCharWidth/2
+			y := pos.y + 3 + fontCharHeight/2
+
+			if x >= command.pos.x &&
+				y >= command.pos.y &&
+				x < command.pos.x+command.size.width &&
+				y < command.pos.y+command.size.height {
+
+				s.cells[row][column] = textCell{}
+				s.fills[row][column] = command.color
+			}
+		}
+	}
+}
+
+// cell returns the cell at the given row and column
+//
+func (s *textScreen) cell(row, column int) textCell {
+	return s.cells[row][column]
+}
+
+// background returns the color behind the character
+// of the cell at the given row and column
+//
+func (s *textScreen) background(row, column int) Color {
+	cell := s.cells[row][column]
+	if !cell.empty() && cell.background != cell.foreground {
+		return cell.background
+	}
+	return s.fills[row][column]
+}
+
+// line returns the text of the given row,
+// with trailing spaces removed
+//
+func (s *textScreen) line(row int) string {
+	var builder strings.Builder
+	for _, cell := range s.cells[row] {
+		builder.WriteRune(textCellRune(cell))
+	}
+	return strings.TrimRight(builder.String(), " ")
+}
+
+// lines returns the text of all rows
+//
+func (s *textScreen) lines() []string {
+	lines := make([]string, textRows)
+	for row := range lines {
+		lines[row] = s.line(row)
+	}
+	return lines
+}
+
+// String returns the text of the whole screen,
+// one line per row
+//
+func (s *textScreen) String() string {
+	return strings.Join(s.lines(), "\n")
+}
+
+// contains returns true if the given text is displayed in any row
+//
+func (s *textScreen) contains(text string) bool {
+	for row := range s.cells {
+		if strings.Contains(s.line(row), text) {
+			return true
+		}
+	}
+	return false
+}
+
+func textCellRune(cell textCell) rune {
+	if cell.empty() {
+		return ' '
+	}
+	return glyphRune(cell.c)
+}
+
+// glyphRune returns the rune for the given M8 font character
+//
+func glyphRune(c byte) rune {
+	if c < ' ' || c > '~' {
+		return ' '
+	}
+	return rune(c)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func drawText(screen *textScreen, x, y int16, text string) {
+	for i, c := range []byte(text) {
+		screen.update(DrawCharacterCommand{
+			c: c,
+			pos: Position{
+				x: x + int16(i*fontCharWidth),
+				y: y,
+			},
+			foreground: Color{r: 0xff, g: 0xff, b: 0xff},
+			background: Color{r: 0xff, g: 0xff, b: 0xff},
+		})
+	}
+}
+
+func TestTextScreen(t *testing.T) {
+
+	t.Run("characters", func(t *testing.T) {
+		screen := newTextScreen()
+		drawText(screen, 0, 0, "SONG")
+		drawText(screen, 16, 20, "00 01")
+
+		require.Equal(t, "SONG", screen.line(0))
+		require.Equal(t, "  00 01", screen.line(2))
+		require.True(t, screen.contains("SONG"))
+		require.False(t, screen.contains("PHRASE"))
+
+		cell := screen.cell(2, 2)
+		require.Equal(t, byte('0'), cell.c)
+		require.Equal(t, Color{r: 0xff, g: 0xff, b: 0xff}, cell.foreground)
+	})
+
+	t.Run("overwrite", func(t *testing.T) {
+		screen := newTextScreen()
+		drawText(screen, 0, 0, "SONG")
+		drawText(screen, 0, 0, "CHAIN")
+
+		require.Equal(t, "CHAIN", screen.line(0))
+	})
+
+	t.Run("full-screen rectangle clears", func(t *testing.T) {
+		screen := newTextScreen()
+		drawText(screen, 0, 0, "SONG")
+		screen.update(DrawRectangleCommand{
+			size: Size{
+				width:  screenWidth,
+				height: screenHeight,
+			},
+		})
+
+		for row := 0; row < textRows; row++ {
+			require.Empty(t, screen.line(row))
+		}
+	})
+
+	t.Run("rectangle clears covered characters", func(t *testing.T) {
+		screen := newTextScreen()
+		drawText(screen, 0, 0, "ABCD")
+		screen.update(DrawRectangleCommand{
+			pos: Position{
+				x: 8,
+				y: 0,
+			},
+			size: Size{
+				width:  16,
+				height: 10,
+			},
+		})
+
+		require.Equal(t, "A  D", screen.line(0))
+	})
+
+	t.Run("out of bounds", func(t *testing.T) {
+		screen := newTextScreen()
+		drawText(screen, -8, 0, "A")
+		drawText(screen, 0, screenHeight, "B")
+
+		require.NotContains(t, screen.String(), "A")
+		require.NotContains(t, screen.String(), "B")
+	})
+}
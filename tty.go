@@ -0,0 +1,45 @@
+package main
+
+// #include <termios.h>
+// #include <unistd.h>
+import "C"
+
+import (
+	"log"
+	"os"
+)
+
+type ttyState struct {
+	fd       C.int
+	settings C.struct_termios
+}
+
+// makeRaw puts the given terminal into raw mode,
+// and returns its previous state, so it can be restored
+//
+func makeRaw(f *os.File) *ttyState {
+	fd := C.int(f.Fd())
+	if C.isatty(fd) != 1 {
+		log.Fatalf("not a TTY: %s", f.Name())
+	}
+
+	state := &ttyState{fd: fd}
+
+	_, err := C.tcgetattr(fd, &state.settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	settings := state.settings
+	C.cfmakeraw(&settings)
+	_, err = C.tcsetattr(fd, C.TCSANOW, &settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return state
+}
+
+func (s *ttyState) restore() {
+	_, _ = C.tcsetattr(s.fd, C.TCSANOW, &s.settings)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// videoWriter writes the frames of a video
+//
+type videoWriter interface {
+	writeFrame(frame *image.RGBA) error
+	close() error
+}
+
+// renderVideoFrames draws the received packets with the headless renderer,
+// and calls the given function with the screen at each frame of a video with the given frame rate,
+// scaled by the given factor. The frames follow the times of the packets in the capture
+//
+func renderVideoFrames(records []captureRecord, rate int, scale int, fn func(frame *image.RGBA) error) (int, error) {
+	if rate < 1 {
+		return 0, errors.New("invalid frame rate")
+	}
+
+	f := newFramebuffer()
+
+	r, err := newReplay(records, f.draw)
+	if err != nil {
+		return 0, err
+	}
+
+	// All packets of the last frame are drawn
+	frames := int(r.duration()*time.Duration(rate)/time.Second) + 1
+
+	for frame := 0; frame < frames; frame++ {
+		position := time.Duration(frame) * time.Second / time.Duration(rate)
+
+		for r.index < len(r.packets) && r.packets[r.index].time <= position {
+			r.apply()
+		}
+
+		err = fn(f.scaledImage(scale))
+		if err != nil {
+			return frame, err
+		}
+	}
+
+	return frames, nil
+}
+
+// ffmpegVideoWriter pipes the frames as raw RGBA into an ffmpeg process,
+// which encodes them into the given file, in the format of its extension
+//
+type ffmpegVideoWriter struct {
+	command *exec.Cmd
+	input   io.WriteCloser
+}
+
+// videoAudio is an audio recording, which starts at the given offset from the start of the video
+//
+type videoAudio struct {
+	path   string
+	offset time.Duration
+}
+
+func ffmpegArguments(path string, width, height int, rate int, audio *videoAudio) []string {
+	arguments := []string{
+		"-loglevel", "error",
+		"-y",
+		"-f", "rawvideo",
+		"-pixel_format", "rgba",
+		"-video_size", fmt.Sprintf("%dx%d", width, height),
+		"-framerate", strconv.Itoa(rate),
+		"-i", "-",
+	}
+
+	if audio != nil {
+		arguments = append(arguments,
+			"-itsoffset", strconv.FormatFloat(audio.offset.Seconds(), 'f', 6, 64),
+			"-i", audio.path,
+			"-map", "0:v",
+			"-map", "1:a",
+		)
+	}
+
+	return append(arguments,
+		// Most players only support 4:2:0 chroma subsampling
+		"-pix_fmt", "yuv420p",
+		path,
+	)
+}
+
+func newFFmpegVideoWriter(ffmpegPath string, path string, width, height int, rate int, audio *videoAudio) (*ffmpegVideoWriter, error) {
+	command := exec.Command(ffmpegPath, ffmpegArguments(path, width, height, rate, audio)...)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+
+	input, err := command.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = command.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ffmpegVideoWriter{
+		command: command,
+		input:   input,
+	}, nil
+}
+
+func (w *ffmpegVideoWriter) writeFrame(frame *image.RGBA) error {
+	_, err := w.input.Write(frame.Pix)
+	return err
+}
+
+func (w *ffmpegVideoWriter) close() error {
+	err := w.input.Close()
+	if err != nil {
+		return err
+	}
+	return w.command.Wait()
+}
+
+// y4mVideoWriter writes uncompressed YUV4MPEG2 video, with full range 4:4:4 YCbCr frames
+//
+type y4mVideoWriter struct {
+	writer *bufio.Writer
+	closer io.Closer
+	planes []byte
+}
+
+func newY4MVideoWriter(w io.WriteCloser, width, height int, rate int) (*y4mVideoWriter, error) {
+	writer := bufio.NewWriter(w)
+
+	_, err := fmt.Fprintf(
+		writer,
+		"YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C444 XCOLORRANGE=FULL\n",
+		width,
+		height,
+		rate,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &y4mVideoWriter{
+		writer: writer,
+		closer: w,
+		planes: make([]byte, width*height*3),
+	}, nil
+}
+
+func (w *y4mVideoWriter) writeFrame(frame *image.RGBA) error {
+	bounds := frame.Bounds()
+	size := bounds.Dx() * bounds.Dy()
+
+	if len(w.planes) != size*3 {
+		return errors.New("invalid frame size")
+	}
+
+	yPlane := w.planes[:size]
+	cbPlane := w.planes[size : size*2]
+	crPlane := w.planes[size*2:]
+
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		offset := frame.PixOffset(bounds.Min.X, y)
+		for x := 0; x < bounds.Dx(); x++ {
+			pixel := frame.Pix[offset+x*4 : offset+x*4+3]
+			yPlane[i], cbPlane[i], crPlane[i] = color.RGBToYCbCr(pixel[0], pixel[1], pixel[2])
+			i++
+		}
+	}
+
+	_, err := w.writer.WriteString("FRAME\n")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.writer.Write(w.planes)
+	return err
+}
+
+func (w *y4mVideoWriter) close() error {
+	err := w.writer.Flush()
+	if err != nil {
+		return err
+	}
+	return w.closer.Close()
+}
+
+// renderVideo renders the capture into a video file.
+// Y4M files are written directly, all other formats are encoded with ffmpeg.
+// If ffmpeg is not available, a Y4M file is written instead, and its path is returned.
+//
+// If an audio recording is given, it is muxed into the video by ffmpeg,
+// aligned by the start times of the capture and the recording
+//
+func renderVideo(
+	start time.Time,
+	records []captureRecord,
+	path string,
+	rate int,
+	scale int,
+	ffmpegPath string,
+	audioPath string,
+) (string, int, error) {
+	if rate < 1 {
+		return path, 0, errors.New("invalid frame rate")
+	}
+
+	if scale < 1 {
+		scale = 1
+	}
+
+	width := screenWidth * scale
+	height := screenHeight * scale
+
+	var audio *videoAudio
+	if audioPath != "" {
+		var err error
+		audio, err = openVideoAudio(audioPath, start)
+		if err != nil {
+			return path, 0, err
+		}
+	}
+
+	extension := filepath.Ext(path)
+
+	if !strings.EqualFold(extension, ".y4m") {
+		_, err := exec.LookPath(ffmpegPath)
+		if err != nil {
+			if audio != nil {
+				return path, 0, fmt.Errorf("%s is needed to add the audio: %w", ffmpegPath, err)
+			}
+
+			y4mPath := strings.TrimSuffix(path, extension) + ".y4m"
+			log.Printf("%s not found, writing uncompressed Y4M to %s instead", ffmpegPath, y4mPath)
+			path = y4mPath
+		}
+	}
+
+	var writer videoWriter
+
+	if strings.EqualFold(filepath.Ext(path), ".y4m") {
+		if audio != nil {
+			return path, 0, errors.New("Y4M files have no audio")
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			return path, 0, err
+		}
+
+		writer, err = newY4MVideoWriter(file, width, height, rate)
+		if err != nil {
+			_ = file.Close()
+			return path, 0, err
+		}
+	} else {
+		var err error
+		writer, err = newFFmpegVideoWriter(ffmpegPath, path, width, height, rate, audio)
+		if err != nil {
+			return path, 0, err
+		}
+	}
+
+	frames, err := renderVideoFrames(records, rate, scale, writer.writeFrame)
+	closeErr := writer.close()
+	if err != nil {
+		return path, frames, err
+	}
+
+	return path, frames, closeErr
+}
+
+// openVideoAudio reads the start time of the audio recording,
+// and returns its offset from the given start time of the video
+//
+func openVideoAudio(path string, start time.Time) (*videoAudio, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	audioStart, err := readWAVStart(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	audio := &videoAudio{
+		path: path,
+	}
+
+	if audioStart.IsZero() {
+		log.Printf("%s has no start time, the audio starts with the video", path)
+	} else {
+		audio.offset = audioStart.Sub(start)
+	}
+
+	return audio, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type closingBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closingBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRenderVideoFrames(t *testing.T) {
+
+	red := []byte{drawRectangleCommand, 0, 0, 0, 0, 0x40, 0x1, 0xF0, 0, 0xff, 0, 0}
+	green := []byte{drawRectangleCommand, 0, 0, 0, 0, 0x40, 0x1, 0xF0, 0, 0, 0xff, 0}
+
+	records := []captureRecord{
+		{kind: captureReceived, time: 0, data: red},
+		// Drawn in the third frame, at 100ms
+		{kind: captureReceived, time: 60 * time.Millisecond, data: green},
+		{kind: captureReceived, time: 150 * time.Millisecond, data: red},
+	}
+
+	var colors []Color
+	var bounds []image.Rectangle
+
+	frames, err := renderVideoFrames(records, 20, 2, func(frame *image.RGBA) error {
+		c := frame.RGBAAt(0, 0)
+		colors = append(colors, Color{r: c.R, g: c.G, b: c.B})
+		bounds = append(bounds, frame.Bounds())
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The frames are 50ms apart, the last one at 150ms
+	require.Equal(t, 4, frames)
+	require.Equal(t,
+		[]Color{
+			{r: 0xff},
+			{r: 0xff},
+			{g: 0xff},
+			{r: 0xff},
+		},
+		colors,
+	)
+	require.Equal(t, image.Rect(0, 0, screenWidth*2, screenHeight*2), bounds[0])
+
+	_, err = renderVideoFrames(records, 0, 1, func(*image.RGBA) error { return nil })
+	require.Error(t, err)
+}
+
+func TestY4MVideoWriter(t *testing.T) {
+
+	var buf closingBuffer
+
+	w, err := newY4MVideoWriter(&buf, 2, 1, 30)
+	require.NoError(t, err)
+
+	frame := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	copy(frame.Pix, []byte{
+		0xff, 0xff, 0xff, 0xff,
+		0xff, 0, 0, 0xff,
+	})
+
+	require.NoError(t, w.writeFrame(frame))
+	require.NoError(t, w.writeFrame(frame))
+	require.Error(t, w.writeFrame(image.NewRGBA(image.Rect(0, 0, 1, 1))))
+	require.NoError(t, w.close())
+	require.True(t, buf.closed)
+
+	expectedFrame := "FRAME\n" +
+		// Y
+		"\xff\x4c" +
+		// Cb
+		"\x80\x55" +
+		// Cr
+		"\x80\xff"
+
+	require.Equal(t,
+		"YUV4MPEG2 W2 H1 F30:1 Ip A1:1 C444 XCOLORRANGE=FULL\n"+expectedFrame+expectedFrame,
+		buf.String(),
+	)
+}
+
+func TestFFmpegArguments(t *testing.T) {
+
+	require.Equal(t,
+		[]string{
+			"-loglevel", "error",
+			"-y",
+			"-f", "rawvideo",
+			"-pixel_format", "rgba",
+			"-video_size", "640x480",
+			"-framerate", "30",
+			"-i", "-",
+			"-itsoffset", "-0.250000",
+			"-i", "session.wav",
+			"-map", "0:v",
+			"-map", "1:a",
+			"-pix_fmt", "yuv420p",
+			"session.mp4",
+		},
+		ffmpegArguments("session.mp4", 640, 480, 30, &videoAudio{
+			path:   "session.wav",
+			offset: -250 * time.Millisecond,
+		}),
+	)
+}
+
+func TestRenderVideo(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "g0m8")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	records := replayRecords(t, 10)
+
+	t.Run("Y4M", func(t *testing.T) {
+		path, frames, err := renderVideo(time.Time{}, records, filepath.Join(dir, "test.y4m"), 10, 1, "ffmpeg", "")
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(dir, "test.y4m"), path)
+		require.Equal(t, 4, frames)
+
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+
+		header := len("YUV4MPEG2 W320 H240 F10:1 Ip A1:1 C444 XCOLORRANGE=FULL\n")
+		require.Equal(t, int64(header+frames*(len("FRAME\n")+screenWidth*screenHeight*3)), info.Size())
+	})
+
+	t.Run("without ffmpeg", func(t *testing.T) {
+		path, _, err := renderVideo(time.Time{}, records, filepath.Join(dir, "test.mp4"), 10, 1, filepath.Join(dir, "missing-ffmpeg"), "")
+		require.NoError(t, err)
+		require.Equal(t, filepath.Join(dir, "test.y4m"), path)
+	})
+
+	t.Run("audio without ffmpeg", func(t *testing.T) {
+		audioPath := filepath.Join(dir, "test.wav")
+		file, err := os.Create(audioPath)
+		require.NoError(t, err)
+		w, err := newWAVWriter(file, audioFrequency, audioChannels)
+		require.NoError(t, err)
+		require.NoError(t, w.close())
+		require.NoError(t, file.Close())
+
+		_, _, err = renderVideo(time.Time{}, records, filepath.Join(dir, "audio.mp4"), 10, 1, filepath.Join(dir, "missing-ffmpeg"), audioPath)
+		require.Error(t, err)
+
+		_, _, err = renderVideo(time.Time{}, records, filepath.Join(dir, "audio.y4m"), 10, 1, "ffmpeg", audioPath)
+		require.Error(t, err)
+	})
+}
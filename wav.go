@@ -0,0 +1,193 @@
+package main
+
+// # Audio recordings
+//
+// Audio recordings are 16-bit PCM WAV files, with an additional "g0m8" chunk,
+// which other programs ignore:
+//   int64: time of the first sample, in nanoseconds since the Unix epoch (little endian)
+//
+// Captures also have their start time, so the audio recording and the capture
+// of a session can be aligned when exporting a video.
+// Only the start is aligned: The sample clock of the M8 is not synchronized with the capture clock,
+// so long recordings might drift apart slightly towards the end
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const wavHeaderSize = 12
+const wavFormatChunkSize = 16
+const wavStartChunkSize = 8
+
+// wavStartOffset is the offset of the start time in the file
+const wavStartOffset = wavHeaderSize + 8 + wavFormatChunkSize + 8
+
+// wavDataOffset is the offset of the audio data in the file
+const wavDataOffset = wavStartOffset + wavStartChunkSize + 8
+
+var errInvalidWAV = errors.New("invalid WAV file")
+
+// wavWriter writes an audio recording. The sizes and the start time are written when it is closed
+//
+type wavWriter struct {
+	file      io.WriteSeeker
+	writer    *bufio.Writer
+	frameSize int
+	frequency int
+	dataSize  int64
+	start     time.Time
+}
+
+func newWAVWriter(file io.WriteSeeker, frequency int, channels int) (*wavWriter, error) {
+	w := &wavWriter{
+		file:      file,
+		writer:    bufio.NewWriter(file),
+		frameSize: channels * 2,
+		frequency: frequency,
+	}
+
+	header := make([]byte, wavDataOffset)
+
+	copy(header[0:], "RIFF")
+	copy(header[8:], "WAVE")
+
+	format := header[wavHeaderSize:]
+	copy(format[0:], "fmt ")
+	binary.LittleEndian.PutUint32(format[4:], wavFormatChunkSize)
+	// PCM
+	binary.LittleEndian.PutUint16(format[8:], 1)
+	binary.LittleEndian.PutUint16(format[10:], uint16(channels))
+	binary.LittleEndian.PutUint32(format[12:], uint32(frequency))
+	binary.LittleEndian.PutUint32(format[16:], uint32(frequency*w.frameSize))
+	binary.LittleEndian.PutUint16(format[20:], uint16(w.frameSize))
+	// Bits per sample
+	binary.LittleEndian.PutUint16(format[22:], 16)
+
+	copy(header[wavStartOffset-8:], "g0m8")
+	binary.LittleEndian.PutUint32(header[wavStartOffset-4:], wavStartChunkSize)
+
+	copy(header[wavDataOffset-8:], "data")
+
+	_, err := w.writer.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// write writes the given samples, which were received at the given time
+//
+func (w *wavWriter) write(samples []byte, now time.Time) error {
+	if w.start.IsZero() {
+		frames := len(samples) / w.frameSize
+		w.start = now.Add(-time.Duration(frames) * time.Second / time.Duration(w.frequency))
+	}
+
+	n, err := w.writer.Write(samples)
+	w.dataSize += int64(n)
+	return err
+}
+
+func (w *wavWriter) writeAt(offset int64, data []byte) error {
+	_, err := w.file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.file.Write(data)
+	return err
+}
+
+// close writes the sizes and the start time. It does not close the file
+//
+func (w *wavWriter) close() error {
+	err := w.writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	var buf [8]byte
+
+	binary.LittleEndian.PutUint32(buf[:], uint32(wavDataOffset-8+w.dataSize))
+	err = w.writeAt(4, buf[:4])
+	if err != nil {
+		return err
+	}
+
+	var start int64
+	if !w.start.IsZero() {
+		start = w.start.UnixNano()
+	}
+	binary.LittleEndian.PutUint64(buf[:], uint64(start))
+	err = w.writeAt(wavStartOffset, buf[:])
+	if err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(buf[:], uint32(w.dataSize))
+	err = w.writeAt(wavDataOffset-4, buf[:4])
+	if err != nil {
+		return err
+	}
+
+	_, err = w.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// readWAVStart returns the start time of the given audio recording,
+// or the zero time if it has none, e.g. because it was not written by g0m8
+//
+func readWAVStart(r io.Reader) (time.Time, error) {
+	var header [wavHeaderSize]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return time.Time{}, errInvalidWAV
+	}
+
+	for {
+		var chunkHeader [8]byte
+		_, err := io.ReadFull(r, chunkHeader[:])
+		if err == io.EOF {
+			return time.Time{}, nil
+		}
+		if err != nil {
+			return time.Time{}, errInvalidWAV
+		}
+
+		id := string(chunkHeader[:4])
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:]))
+
+		switch id {
+		case "g0m8":
+			if size < wavStartChunkSize {
+				return time.Time{}, errInvalidWAV
+			}
+
+			var start int64
+			err = binary.Read(r, binary.LittleEndian, &start)
+			if err != nil {
+				return time.Time{}, errInvalidWAV
+			}
+			if start == 0 {
+				return time.Time{}, nil
+			}
+			return time.Unix(0, start), nil
+
+		case "data":
+			// The start chunk is before the data
+			return time.Time{}, nil
+		}
+
+		// Chunks are padded to an even size
+		_, err = io.CopyN(ioutil.Discard, r, size+size%2)
+		if err != nil {
+			return time.Time{}, errInvalidWAV
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWAVWriter(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "g0m8")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.wav")
+
+	file, err := os.Create(path)
+	require.NoError(t, err)
+
+	w, err := newWAVWriter(file, audioFrequency, audioChannels)
+	require.NoError(t, err)
+
+	// The first samples were captured 441 frames, i.e. 10ms, before they were received
+	now := time.Unix(1600000000, 0)
+	first := make([]byte, 441*audioFrameSize)
+	for i := range first {
+		first[i] = byte(i)
+	}
+	second := []byte{1, 2, 3, 4}
+
+	require.NoError(t, w.write(first, now))
+	require.NoError(t, w.write(second, now.Add(time.Second)))
+	require.NoError(t, w.close())
+	require.NoError(t, file.Close())
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	dataSize := len(first) + len(second)
+	require.Len(t, data, wavDataOffset+dataSize)
+
+	require.Equal(t, "RIFF", string(data[0:4]))
+	require.Equal(t, uint32(len(data)-8), binary.LittleEndian.Uint32(data[4:]))
+	require.Equal(t, "WAVE", string(data[8:12]))
+
+	require.Equal(t, "fmt ", string(data[12:16]))
+	// PCM
+	require.Equal(t, uint16(1), binary.LittleEndian.Uint16(data[20:]))
+	require.Equal(t, uint16(audioChannels), binary.LittleEndian.Uint16(data[22:]))
+	require.Equal(t, uint32(audioFrequency), binary.LittleEndian.Uint32(data[24:]))
+	require.Equal(t, uint32(audioFrequency*audioFrameSize), binary.LittleEndian.Uint32(data[28:]))
+	require.Equal(t, uint16(audioFrameSize), binary.LittleEndian.Uint16(data[32:]))
+	require.Equal(t, uint16(16), binary.LittleEndian.Uint16(data[34:]))
+
+	require.Equal(t, "data", string(data[wavDataOffset-8:wavDataOffset-4]))
+	require.Equal(t, uint32(dataSize), binary.LittleEndian.Uint32(data[wavDataOffset-4:]))
+	require.Equal(t, append(first, second...), data[wavDataOffset:])
+
+	reader, err := os.Open(path)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	start, err := readWAVStart(reader)
+	require.NoError(t, err)
+	require.True(t, now.Add(-10*time.Millisecond).Equal(start))
+}
+
+func TestReadWAVStart(t *testing.T) {
+
+	t.Run("without start", func(t *testing.T) {
+		header := make([]byte, 44)
+		copy(header[0:], "RIFF")
+		copy(header[8:], "WAVE")
+		copy(header[12:], "fmt ")
+		binary.LittleEndian.PutUint32(header[16:], 16)
+		copy(header[36:], "data")
+
+		start, err := readWAVStart(strings.NewReader(string(header)))
+		require.NoError(t, err)
+		require.True(t, start.IsZero())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := readWAVStart(strings.NewReader("RIFF\x00\x00\x00\x00AVI "))
+		require.Equal(t, errInvalidWAV, err)
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// waveformHeight is the height of the oscilloscope strip at the top of the M8 screen
+const waveformHeight = screenHeight / 10
+
+// waveformStyle is how the oscilloscope waveform is drawn
+//
+type waveformStyle string
+
+const (
+	// waveformPoints draws one point per sample, like the M8
+	waveformPoints waveformStyle = "points"
+	// waveformLines connects the samples
+	waveformLines waveformStyle = "lines"
+	// waveformFilled fills the area between the samples and the center
+	waveformFilled waveformStyle = "filled"
+	// waveformThick connects the samples with lines which are one pixel thicker on each side
+	waveformThick waveformStyle = "thick"
+)
+
+func parseWaveformStyle(s string) (waveformStyle, error) {
+	style := waveformStyle(strings.ToLower(s))
+	switch style {
+	case waveformPoints, waveformLines, waveformFilled, waveformThick:
+		return style, nil
+	}
+	return "", fmt.Errorf("invalid waveform style %s, expected points, lines, filled, or thick", s)
+}
+
+// waveformSpan is a vertical run of pixels of a column of the waveform, from top to bottom inclusive
+//
+type waveformSpan struct {
+	x      int
+	top    int
+	bottom int
+}
+
+// appendWaveformSpans appends the spans which draw the given waveform in the given style.
+// Samples outside of the oscilloscope strip are clamped to it
+//
+func appendWaveformSpans(spans []waveformSpan, style waveformStyle, waveform []byte) []waveformSpan {
+	const center = waveformHeight / 2
+
+	clamp := func(y int) int {
+		if y < 0 {
+			return 0
+		}
+		if y >= waveformHeight {
+			return waveformHeight - 1
+		}
+		return y
+	}
+
+	for x, sample := range waveform {
+		y := clamp(int(sample))
+
+		span := waveformSpan{
+			x:      x,
+			top:    y,
+			bottom: y,
+		}
+
+		switch style {
+		case waveformLines, waveformThick:
+			// Connect to the previous sample
+			if x > 0 {
+				previous := clamp(int(waveform[x-1]))
+				if previous < span.top {
+					span.top = previous
+				} else if previous > span.bottom {
+					span.bottom = previous
+				}
+			}
+
+			if style == waveformThick {
+				span.top = clamp(span.top - 1)
+				span.bottom = clamp(span.bottom + 1)
+			}
+
+		case waveformFilled:
+			if center < span.top {
+				span.top = center
+			} else if center > span.bottom {
+				span.bottom = center
+			}
+		}
+
+		spans = append(spans, span)
+	}
+
+	return spans
+}
+
+// waveformTrail keeps the most recent waveforms, for drawing a persistence trail
+//
+type waveformTrail struct {
+	length int
+	// frames are the waveforms, from the oldest to the most recent
+	frames [][]byte
+}
+
+// newWaveformTrail returns a trail of the given number of frames, including the most recent
+//
+func newWaveformTrail(length int) *waveformTrail {
+	if length < 1 {
+		length = 1
+	}
+	return &waveformTrail{
+		length: length,
+	}
+}
+
+func (t *waveformTrail) push(waveform []byte) {
+	var frame []byte
+	if len(t.frames) == t.length {
+		// Reuse the oldest frame
+		frame = t.frames[0]
+		copy(t.frames, t.frames[1:])
+		t.frames = t.frames[:len(t.frames)-1]
+	}
+
+	frame = append(frame[:0], waveform...)
+	t.frames = append(t.frames, frame)
+}
+
+func (t *waveformTrail) clear() {
+	t.frames = t.frames[:0]
+}
+
+// each calls the given function for each frame of the trail, from the oldest to the most recent,
+// with the brightness of the frame, from above 0 to 1 for the most recent
+//
+func (t *waveformTrail) each(f func(waveform []byte, brightness float64)) {
+	for i, frame := range t.frames {
+		f(frame, float64(i+1)/float64(len(t.frames)))
+	}
+}
+
+// fadeColor returns the given color faded towards the given background color,
+// with brightness 1 being the color, and 0 the background color
+//
+func fadeColor(background, color Color, brightness float64) Color {
+	mix := func(from, to uint8) uint8 {
+		return uint8(float64(from) + (float64(to)-float64(from))*brightness + 0.5)
+	}
+
+	return Color{
+		r: mix(background.r, color.r),
+		g: mix(background.g, color.g),
+		b: mix(background.b, color.b),
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWaveformStyle(t *testing.T) {
+	style, err := parseWaveformStyle("Thick")
+	require.NoError(t, err)
+	require.Equal(t, waveformThick, style)
+
+	_, err = parseWaveformStyle("dots")
+	require.Error(t, err)
+}
+
+func TestWaveformSpans(t *testing.T) {
+
+	waveform := []byte{5, 10, 8, 30}
+
+	t.Run("points", func(t *testing.T) {
+		require.Equal(t,
+			[]waveformSpan{
+				{x: 0, top: 5, bottom: 5},
+				{x: 1, top: 10, bottom: 10},
+				{x: 2, top: 8, bottom: 8},
+				// Clamped to the oscilloscope strip
+				{x: 3, top: 23, bottom: 23},
+			},
+			appendWaveformSpans(nil, waveformPoints, waveform),
+		)
+	})
+
+	t.Run("lines", func(t *testing.T) {
+		require.Equal(t,
+			[]waveformSpan{
+				{x: 0, top: 5, bottom: 5},
+				{x: 1, top: 5, bottom: 10},
+				{x: 2, top: 8, bottom: 10},
+				{x: 3, top: 8, bottom: 23},
+			},
+			appendWaveformSpans(nil, waveformLines, waveform),
+		)
+	})
+
+	t.Run("thick", func(t *testing.T) {
+		require.Equal(t,
+			[]waveformSpan{
+				{x: 0, top: 4, bottom: 6},
+				{x: 1, top: 4, bottom: 11},
+				{x: 2, top: 7, bottom: 11},
+				{x: 3, top: 7, bottom: 23},
+			},
+			appendWaveformSpans(nil, waveformThick, waveform),
+		)
+	})
+
+	t.Run("filled", func(t *testing.T) {
+		require.Equal(t,
+			[]waveformSpan{
+				{x: 0, top: 5, bottom: 12},
+				{x: 1, top: 10, bottom: 12},
+				{x: 2, top: 8, bottom: 12},
+				{x: 3, top: 12, bottom: 23},
+			},
+			appendWaveformSpans(nil, waveformFilled, waveform),
+		)
+	})
+}
+
+func TestWaveformRects(t *testing.T) {
+	spans := []waveformSpan{
+		{x: 0, top: 0, bottom: 0},
+		{x: 319, top: 12, bottom: 23},
+	}
+
+	rects := appendWaveformRects(nil, spans, screenWidth*3, waveformHeight*10)
+	require.Len(t, rects, 2)
+
+	require.Equal(t, int32(0), rects[0].X)
+	require.Equal(t, int32(0), rects[0].Y)
+	require.Equal(t, int32(3), rects[0].W)
+	require.Equal(t, int32(10), rects[0].H)
+
+	require.Equal(t, int32(957), rects[1].X)
+	require.Equal(t, int32(120), rects[1].Y)
+	require.Equal(t, int32(3), rects[1].W)
+	require.Equal(t, int32(120), rects[1].H)
+}
+
+func TestWaveformTrail(t *testing.T) {
+
+	type frame struct {
+		waveform   []byte
+		brightness float64
+	}
+
+	frames := func(trail *waveformTrail) []frame {
+		var result []frame
+		trail.each(func(waveform []byte, brightness float64) {
+			result = append(result, frame{
+				waveform:   append([]byte(nil), waveform...),
+				brightness: brightness,
+			})
+		})
+		return result
+	}
+
+	trail := newWaveformTrail(3)
+
+	trail.push([]byte{1})
+	require.Equal(t, []frame{{[]byte{1}, 1}}, frames(trail))
+
+	trail.push([]byte{2})
+	trail.push([]byte{3})
+	trail.push([]byte{4})
+	require.Equal(t,
+		[]frame{
+			{[]byte{2}, 1.0 / 3},
+			{[]byte{3}, 2.0 / 3},
+			{[]byte{4}, 1},
+		},
+		frames(trail),
+	)
+
+	trail.clear()
+	require.Empty(t, frames(trail))
+
+	// A trail of zero frames still has the most recent frame
+	trail = newWaveformTrail(0)
+	trail.push([]byte{1})
+	trail.push([]byte{2})
+	require.Equal(t, []frame{{[]byte{2}, 1}}, frames(trail))
+}
+
+func TestFadeColor(t *testing.T) {
+	background := Color{r: 0, g: 100, b: 200}
+	color := Color{r: 200, g: 100, b: 0}
+
+	require.Equal(t, color, fadeColor(background, color, 1))
+	require.Equal(t, background, fadeColor(background, color, 0))
+	require.Equal(t, Color{r: 100, g: 100, b: 100}, fadeColor(background, color, 0.5))
+}
@@ -9,8 +9,10 @@ package main
 // 'R' - Reset display command: No extra bytes following
 
 import (
+	"fmt"
+	"io"
 	"log"
-	"os"
+	"strings"
 )
 
 const (
@@ -24,47 +26,148 @@ const (
 	keyEdit   = 1
 )
 
+// keyNames are the names of the keys, as labeled on the M8
+var keyNames = map[string]uint8{
+	"LEFT":   keyLeft,
+	"UP":     keyUp,
+	"DOWN":   keyDown,
+	"SHIFT":  keySelect,
+	"SELECT": keySelect,
+	"PLAY":   keyStart,
+	"START":  keyStart,
+	"RIGHT":  keyRight,
+	"OPT":    keyOpt,
+	"OPTION": keyOpt,
+	"EDIT":   keyEdit,
+}
+
+// parseKeys returns the controller state for the given key names
+//
+func parseKeys(names []string) (uint8, error) {
+	var keys uint8
+	for _, name := range names {
+		key, ok := keyNames[strings.ToUpper(name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown key: %s", name)
+		}
+		keys |= key
+	}
+	return keys, nil
+}
+
 var sendControllerCommand = []byte{'C', 0}
 
-func sendController(port *os.File, controller byte) {
+func sendController(port io.Writer, controller byte) {
 	sendControllerCommand[1] = controller
 
 	n, err := port.Write(sendControllerCommand)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	if n != len(sendControllerCommand) {
-		log.Fatalf("failed to send controller: %016b", controller)
+		fatalf("failed to send controller: %016b", controller)
 	}
 }
 
 var enableAndResetDisplayCommand = []byte{'E', 'R'}
 
-func enableAndResetDisplay(port *os.File) {
+func enableAndResetDisplay(port io.Writer) {
 	log.Println("Enabling and resetting display ...")
 
 	n, err := port.Write(enableAndResetDisplayCommand)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	if n != len(enableAndResetDisplayCommand) {
-		log.Fatalln("failed to enable and reset display")
+		fatal("failed to enable and reset display")
 	}
 }
 
 var disconnectCommand = []byte{'D'}
 
-func disconnect(port *os.File) {
+// disconnect releases all keys, so none stay latched on the M8,
+// and disables the display
+//
+func disconnect(port io.Writer) {
 	log.Println("Disconnecting ...")
 
+	sendController(port, 0)
+
 	n, err := port.Write(disconnectCommand)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	if n != len(disconnectCommand) {
-		log.Fatalln("failed to disconnect")
+		fatal("failed to disconnect")
+	}
+}
+
+var sendNoteOnCommand = []byte{'K', 0, 0}
+
+// checkNote returns an error if the given note and velocity can not be sent as a keyjazz note.
+// Note 0 stops the note, and is not followed by a velocity,
+// so sending it with a velocity would put the M8 out of sync with the command stream
+//
+func checkNote(note int, velocity int) error {
+	if note < 1 || note > 127 {
+		return fmt.Errorf("invalid note: %d, expected 1 to 127", note)
+	}
+	if velocity < 0 || velocity > 127 {
+		return fmt.Errorf("invalid velocity: %d, expected 0 to 127", velocity)
+	}
+	return nil
+}
+
+func sendNoteOn(port io.Writer, note byte, velocity byte) {
+	err := checkNote(int(note), int(velocity))
+	if err != nil {
+		log.Printf("not sending note on: %s", err)
+		return
+	}
+
+	sendNoteOnCommand[1] = note
+	sendNoteOnCommand[2] = velocity
+
+	n, err := port.Write(sendNoteOnCommand)
+	if err != nil {
+		fatal(err)
+	}
+
+	if n != len(sendNoteOnCommand) {
+		fatalf("failed to send note on: %d %d", note, velocity)
+	}
+}
+
+var sendNoteOffCommand = []byte{'K', 0}
+
+func sendNoteOff(port io.Writer) {
+	n, err := port.Write(sendNoteOffCommand)
+	if err != nil {
+		fatal(err)
+	}
+
+	if n != len(sendNoteOffCommand) {
+		fatal("failed to send note off")
+	}
+}
+
+var sendThemeColorCommand = []byte{'S', 0, 0, 0, 0}
+
+func sendThemeColor(port io.Writer, index byte, color Color) {
+	sendThemeColorCommand[1] = index
+	sendThemeColorCommand[2] = color.r
+	sendThemeColorCommand[3] = color.g
+	sendThemeColorCommand[4] = color.b
+
+	n, err := port.Write(sendThemeColorCommand)
+	if err != nil {
+		fatal(err)
+	}
+
+	if n != len(sendThemeColorCommand) {
+		fatalf("failed to send theme color: %d", index)
 	}
 }